@@ -9,9 +9,17 @@ package ctxfmt
 type argstate struct {
 	idx  int
 	args []interface{}
+
+	// names holds the field names of the arguments. If names is set, named
+	// fields are bound by name and anonymous verbs only consume arguments
+	// without name.
+	names []string
 }
 
 func (a *argstate) next() (arg interface{}, idx int, has bool) {
+	for a.names != nil && a.idx < len(a.args) && a.names[a.idx] != "" {
+		a.idx++
+	}
 	if a.idx < len(a.args) {
 		arg, idx = a.args[a.idx], a.idx
 		a.idx++
@@ -19,3 +27,13 @@ func (a *argstate) next() (arg interface{}, idx int, has bool) {
 	}
 	return nil, len(a.args), false
 }
+
+// named returns the argument bound to the field name.
+func (a *argstate) named(name string) (arg interface{}, idx int, has bool) {
+	for i, n := range a.names {
+		if n == name {
+			return a.args[i], i, true
+		}
+	}
+	return nil, len(a.args), false
+}
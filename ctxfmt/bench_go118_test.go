@@ -0,0 +1,16 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+//go:build !go1.19
+// +build !go1.19
+
+package ctxfmt
+
+import "fmt"
+
+func fmtAppendf(b []byte, format string, args ...interface{}) []byte {
+	return append(b, fmt.Sprintf(format, args...)...)
+}
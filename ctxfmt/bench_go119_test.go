@@ -4,12 +4,13 @@
 //
 //        http://www.apache.org/licenses/LICENSE-2.0
 
-//+build go1.12
+//go:build go1.19
+// +build go1.19
 
 package ctxfmt
 
-import "reflect"
+import "fmt"
 
-func newMapIter(m reflect.Value) *reflect.MapIter {
-	return m.MapRange()
+func fmtAppendf(b []byte, format string, args ...interface{}) []byte {
+	return fmt.Appendf(b, format, args...)
 }
@@ -0,0 +1,114 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+package ctxfmt
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestAppendf(t *testing.T) {
+	var keys []string
+	cb := func(key string, idx int, val interface{}) {
+		keys = append(keys, key)
+	}
+
+	buf := []byte("prefix: ")
+	buf, rest := Appendf(buf, cb, "%{a} %5.2f %{b:q}", 1, 3.14159, "x", 2)
+	if want := `prefix: 1  3.14 "x"`; string(buf) != want {
+		t.Errorf("got <%s> want <%s>", buf, want)
+	}
+	if len(rest) != 1 || rest[0] != 2 {
+		t.Errorf("unexpected rest arguments: %v", rest)
+	}
+	if want := []string{"a", "b"}; fmt.Sprint(keys) != fmt.Sprint(want) {
+		t.Errorf("got keys %v want %v", keys, want)
+	}
+}
+
+func TestAppendfAllocs(t *testing.T) {
+	buf := make([]byte, 0, 1024)
+	i, s, f := 1234567, "hello", 3.5
+	args := []interface{}{i, s, f, true}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		buf, _ = Appendf(buf[:0], nil, "%d %{str} %5.2f %v", args...)
+	})
+	if allocs != 0 {
+		t.Errorf("Appendf allocated %v times, want 0", allocs)
+	}
+}
+
+var benchSink string
+
+var benchFormats = []struct {
+	name string
+	fmt  string
+	args []interface{}
+}{
+	{"string", "hello %s", []interface{}{"world"}},
+	{"int", "value %d", []interface{}{1234567}},
+	{"float", "value %5.2f", []interface{}{3.14159}},
+	{"mixed", "%s %d %v %x", []interface{}{"str", 42, true, 255}},
+	{"padded", "%-10s|%08d", []interface{}{"left", 42}},
+	{"error", "failed with %v", []interface{}{errors.New("oops")}},
+	{"struct", "%+v", []interface{}{struct {
+		A int
+		B string
+	}{1, "b"}}},
+}
+
+func BenchmarkAppendf(b *testing.B) {
+	for _, bench := range benchFormats {
+		bench := bench
+		b.Run(bench.name, func(b *testing.B) {
+			buf := make([]byte, 0, 1024)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				buf, _ = Appendf(buf[:0], nil, bench.fmt, bench.args...)
+			}
+		})
+	}
+}
+
+func BenchmarkFmtAppendf(b *testing.B) {
+	for _, bench := range benchFormats {
+		bench := bench
+		b.Run(bench.name, func(b *testing.B) {
+			buf := make([]byte, 0, 1024)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				buf = fmtAppendf(buf[:0], bench.fmt, bench.args...)
+			}
+		})
+	}
+}
+
+func BenchmarkSprintf(b *testing.B) {
+	for _, bench := range benchFormats {
+		bench := bench
+		b.Run(bench.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				benchSink, _ = Sprintf(nil, bench.fmt, bench.args...)
+			}
+		})
+	}
+}
+
+func BenchmarkFmtSprintf(b *testing.B) {
+	for _, bench := range benchFormats {
+		bench := bench
+		b.Run(bench.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				benchSink = fmt.Sprintf(bench.fmt, bench.args...)
+			}
+		})
+	}
+}
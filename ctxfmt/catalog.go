@@ -0,0 +1,290 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+package ctxfmt
+
+import (
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/urso/diag"
+)
+
+// Catalog maps message IDs to localized format strings.
+//
+// Arguments are passed to a catalog as diag.Field values or key value pairs
+// (see diag.Context.AddAll), and are bound to the field-specs in the format
+// string by field name. Translations can use a different order of fields
+// than the original message. A string is only used as key if the format
+// string has a field-spec of the same name. All other arguments are consumed
+// by anonymous verbs in order.
+//
+// The zero value is ready to use. A Catalog must not be modified while
+// messages are printed concurrently.
+type Catalog struct {
+	// Printer configures the formatting. The package defaults are used if
+	// Printer is nil.
+	Printer *Printer
+
+	// Fallback configures the locale to use if a message is not available in
+	// the requested locale.
+	Fallback string
+
+	// PluralRules maps a locale to a function selecting the plural category
+	// for a count. If no rule is configured the category "one" is used for
+	// 1, and "other" for all other counts.
+	PluralRules map[string]func(n float64) string
+
+	messages map[string]map[string]Message // locale -> message ID -> message
+}
+
+// Message is a localized message in a Catalog.
+type Message struct {
+	// Format is the format string of the message. It is used if no plural
+	// form matches.
+	Format string
+
+	// Plural names the numeric field used to select the format string from
+	// Forms.
+	Plural string
+
+	// Forms holds the format strings for the plural forms. A key can be an
+	// exact value like "=0", or a plural category like "one" or "other".
+	// Exact values are checked first.
+	Forms map[string]string
+}
+
+// Set adds the format string for the message ID in the given locale.
+func (c *Catalog) Set(locale, id, format string) {
+	c.SetMessage(locale, id, Message{Format: format})
+}
+
+// SetMessage adds a message, possibly with plural forms, for the message ID
+// in the given locale.
+func (c *Catalog) SetMessage(locale, id string, msg Message) {
+	if c.messages == nil {
+		c.messages = map[string]map[string]Message{}
+	}
+
+	msgs := c.messages[locale]
+	if msgs == nil {
+		msgs = map[string]Message{}
+		c.messages[locale] = msgs
+	}
+	msgs[id] = msg
+}
+
+// Lookup finds the message for the message ID. If the message is not
+// available for the locale (e.g. "de-AT"), the base language of the
+// locale ("de") and the fallback locale are tried.
+func (c *Catalog) Lookup(locale, id string) (Message, bool) {
+	if msg, ok := c.messages[locale][id]; ok {
+		return msg, true
+	}
+	if i := strings.IndexAny(locale, "-_"); i > 0 {
+		if msg, ok := c.messages[locale[:i]][id]; ok {
+			return msg, true
+		}
+	}
+	msg, ok := c.messages[c.Fallback][id]
+	return msg, ok
+}
+
+// Sprintf formats the message ID for the locale and returns the resulting
+// string. If the message is not available `%!(NOMSG=<id>)` is returned.
+func (c *Catalog) Sprintf(cb CB, locale, id string, args ...interface{}) string {
+	format, st, ok := c.prepare(locale, id, args)
+	if !ok {
+		return "%!(NOMSG=" + id + ")"
+	}
+
+	s, _ := c.printer().sprintf(cb, nil, format, st)
+	return s
+}
+
+// Fprintf formats the message ID for the locale and writes to w.
+func (c *Catalog) Fprintf(w io.Writer, cb CB, locale, id string, args ...interface{}) (n int, err error) {
+	format, st, ok := c.prepare(locale, id, args)
+	if !ok {
+		return io.WriteString(w, "%!(NOMSG="+id+")")
+	}
+
+	_, n, err = c.printer().fprintf(w, cb, nil, format, st)
+	return n, err
+}
+
+func (c *Catalog) printer() *Printer {
+	if c.Printer != nil {
+		return c.Printer
+	}
+	return &defaultPrinter
+}
+
+// prepare selects the format string for the message and binds the arguments
+// to the field names used in the selected format string.
+func (c *Catalog) prepare(locale, id string, args []interface{}) (string, argstate, bool) {
+	msg, ok := c.Lookup(locale, id)
+	if !ok {
+		return "", argstate{}, false
+	}
+
+	format := msg.Format
+	if msg.Plural != "" && len(msg.Forms) > 0 {
+		names, vs := namedArgs(args, []string{msg.Plural})
+		format = c.pluralForm(locale, msg, names, vs)
+	}
+
+	names, vs := namedArgs(args, fieldNames(format))
+	return format, argstate{args: vs, names: names}, true
+}
+
+// pluralForm selects the format string for the message based on the value of
+// the plural field.
+func (c *Catalog) pluralForm(locale string, msg Message, names []string, vs []interface{}) string {
+	n, ok := pluralCount(msg.Plural, names, vs)
+	if !ok {
+		return msg.Format
+	}
+
+	if n == math.Trunc(n) && math.Abs(n) < 1<<53 {
+		if format, exists := msg.Forms["="+strconv.FormatInt(int64(n), 10)]; exists {
+			return format
+		}
+	}
+
+	category := "other"
+	if rule := c.pluralRule(locale); rule != nil {
+		category = rule(n)
+	} else if n == 1 {
+		category = "one"
+	}
+
+	if format, exists := msg.Forms[category]; exists {
+		return format
+	}
+	if format, exists := msg.Forms["other"]; exists {
+		return format
+	}
+	return msg.Format
+}
+
+func (c *Catalog) pluralRule(locale string) func(float64) string {
+	if rule := c.PluralRules[locale]; rule != nil {
+		return rule
+	}
+	if i := strings.IndexAny(locale, "-_"); i > 0 {
+		return c.PluralRules[locale[:i]]
+	}
+	return nil
+}
+
+// namedArgs splits a list of diag.Field values and key value pairs into
+// field names and values. A string is used as key only if it is one of the
+// field names in fields. Other arguments are kept with an empty name, such
+// that they can be consumed by anonymous verbs.
+func namedArgs(args []interface{}, fields []string) (names []string, vs []interface{}) {
+	names = make([]string, 0, len(args))
+	vs = make([]interface{}, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i].(type) {
+		case diag.Field:
+			var v interface{}
+			if arg.Value.Reporter != nil {
+				v = arg.Value.Interface()
+			}
+			names = append(names, arg.Key)
+			vs = append(vs, v)
+		case string:
+			if i+1 < len(args) && containsString(fields, arg) {
+				names = append(names, arg)
+				vs = append(vs, args[i+1])
+				i++
+			} else {
+				names = append(names, "")
+				vs = append(vs, arg)
+			}
+		default:
+			names = append(names, "")
+			vs = append(vs, arg)
+		}
+	}
+	return names, vs
+}
+
+// fieldNames returns the names of the field-specs in the format string.
+func fieldNames(format string) []string {
+	var c fieldCollector
+	p := parser{handler: &c}
+	p.parse(format)
+	return c.names
+}
+
+// fieldCollector collects the field names found by the parser.
+type fieldCollector struct {
+	names []string
+}
+
+func (c *fieldCollector) onString(_ string) {}
+
+func (c *fieldCollector) onToken(tok formatToken) {
+	if tok.field != "" && !containsString(c.names, tok.field) {
+		c.names = append(c.names, tok.field)
+	}
+}
+
+func (c *fieldCollector) onParseError(_ formatToken, _ error) {}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// pluralCount finds the numeric value of the named field.
+func pluralCount(name string, names []string, vs []interface{}) (float64, bool) {
+	for i, n := range names {
+		if n == name {
+			return toFloat(vs[i])
+		}
+	}
+	return 0, false
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int8:
+		return float64(n), true
+	case int16:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint8:
+		return float64(n), true
+	case uint16:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	default:
+		return 0, false
+	}
+}
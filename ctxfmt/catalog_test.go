@@ -0,0 +1,216 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+package ctxfmt
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/urso/diag"
+)
+
+func makeTestCatalog() *Catalog {
+	c := &Catalog{Fallback: "en"}
+	c.Set("en", "files", "%{count} files in %{dir}")
+	c.Set("de", "files", "In %{dir} befinden sich %{count} Dateien")
+	c.SetMessage("en", "deleted", Message{
+		Format: "deleted %{count} files",
+		Plural: "count",
+		Forms: map[string]string{
+			"=0":    "no files deleted",
+			"one":   "deleted one file",
+			"other": "deleted %{count} files",
+		},
+	})
+	c.SetMessage("de", "deleted", Message{
+		Plural: "count",
+		Forms: map[string]string{
+			"one":   "eine Datei gelöscht",
+			"other": "%{count} Dateien gelöscht",
+		},
+	})
+	c.Set("en", "anon", "%{user} said %v")
+	c.Set("en", "owner", "%s has %{count} files in %{dir}")
+	return c
+}
+
+func TestCatalog(t *testing.T) {
+	c := makeTestCatalog()
+
+	cases := map[string]struct {
+		locale, id string
+		args       []interface{}
+		out        string
+	}{
+		"original order": {
+			locale: "en", id: "files",
+			args: []interface{}{"count", 3, "dir", "/tmp"},
+			out:  "3 files in /tmp",
+		},
+		"reordered fields": {
+			locale: "de", id: "files",
+			args: []interface{}{"count", 3, "dir", "/tmp"},
+			out:  "In /tmp befinden sich 3 Dateien",
+		},
+		"diag fields": {
+			locale: "de", id: "files",
+			args: []interface{}{diag.String("dir", "/tmp"), diag.Int("count", 3)},
+			out:  "In /tmp befinden sich 3 Dateien",
+		},
+		"base language": {
+			locale: "de-AT", id: "files",
+			args: []interface{}{"count", 1, "dir", "/"},
+			out:  "In / befinden sich 1 Dateien",
+		},
+		"fallback locale": {
+			locale: "fr", id: "files",
+			args: []interface{}{"count", 1, "dir", "/"},
+			out:  "1 files in /",
+		},
+		"plural exact": {
+			locale: "en", id: "deleted",
+			args: []interface{}{"count", 0},
+			out:  "no files deleted",
+		},
+		"plural one": {
+			locale: "en", id: "deleted",
+			args: []interface{}{"count", uint8(1)},
+			out:  "deleted one file",
+		},
+		"plural other": {
+			locale: "de", id: "deleted",
+			args: []interface{}{diag.Int64("count", 0)},
+			out:  "0 Dateien gelöscht",
+		},
+		"plural float": {
+			locale: "en", id: "deleted",
+			args: []interface{}{"count", 1.5},
+			out:  "deleted 1.5 files",
+		},
+		"plural field missing": {
+			locale: "en", id: "deleted",
+			args: nil,
+			out:  "deleted %!v(MISSING) files",
+		},
+		"missing field": {
+			locale: "en", id: "files",
+			args: []interface{}{"count", 3},
+			out:  "3 files in %!v(MISSING)",
+		},
+		"anonymous verbs do not consume named args": {
+			locale: "en", id: "anon",
+			args: []interface{}{"user", "bob"},
+			out:  "bob said %!v(MISSING)",
+		},
+		"anonymous verbs consume positional args": {
+			locale: "en", id: "anon",
+			args: []interface{}{"user", "bob", 42},
+			out:  "bob said 42",
+		},
+		"positional arg between fields": {
+			locale: "en", id: "anon",
+			args: []interface{}{diag.String("user", "bob"), true, diag.Field{Key: "x"}},
+			out:  "bob said true",
+		},
+		"field without value": {
+			locale: "en", id: "files",
+			args: []interface{}{diag.Field{Key: "count"}, "dir", "/"},
+			out:  "<nil> files in /",
+		},
+		"positional string with diag fields": {
+			locale: "en", id: "owner",
+			args: []interface{}{"alice", diag.Int("count", 3), diag.String("dir", "/tmp")},
+			out:  "alice has 3 files in /tmp",
+		},
+		"positional string with key value pairs": {
+			locale: "en", id: "owner",
+			args: []interface{}{"alice", "count", 3, "dir", "/tmp"},
+			out:  "alice has 3 files in /tmp",
+		},
+		"positional string after key value pairs": {
+			locale: "en", id: "owner",
+			args: []interface{}{"count", 3, "dir", "/tmp", "alice"},
+			out:  "alice has 3 files in /tmp",
+		},
+		"unknown key is positional": {
+			locale: "en", id: "anon",
+			args: []interface{}{"user", "bob", "hello", "world"},
+			out:  "bob said hello",
+		},
+		"missing message": {
+			locale: "en", id: "unknown",
+			out: "%!(NOMSG=unknown)",
+		},
+	}
+
+	for name, test := range cases {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			out := c.Sprintf(nil, test.locale, test.id, test.args...)
+			if out != test.out {
+				t.Errorf("Sprintf(%q, %q, %v) = <%s> want <%s>", test.locale, test.id, test.args, out, test.out)
+			}
+
+			var sb strings.Builder
+			c.Fprintf(&sb, nil, test.locale, test.id, test.args...)
+			if sb.String() != test.out {
+				t.Errorf("Fprintf(%q, %q, %v) = <%s> want <%s>", test.locale, test.id, test.args, sb.String(), test.out)
+			}
+		})
+	}
+}
+
+func TestCatalogCallback(t *testing.T) {
+	c := makeTestCatalog()
+
+	type field struct {
+		Key   string
+		Index int
+		Value interface{}
+	}
+	var got []field
+	c.Sprintf(func(key string, idx int, val interface{}) {
+		got = append(got, field{key, idx, val})
+	}, "de", "files", "count", 3, "dir", "/tmp")
+
+	want := []field{{"dir", 1, "/tmp"}, {"count", 0, 3}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("callback mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestCatalogPluralRules(t *testing.T) {
+	c := Catalog{
+		PluralRules: map[string]func(float64) string{
+			"pl": func(n float64) string {
+				switch {
+				case n == 1:
+					return "one"
+				case int64(n)%10 >= 2 && int64(n)%10 <= 4:
+					return "few"
+				default:
+					return "many"
+				}
+			},
+		},
+	}
+	c.SetMessage("pl", "files", Message{
+		Plural: "n",
+		Forms: map[string]string{
+			"one":  "%{n} plik",
+			"few":  "%{n} pliki",
+			"many": "%{n} plików",
+		},
+	})
+
+	for n, want := range map[int]string{1: "1 plik", 3: "3 pliki", 5: "5 plików", 22: "22 pliki"} {
+		if out := c.Sprintf(nil, "pl-PL", "files", "n", n); out != want {
+			t.Errorf("n=%v: got <%s> want <%s>", n, out, want)
+		}
+	}
+}
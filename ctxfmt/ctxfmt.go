@@ -21,13 +21,32 @@
 // Verbs accept almost all flags, width, and precision arguments as are present in the fmt package.
 // Index selection or '*' is not supported.
 //
-// A field-spec has the form `%{[+#@]<field-name>[:<format-verb>]}`.
+// A field-spec has the form `%{[=][*][+#@]<field-name>[?][:<format-verb>][|<default>]}`.
 // The field name is mandatory. If no <format-verb> is given, then the value
 // will be printed using `v` as verb. The prefix modifiers '+', '#', and
 // '@'(=alias for '#') change how the argument will be printed, similar to
 // normal verb flags.
 // More complex formatting directives can be configured after the `:`. The <format-verb> uses the same syntax.
 //
+// Fields can be marked optional by adding '?' to the field name. If the
+// argument is missing or nil, nothing is printed and the callback is not
+// called. Alternatively a default value can be configured after '|', e.g.
+// `%{user|anonymous}`. The default value is printed if the argument is missing
+// or nil.
+//
+// The prefix '=' marks a field-spec as back-reference to a field printed
+// earlier. A back-reference prints the bound value again, optionally using a
+// different format, without consuming an argument and without calling the
+// callback. For example:
+//
+//    Printf(cb, "request %{id} failed, retrying %{=id}", id)
+//
+// The prefix '*' expands structs and maps. The value is printed as usual, but
+// the callback is called for each exported struct field or map entry, using
+// dotted field names like `req.Method`. Dots in map keys are escaped, such
+// that keys like hostnames are kept as single name. Struct fields can be
+// renamed or ignored using `diag:"name,omitempty"` or `diag:"-"` struct tags.
+//
 // For example:
 //
 //    Printf(cb, "hello %v", "world")
@@ -47,55 +66,166 @@
 // Named and anonymous formatting using can be freely mixed. The callback will only
 // be called if a named field or error value is encountered.
 //
+// The PrintfFields, SprintfFields, and FprintfFields variants accept a FieldCB
+// callback instead. The FieldInfo passed to the callback additionally holds
+// the formatting verb, flags, width, precision, and the byte range of the
+// rendered value in the output.
+//
+// The Escape option of a Printer selects an escaping policy, that is applied
+// to all interpolated arguments, but not to the literal text of the format
+// string. This protects line-oriented log formats from user input
+// containing newlines, quotes or terminal escape sequences. EscapeHTML is the
+// only policy that also escapes the literal text.
+//
+// The Decorate option of a Printer adds markup around the values of named
+// fields, e.g. to highlight fields in a terminal using ANSIDecorator, or to
+// produce HTML with HTMLDecorator. The markup itself is never escaped.
+//
+// The Redact option of a Printer configures field names, whose values are
+// replaced by a mask in the output, e.g. `[REDACTED]`. The callback still
+// receives the original value, unless configured otherwise.
+//
+// The Limits option of a Printer bounds the output generated for a single
+// argument, by limiting the number of bytes, the number of collection
+// elements, and the nesting depth of values printed.
+//
+// Appendf appends the formatted output to a byte slice. Internal formatting
+// state is reused between calls, such that Appendf does not allocate when
+// formatting primitive values into a buffer with enough capacity.
+//
+// In addition to the verbs supported by the fmt package, ctxfmt provides
+// humanizing verbs and flags:
+//
+//    %B    byte size using IEC units, e.g. `1.5 MiB`. The '#' flag selects SI
+//          units, e.g. `1.6 MB`.
+//    %D    compact duration of integer nanoseconds, e.g. `2d3h` or `1m30s`.
+//          The precision configures the number of units to print (default 2).
+//    '     thousands grouping flag for d, v, and f, e.g. `%'d` prints `1,234,567`.
+//          Zero padding counts separators against the width, e.g. `%'011d`
+//          prints `001,234,567`.
+//
+// A Catalog maps message IDs to localized format strings. Arguments passed to
+// a Catalog are bound to field-specs by name, such that translations can
+// reorder fields. Plural forms are selected by the value of a numeric field.
+//
+// Scan reverses the formatting. Given the format string, Scan extracts the
+// named fields from a rendered message into a diag.Context. Templates
+// returned by Compile can be reused to scan many messages:
+//
+//    tmpl, err := ctxfmt.Compile("%{method} %{path} -> %{status:d}")
+//    ...
+//    ctx, err := tmpl.Scan("GET /index.html -> 200")
+//
 // The printf-style functions in ctxfmt all respect the fmt.Stringer,
 // fmt.GoStringer, and fmt.Formatter interfaces.
+// Values of type diag.Value, diag.Field, and *diag.Context are formatted
+// without reflection. A diag.Field prints its value, or `key=value` if
+// formatted with `%+v`. A *diag.Context prints all fields as `k1=v1 k2=v2`,
+// or as nested object if formatted with `%+v`.
+//
+// Custom verbs and formatters for types that do not implement fmt.Formatter
+// can be registered with a Printer. For example:
+//
+//    var p ctxfmt.Printer
+//    p.RegisterType(reflect.TypeOf(uuid.UUID{}), formatUUID)
+//    p.RegisterVerb('h', formatHash)
+//    p.Printf(cb, "uploaded %{file} (%{digest:h})", id, sum)
 package ctxfmt
 
 import (
 	"io"
-	"os"
-	"strings"
 )
 
+// CB is called for every named field and error value found while formatting.
+// The idx reports the index of the argument in the argument list.
+// CB is called before the value is printed.
 type CB func(key string, idx int, val interface{})
 
+// FieldCB is an extended callback, that is called with the field its
+// formatting directives and the position of the rendered value in the output.
+// FieldCB is called after the value has been printed.
+type FieldCB func(FieldInfo)
+
+// FieldInfo describes a field or error value captured while formatting.
+// The formatting directives can be used to rebuild the original field-spec,
+// while Start and End can be used to highlight or redact the value
+// in the output.
+type FieldInfo struct {
+	Key   string      // field name. Empty for error values passed as anonymous argument.
+	Index int         // index of the argument in the argument list.
+	Value interface{} // argument value
+
+	Verb         rune // formatting verb
+	Width        int  // configured width. Only valid if HasWidth is set
+	Precision    int  // configured precision. Only valid if HasPrecision is set
+	HasWidth     bool
+	HasPrecision bool
+
+	// Start and End give the byte range [Start, End) of the rendered value
+	// relative to the beginning of the formatted message. Both are set to -1
+	// if the value has not been rendered (e.g. errors in the rest arguments).
+	Start, End int
+
+	flags flags
+}
+
 // Printf formats according to the format specifier and writes to stdout.
 // It returns the unprocessed arguments.
 func Printf(cb CB, msg string, vs ...interface{}) (rest []interface{}, n int, err error) {
-	return Fprintf(os.Stdout, cb, msg, vs...)
+	return defaultPrinter.Printf(cb, msg, vs...)
 }
 
 // Sprintf formats according to the format specifier and returns the resulting
 // string and the list of unprocessed arguments.
 func Sprintf(cb CB, msg string, vs ...interface{}) (string, []interface{}) {
-	var buf strings.Builder
-	rest, _, _ := Fprintf(&buf, cb, msg, vs...)
-	return buf.String(), rest
+	return defaultPrinter.Sprintf(cb, msg, vs...)
 }
 
 // Fprintf formats according to the format specifier and writes to w.
 // It returns the unprocessed arguments.
 func Fprintf(w io.Writer, cb CB, msg string, vs ...interface{}) (rest []interface{}, n int, err error) {
-	printer := &printer{To: w}
-	in := &interpreter{
-		cb:   cb,
-		p:    printer,
-		args: argstate{args: vs},
-	}
-	parser := &parser{handler: in}
-	parser.parse(msg)
-
-	used := in.args.idx
-	if used >= len(vs) {
-		return nil, printer.written, printer.err
-	}
-
-	// collect errors from extra variables
-	rest = vs[used:]
-	for i := range rest {
-		if isErrorValue(rest[i]) {
-			cb("", used+i, rest[i])
-		}
-	}
-	return rest, printer.written, printer.err
+	return defaultPrinter.Fprintf(w, cb, msg, vs...)
+}
+
+// Appendf formats according to the format specifier, appends the result to
+// dst, and returns the updated buffer and the list of unprocessed arguments.
+func Appendf(dst []byte, cb CB, msg string, vs ...interface{}) ([]byte, []interface{}) {
+	return defaultPrinter.Appendf(dst, cb, msg, vs...)
+}
+
+// PrintfFields formats according to the format specifier and writes to stdout.
+// The callback receives the field its formatting directives and position
+// within the output.
+// It returns the unprocessed arguments.
+func PrintfFields(cb FieldCB, msg string, vs ...interface{}) (rest []interface{}, n int, err error) {
+	return defaultPrinter.PrintfFields(cb, msg, vs...)
+}
+
+// SprintfFields formats according to the format specifier and returns the
+// resulting string and the list of unprocessed arguments.
+// The callback receives the field its formatting directives and position
+// within the output.
+func SprintfFields(cb FieldCB, msg string, vs ...interface{}) (string, []interface{}) {
+	return defaultPrinter.SprintfFields(cb, msg, vs...)
+}
+
+// FprintfFields formats according to the format specifier and writes to w.
+// The callback receives the field its formatting directives and position
+// within the output.
+// It returns the unprocessed arguments.
+func FprintfFields(w io.Writer, cb FieldCB, msg string, vs ...interface{}) (rest []interface{}, n int, err error) {
+	return defaultPrinter.FprintfFields(w, cb, msg, vs...)
+}
+
+// AppendfFields formats according to the format specifier, appends the result
+// to dst, and returns the updated buffer and the list of unprocessed arguments.
+// The callback receives the field its formatting directives and position
+// within the output. Positions are relative to the end of dst.
+func AppendfFields(dst []byte, cb FieldCB, msg string, vs ...interface{}) ([]byte, []interface{}) {
+	return defaultPrinter.AppendfFields(dst, cb, msg, vs...)
+}
+
+// Flag reports whether the flag c, a character, has been set.
+func (fi *FieldInfo) Flag(c int) bool {
+	return fi.flags.has(c)
 }
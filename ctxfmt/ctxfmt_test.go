@@ -7,6 +7,7 @@
 package ctxfmt
 
 import (
+	"errors"
 	"fmt"
 	"testing"
 
@@ -78,6 +79,80 @@ func TestSprintfFields(t *testing.T) {
 			},
 			rest: values(2, 3),
 		},
+		{
+			in:   "hello%{user?}",
+			out:  "hello",
+			args: nil,
+		},
+		{
+			in:   "hello %{user?}",
+			out:  "hello test",
+			args: values("test"),
+			want: records{
+				{"user", 0, "test"},
+			},
+		},
+		{
+			in:   "[%{a?}] [%{b}]",
+			out:  "[] [2]",
+			args: values(nil, 2),
+			want: records{
+				{"b", 1, 2},
+			},
+		},
+		{
+			in:   "[%{ptr?}]",
+			out:  "[]",
+			args: values((*int)(nil)),
+		},
+		{
+			in:   "hello %{user|anonymous}",
+			out:  "hello anonymous",
+		},
+		{
+			in:   "hello %{user|anonymous}",
+			out:  "hello test",
+			args: values("test"),
+			want: records{
+				{"user", 0, "test"},
+			},
+		},
+		{
+			in:   "[%{user:-6s|anon}] [%{id:04d|-}]",
+			out:  "[anon  ] [0023]",
+			args: values(nil, 23),
+			want: records{
+				{"id", 1, 23},
+			},
+		},
+		{
+			in:   "request %{id} failed, retrying %{=id}",
+			out:  "request 42 failed, retrying 42",
+			args: values(42, "rest"),
+			want: records{
+				{"id", 0, 42},
+			},
+			rest: values("rest"),
+		},
+		{
+			in:   "%{id:d} (%{=id:#x}) %{id} %{=id}",
+			out:  "255 (0xff) 1 1",
+			args: values(255, 1),
+			want: records{
+				{"id", 0, 255},
+				{"id", 1, 1},
+			},
+		},
+		{
+			in:   "[%{user|anon}] [%{=user}] [%{id?}] [%{=id}]",
+			out:  "[anon] [anon] [] []",
+			args: values(nil),
+			rest: values(),
+		},
+		{
+			in:   "%{=id} %{=id?}%{=id|none}",
+			out:  "%!v(UNBOUND=id) none",
+		},
 	}
 
 	for i, test := range cases {
@@ -102,3 +177,121 @@ func TestSprintfFields(t *testing.T) {
 		})
 	}
 }
+
+func TestSprintfFieldInfo(t *testing.T) {
+	type cbRecord struct {
+		Key        string
+		Idx        int
+		Verb       rune
+		Plus       bool
+		Sharp      bool
+		Width      int
+		Rendered   string
+		Start, End int
+	}
+	type records []cbRecord
+
+	values := func(vs ...interface{}) []interface{} { return vs }
+	testErr := errors.New("oops")
+
+	cases := []struct {
+		in   string
+		out  string
+		args []interface{}
+		want records
+	}{
+		{
+			in:   "value=%{field}",
+			out:  "value=3",
+			args: values(3),
+			want: records{
+				{Key: "field", Idx: 0, Verb: 'v', Rendered: "3", Start: 6, End: 7},
+			},
+		},
+		{
+			in:   "%{a:x} and %{+b} %v",
+			out:  "ff and {X:1} oops",
+			args: values(255, struct{ X int }{1}, testErr),
+			want: records{
+				{Key: "a", Idx: 0, Verb: 'x', Rendered: "ff", Start: 0, End: 2},
+				{Key: "b", Idx: 1, Verb: 'v', Plus: true, Rendered: "{X:1}", Start: 7, End: 12},
+				{Key: "", Idx: 2, Verb: 'v', Rendered: "oops", Start: 13, End: 17},
+			},
+		},
+		{
+			in:   "[%{name:#5x}]",
+			out:  "[ 0x17]",
+			args: values(23),
+			want: records{
+				{Key: "name", Idx: 0, Verb: 'x', Sharp: true, Width: 5, Rendered: " 0x17", Start: 1, End: 6},
+			},
+		},
+		{
+			in:   "%{a}",
+			out:  "1",
+			args: values(1, testErr),
+			want: records{
+				{Key: "a", Idx: 0, Verb: 'v', Rendered: "1", Start: 0, End: 1},
+				{Key: "", Idx: 1, Start: -1, End: -1},
+			},
+		},
+	}
+
+	for i, test := range cases {
+		name := fmt.Sprintf("%d: %v -> %v", i, test.in, test.out)
+		t.Run(name, func(t *testing.T) {
+			var actual records
+			var out string
+			out, _ = SprintfFields(func(fi FieldInfo) {
+				actual = append(actual, cbRecord{
+					Key:   fi.Key,
+					Idx:   fi.Index,
+					Verb:  fi.Verb,
+					Plus:  fi.Flag('+'),
+					Sharp: fi.Flag('#'),
+					Width: fi.Width,
+					Start: fi.Start,
+					End:   fi.End,
+				})
+			}, test.in, test.args...)
+
+			for i := range actual {
+				if rec := &actual[i]; rec.Start >= 0 {
+					rec.Rendered = out[rec.Start:rec.End]
+				}
+			}
+
+			if test.out != out {
+				t.Errorf("Output failure. Want <%s>, Got <%s>", test.out, out)
+			}
+
+			if diff := cmp.Diff(test.want, actual); diff != "" {
+				t.Errorf("callback missmatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+type stringerFunc func() string
+
+func (fn stringerFunc) String() string { return fn() }
+
+func TestCallbackOrder(t *testing.T) {
+	var events []string
+	arg := stringerFunc(func() string {
+		events = append(events, "print")
+		return "x"
+	})
+
+	Sprintf(func(key string, _ int, _ interface{}) {
+		events = append(events, "cb")
+	}, "%{a}", arg)
+	SprintfFields(func(fi FieldInfo) {
+		events = append(events, "fieldcb")
+	}, "%{a}", arg)
+
+	want := []string{"cb", "print", "print", "fieldcb"}
+	if diff := cmp.Diff(want, events); diff != "" {
+		t.Errorf("callback order mismatch (-want +got):\n%s", diff)
+	}
+}
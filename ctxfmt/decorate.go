@@ -0,0 +1,121 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+package ctxfmt
+
+import (
+	"html"
+	"io"
+	"reflect"
+)
+
+// Decorator adds markup around the rendered values of named fields.
+// The markup is written to w as is. Escaping policies and limits are not
+// applied to the markup.
+type Decorator interface {
+	// OpenField is called before the value of a named field is printed.
+	OpenField(w io.Writer, key string, val interface{})
+
+	// CloseField is called after the value of a named field has been printed.
+	CloseField(w io.Writer, key string, val interface{})
+}
+
+// ANSIDecorator colors field values in terminals using ANSI escape
+// sequences. Colors are given as SGR parameters, e.g. "31" for red or "1;34"
+// for bold blue.
+type ANSIDecorator struct {
+	// Keys configures colors per field name.
+	Keys map[string]string
+
+	// Types configures colors per type of the value. Colors configured for
+	// the field name have precedence.
+	Types map[reflect.Type]string
+
+	// Default configures the color of all other fields. Other fields are not
+	// colored if Default is empty.
+	Default string
+}
+
+// HTMLDecorator wraps field values into `<span>` elements.
+// The field name is added as `data-key` attribute.
+//
+// HTMLDecorator should be combined with EscapeHTML, such that the literal
+// text and the values are escaped as well.
+type HTMLDecorator struct {
+	// Class configures the CSS class of the span element. The class "field"
+	// is used if Class is empty.
+	Class string
+}
+
+// rawWriter writes to the output of a printer, bypassing escaping and limits.
+type rawWriter printer
+
+func (w *rawWriter) Write(b []byte) (int, error) {
+	p := (*printer)(w)
+	if p.err != nil {
+		return 0, p.err
+	}
+	return p.rawWrite(b)
+}
+
+func (in *interpreter) openField(key string, val interface{}) {
+	if d := in.cfg.Decorate; d != nil {
+		d.OpenField((*rawWriter)(&in.p), key, val)
+	}
+}
+
+func (in *interpreter) closeField(key string, val interface{}) {
+	if d := in.cfg.Decorate; d != nil {
+		d.CloseField((*rawWriter)(&in.p), key, val)
+	}
+}
+
+// OpenField starts the color sequence for the field.
+func (d *ANSIDecorator) OpenField(w io.Writer, key string, val interface{}) {
+	if color := d.color(key, val); color != "" {
+		io.WriteString(w, "\x1b[")
+		io.WriteString(w, color)
+		io.WriteString(w, "m")
+	}
+}
+
+// CloseField resets the terminal colors, if the field has been colored.
+func (d *ANSIDecorator) CloseField(w io.Writer, key string, val interface{}) {
+	if color := d.color(key, val); color != "" {
+		io.WriteString(w, "\x1b[0m")
+	}
+}
+
+func (d *ANSIDecorator) color(key string, val interface{}) string {
+	if color, exists := d.Keys[key]; exists {
+		return color
+	}
+	if val != nil {
+		if color, exists := d.Types[reflect.TypeOf(val)]; exists {
+			return color
+		}
+	}
+	return d.Default
+}
+
+// OpenField writes the opening span tag.
+func (d *HTMLDecorator) OpenField(w io.Writer, key string, val interface{}) {
+	class := d.Class
+	if class == "" {
+		class = "field"
+	}
+
+	io.WriteString(w, `<span class="`)
+	io.WriteString(w, html.EscapeString(class))
+	io.WriteString(w, `" data-key="`)
+	io.WriteString(w, html.EscapeString(key))
+	io.WriteString(w, `">`)
+}
+
+// CloseField writes the closing span tag.
+func (d *HTMLDecorator) CloseField(w io.Writer, key string, val interface{}) {
+	io.WriteString(w, "</span>")
+}
@@ -0,0 +1,114 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+package ctxfmt
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDecorate(t *testing.T) {
+	ansi := &ANSIDecorator{
+		Keys:  map[string]string{"user": "1;34"},
+		Types: map[reflect.Type]string{reflect.TypeOf(0): "33"},
+	}
+
+	cases := map[string]struct {
+		printer Printer
+		fmt     string
+		args    []interface{}
+		out     string
+	}{
+		"ansi by key": {
+			printer: Printer{Decorate: ansi},
+			fmt:     "hello %{user}",
+			args:    []interface{}{"world"},
+			out:     "hello \x1b[1;34mworld\x1b[0m",
+		},
+		"ansi by type": {
+			printer: Printer{Decorate: ansi},
+			fmt:     "%{count} %{other} %v",
+			args:    []interface{}{3, "x", 4},
+			out:     "\x1b[33m3\x1b[0m x 4",
+		},
+		"ansi default color": {
+			printer: Printer{Decorate: &ANSIDecorator{Default: "2"}},
+			fmt:     "%{a}",
+			args:    []interface{}{"x"},
+			out:     "\x1b[2mx\x1b[0m",
+		},
+		"ansi markup not escaped": {
+			printer: Printer{Decorate: ansi, Escape: EscapeANSI},
+			fmt:     "%{user}",
+			args:    []interface{}{"\x1b[31mroot"},
+			out:     "\x1b[1;34mroot\x1b[0m",
+		},
+		"html": {
+			printer: Printer{Decorate: &HTMLDecorator{}, Escape: EscapeHTML},
+			fmt:     "<%{user}> failed: %{err}",
+			args:    []interface{}{"a&b", errors.New("<oops>")},
+			out:     `&lt;<span class="field" data-key="user">a&amp;b</span>&gt; failed: <span class="field" data-key="err">&lt;oops&gt;</span>`,
+		},
+		"html custom class": {
+			printer: Printer{Decorate: &HTMLDecorator{Class: "f"}, Escape: EscapeHTML},
+			fmt:     "%{x:3d}",
+			args:    []interface{}{1},
+			out:     `<span class="f" data-key="x">  1</span>`,
+		},
+		"html back-reference": {
+			printer: Printer{Decorate: &HTMLDecorator{}},
+			fmt:     "%{x} %{=x}",
+			args:    []interface{}{1},
+			out:     `<span class="field" data-key="x">1</span> <span class="field" data-key="x">1</span>`,
+		},
+		"html redacted": {
+			printer: Printer{
+				Decorate: &HTMLDecorator{},
+				Escape:   EscapeHTML,
+				Redact:   &Redaction{Names: []string{"password"}, Mask: func(_ string, _ interface{}) string { return "<hidden>" }},
+			},
+			fmt:  "%{password}",
+			args: []interface{}{"secret"},
+			out:  `<span class="field" data-key="password">&lt;hidden&gt;</span>`,
+		},
+	}
+
+	for name, test := range cases {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			out, _ := test.printer.Sprintf(nil, test.fmt, test.args...)
+			if out != test.out {
+				t.Errorf("Sprintf(%q, %v) = %q, want %q", test.fmt, test.args, out, test.out)
+			}
+		})
+	}
+}
+
+func TestDecorateFieldInfoPositions(t *testing.T) {
+	p := Printer{Decorate: &HTMLDecorator{}}
+
+	var infos []FieldInfo
+	out, _ := p.SprintfFields(func(fi FieldInfo) { infos = append(infos, fi) }, "a %{x} b %{y}", 1, "two")
+
+	want := []string{"1", "two"}
+	if len(infos) != len(want) {
+		t.Fatalf("got %d fields, want %d", len(infos), len(want))
+	}
+	for i, fi := range infos {
+		if got := out[fi.Start:fi.End]; got != want[i] {
+			t.Errorf("field %v: range [%d,%d) = %q, want %q", fi.Key, fi.Start, fi.End, got, want[i])
+		}
+	}
+}
+
+func ExampleHTMLDecorator() {
+	p := Printer{Decorate: &HTMLDecorator{}, Escape: EscapeHTML}
+	p.Printf(nil, "user %{user} logged in from <%{ip}>\n", "alice", "10.0.0.1")
+	// Output:
+	// user <span class="field" data-key="user">alice</span> logged in from &lt;<span class="field" data-key="ip">10.0.0.1</span>&gt;
+}
@@ -0,0 +1,153 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+package ctxfmt
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/urso/diag"
+)
+
+// ctxPrinter prints all fields in a diag.Context.
+type ctxPrinter struct {
+	in         *interpreter
+	tok        formatToken
+	structured bool
+	first      bool
+}
+
+// fmtDiagValue formats a diag.Value based on the type reported by its
+// Reporter. Values of the primitive types are decoded directly from the
+// Primitive and String slots, without calling the Reporter.
+func (in *interpreter) fmtDiagValue(tok *formatToken, v *diag.Value) {
+	if v.Reporter == nil {
+		in.formatArg(tok, nil)
+		return
+	}
+
+	switch v.Reporter.Type() {
+	case diag.BoolType:
+		in.fmtBool(tok, v.Primitive != 0)
+	case diag.IntType, diag.Int64Type:
+		in.fmtInt(tok, v.Primitive, true)
+	case diag.Uint64Type:
+		in.fmtInt(tok, v.Primitive, false)
+	case diag.Float64Type:
+		in.fmtFloat(tok, math.Float64frombits(v.Primitive), 64)
+	case diag.StringType:
+		in.fmtString(tok, v.String)
+	case diag.DurationType:
+		switch tok.verb {
+		case 'v', 's', 'q', 'x', 'X':
+			if !tok.flags.sharpV {
+				in.fmtString(tok, time.Duration(v.Primitive).String())
+				return
+			}
+		}
+		in.fmtInt(tok, v.Primitive, true)
+	default:
+		in.formatArg(tok, v.Interface())
+	}
+}
+
+// fmtDiagField prints the field its value. The field is printed as
+// `key=value` if the '+' flag is used with the 'v' verb.
+func (in *interpreter) fmtDiagField(tok *formatToken, fld *diag.Field) {
+	if tok.flags.plusV {
+		in.p.WriteString(fld.Key)
+		in.p.WriteByte('=')
+	}
+	in.fmtDiagValue(tok, &fld.Value)
+}
+
+// fmtDiagContext prints all fields in a context. The fields are printed as
+// `k1=v1 k2=v2` by default. If the '+' or '#' flag is used with the 'v' verb,
+// the context is printed as JSON-like nested object.
+func (in *interpreter) fmtDiagContext(tok *formatToken, ctx *diag.Context) {
+	if ctx == nil {
+		in.formatPadString(tok, "<nil>")
+		return
+	}
+
+	cp := &ctxPrinter{
+		in:         in,
+		tok:        *tok,
+		structured: tok.flags.plusV || tok.flags.sharpV,
+		first:      true,
+	}
+	if !cp.structured {
+		ctx.VisitKeyValues(cp)
+		return
+	}
+
+	in.p.WriteByte('{')
+	ctx.VisitStructured(cp)
+	in.p.WriteByte('}')
+}
+
+func (cp *ctxPrinter) OnObjStart(key string) error {
+	cp.writeKey(key)
+	cp.in.p.WriteByte('{')
+	cp.first = true
+	return nil
+}
+
+func (cp *ctxPrinter) OnObjEnd() error {
+	cp.in.p.WriteByte('}')
+	cp.first = false
+	return nil
+}
+
+func (cp *ctxPrinter) OnValue(key string, v diag.Value) error {
+	cp.writeKey(key)
+
+	tok := cp.tok
+	if cp.structured && isStringValue(&v) {
+		tok.verb = 'q'
+		tok.flags.plusV, tok.flags.sharpV = false, false
+	}
+	cp.in.fmtDiagValue(&tok, &v)
+	return nil
+}
+
+func isStringValue(v *diag.Value) bool {
+	if v.Reporter == nil {
+		return false
+	}
+
+	switch v.Reporter.Type() {
+	case diag.StringType:
+		return true
+	case diag.IfcType:
+		_, ok := v.Interface().(string)
+		return ok
+	default:
+		return false
+	}
+}
+
+func (cp *ctxPrinter) writeKey(key string) {
+	p := &cp.in.p
+	if !cp.first {
+		if cp.structured {
+			p.WriteString(", ")
+		} else {
+			p.WriteByte(' ')
+		}
+	}
+	cp.first = false
+
+	if cp.structured {
+		p.Write(strconv.AppendQuote(cp.in.fmtBuf[:0], key))
+		p.WriteString(": ")
+	} else {
+		p.WriteString(key)
+		p.WriteByte('=')
+	}
+}
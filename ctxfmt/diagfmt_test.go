@@ -0,0 +1,85 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+package ctxfmt
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/urso/diag"
+)
+
+func TestFmtDiagTypes(t *testing.T) {
+	ctx := diag.NewContext(nil, nil)
+	ctx.AddAll(
+		"b", true,
+		"a.x", 1,
+		"a.y", "str",
+		diag.Duration("d", 1500*time.Millisecond),
+	)
+
+	nested := diag.NewContext(ctx, nil)
+	nested.AddAll("a.x", 2, "c", 3.5)
+
+	var nilCtx *diag.Context
+
+	cases := []struct {
+		fmt string
+		val interface{}
+		out string
+	}{
+		{"%v", diag.ValBool(true), "true"},
+		{"%v", diag.ValInt(-23), "-23"},
+		{"%05d", diag.ValInt64(-23), "-0023"},
+		{"%x", diag.ValUint64(255), "ff"},
+		{"%#v", diag.ValUint64(255), "0xff"},
+		{"%.2f", diag.ValFloat(3.14159), "3.14"},
+		{"%v", diag.ValString("hello"), "hello"},
+		{"%q", diag.ValString("hello"), `"hello"`},
+		{"%v", diag.ValDuration(2 * time.Second), "2s"},
+		{"%d", diag.ValDuration(2 * time.Second), "2000000000"},
+		{"%v", diag.ValTime(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)), "2020-01-02 03:04:05 +0000 UTC"},
+		{"%v", diag.ValAny([]int{1, 2}), "[1 2]"},
+		{"%v", diag.ValAny(errors.New("oops")), "oops"},
+		{"%v", diag.Value{}, "<nil>"},
+		{"%v", diag.Int("count", 5), "5"},
+		{"%+v", diag.Int("count", 5), "count=5"},
+		{"%+v", diag.String("msg", "hello world"), "msg=hello world"},
+		{"%v", ctx, "a.x=1 a.y=str b=true d=1.5s"},
+		{"%+v", ctx, `{"a": {"x": 1, "y": "str"}, "b": true, "d": 1.5s}`},
+		{"%v", nested, "a.x=2 a.y=str b=true c=3.5 d=1.5s"},
+		{"%+v", nested, `{"a": {"x": 2, "y": "str"}, "b": true, "c": 3.5, "d": 1.5s}`},
+		{"%v", diag.NewContext(nil, nil), ""},
+		{"%+v", diag.NewContext(nil, nil), "{}"},
+		{"%v", nilCtx, "<nil>"},
+	}
+
+	for i, test := range cases {
+		t.Run(fmt.Sprintf("%d: %v -> %v", i, test.fmt, test.out), func(t *testing.T) {
+			out, _ := Sprintf(nil, test.fmt, test.val)
+			if out != test.out {
+				t.Errorf("Sprintf(%q, %v) = <%s> want <%s>", test.fmt, test.val, out, test.out)
+			}
+		})
+	}
+}
+
+func TestFmtDiagFieldCallback(t *testing.T) {
+	var fields []string
+	out, _ := Sprintf(func(key string, idx int, val interface{}) {
+		fields = append(fields, fmt.Sprintf("%v:%v", key, val.(diag.Field).Key))
+	}, "request %+v failed", diag.String("url", "http://localhost"))
+
+	if want := "request url=http://localhost failed"; out != want {
+		t.Errorf("got <%s> want <%s>", out, want)
+	}
+	if want := "[:url]"; fmt.Sprint(fields) != want {
+		t.Errorf("got callbacks %v want %v", fields, want)
+	}
+}
@@ -14,4 +14,5 @@ var (
 	errCloseMissing = errors.New("missing '}'")
 	errNoFieldName  = errors.New("field name missing")
 	errMissingArg   = errors.New("missing arg")
+	errUnboundField = errors.New("unbound field")
 )
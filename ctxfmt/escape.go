@@ -0,0 +1,216 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+package ctxfmt
+
+import "unicode/utf8"
+
+// Escaping selects the escaping policy applied to interpolated arguments.
+// The escaping policy is not applied to the literal text in the format
+// string.
+type Escaping uint8
+
+const (
+	// EscapeNone prints arguments as is.
+	EscapeNone Escaping = iota
+
+	// EscapeJSON escapes quotes, backslashes and control characters, such
+	// that the formatted message can be embedded into a JSON string. Invalid
+	// UTF-8 sequences are replaced with �.
+	EscapeJSON
+
+	// EscapeLogfmt escapes quotes, backslashes and control characters, such
+	// that the formatted message can be used as quoted logfmt value.
+	EscapeLogfmt
+
+	// EscapeStripControl removes all ASCII and unicode (C1) control characters.
+	EscapeStripControl
+
+	// EscapeANSI removes ANSI escape sequences (CSI, OSC) and escapes other
+	// control characters, except for tab and newline, as \xNN.
+	EscapeANSI
+
+	// EscapeHTML escapes the special characters <, >, &, ', and ".
+	// Unlike the other policies, EscapeHTML is also applied to the literal
+	// text of the format string.
+	EscapeHTML
+)
+
+// textEscaping returns the escaping policy to be applied to literal text.
+func (e Escaping) textEscaping() Escaping {
+	if e == EscapeHTML {
+		return EscapeHTML
+	}
+	return EscapeNone
+}
+
+// ansiState tracks the parsing of ANSI escape sequences, such that
+// sequences split between multiple writes are removed.
+type ansiState uint8
+
+const (
+	ansiNone   ansiState = iota
+	ansiEsc              // found ESC
+	ansiCSI              // found ESC '[', skip until final byte
+	ansiOSC              // found ESC ']', skip until BEL or ESC '\'
+	ansiOSCEsc           // found ESC in OSC sequence
+)
+
+// writeEscaped writes b using the active escaping policy. Bytes not requiring
+// escaping are written in batches.
+func (p *printer) writeEscaped(b []byte) (int, error) {
+	start := 0
+	for i := 0; i < len(b); {
+		esc, n, replace := p.escapeAt(b, i)
+		if !replace {
+			i += n
+			continue
+		}
+
+		if start < i {
+			if _, err := p.doWrite(b[start:i]); err != nil {
+				return 0, err
+			}
+		}
+		if len(esc) > 0 {
+			if _, err := p.doWriteAll(esc); err != nil {
+				return 0, err
+			}
+		}
+		i += n
+		start = i
+	}
+
+	if start < len(b) {
+		if _, err := p.doWrite(b[start:]); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// escapeAt checks if the bytes at b[i:] need to be escaped. If replace is
+// false, the next n bytes are written as is. Otherwise the next n bytes are
+// replaced by esc. The bytes are removed if esc is empty.
+func (p *printer) escapeAt(b []byte, i int) (esc []byte, n int, replace bool) {
+	c := b[i]
+
+	switch p.escape {
+	case EscapeJSON, EscapeLogfmt:
+		switch c {
+		case '"', '\\':
+			return p.escBytes('\\', c), 1, true
+		case '\n':
+			return p.escBytes('\\', 'n'), 1, true
+		case '\r':
+			return p.escBytes('\\', 'r'), 1, true
+		case '\t':
+			return p.escBytes('\\', 't'), 1, true
+		}
+
+		if c < 0x20 || c == 0x7f {
+			return p.escBytes('\\', 'u', '0', '0', lHexDigits[c>>4], lHexDigits[c&0xf]), 1, true
+		}
+		if c >= utf8.RuneSelf && p.escape == EscapeJSON {
+			r, size := utf8.DecodeRune(b[i:])
+			if r == utf8.RuneError && size == 1 {
+				return p.escBytes('\\', 'u', 'f', 'f', 'f', 'd'), 1, true
+			}
+			return nil, size, false
+		}
+
+	case EscapeStripControl:
+		if c < 0x20 || c == 0x7f {
+			return nil, 1, true
+		}
+		if isC1Control(b, i) {
+			return nil, 2, true
+		}
+
+	case EscapeANSI:
+		if p.ansi != ansiNone || c == 0x1b {
+			p.ansi = nextANSIState(p.ansi, c)
+			return nil, 1, true
+		}
+		if c == '\n' || c == '\t' {
+			return nil, 1, false
+		}
+		if c < 0x20 || c == 0x7f {
+			return p.escBytes('\\', 'x', lHexDigits[c>>4], lHexDigits[c&0xf]), 1, true
+		}
+		if isC1Control(b, i) {
+			c = b[i+1]
+			return p.escBytes('\\', 'x', lHexDigits[c>>4], lHexDigits[c&0xf]), 2, true
+		}
+
+	case EscapeHTML:
+		switch c {
+		case '<':
+			return p.escBytes('&', 'l', 't', ';'), 1, true
+		case '>':
+			return p.escBytes('&', 'g', 't', ';'), 1, true
+		case '&':
+			return p.escBytes('&', 'a', 'm', 'p', ';'), 1, true
+		case '\'':
+			return p.escBytes('&', '#', '3', '9', ';'), 1, true
+		case '"':
+			return p.escBytes('&', '#', '3', '4', ';'), 1, true
+		}
+	}
+
+	return nil, 1, false
+}
+
+func (p *printer) escBytes(bs ...byte) []byte {
+	n := copy(p.esc[:], bs)
+	return p.esc[:n]
+}
+
+// isC1Control checks if b[i:] starts with an UTF-8 encoded unicode control
+// character in the range U+0080 to U+009F.
+func isC1Control(b []byte, i int) bool {
+	return b[i] == 0xc2 && i+1 < len(b) && 0x80 <= b[i+1] && b[i+1] <= 0x9f
+}
+
+func nextANSIState(st ansiState, c byte) ansiState {
+	switch st {
+	case ansiNone:
+		if c == 0x1b {
+			return ansiEsc
+		}
+		return ansiNone
+	case ansiEsc:
+		switch c {
+		case '[':
+			return ansiCSI
+		case ']':
+			return ansiOSC
+		default:
+			return ansiNone // two byte escape sequence
+		}
+	case ansiCSI:
+		if 0x40 <= c && c <= 0x7e {
+			return ansiNone // final byte
+		}
+		return ansiCSI
+	case ansiOSC:
+		switch c {
+		case 0x07:
+			return ansiNone
+		case 0x1b:
+			return ansiOSCEsc
+		default:
+			return ansiOSC
+		}
+	case ansiOSCEsc:
+		if c == '\\' {
+			return ansiNone
+		}
+		return ansiOSC
+	default:
+		return ansiNone
+	}
+}
@@ -0,0 +1,97 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+package ctxfmt
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+// splitWriter writes its contents using multiple writes.
+type splitWriter []string
+
+func (s splitWriter) Format(f fmt.State, _ rune) {
+	for _, part := range s {
+		f.Write([]byte(part))
+	}
+}
+
+func TestEscaping(t *testing.T) {
+	cases := []struct {
+		mode Escaping
+		fmt  string
+		args []interface{}
+		out  string
+	}{
+		{EscapeNone, "line1\n%v", []interface{}{"line2\nline3"}, "line1\nline2\nline3"},
+
+		{EscapeJSON, "msg: %v", []interface{}{"say \"hi\"\n"}, `msg: say \"hi\"\n`},
+		{EscapeJSON, "\n%{path}\t", []interface{}{`c:\tmp`}, "\n" + `c:\\tmp` + "\t"},
+		{EscapeJSON, "%v", []interface{}{"bell\a del\x7f"}, `bell\u0007 del\u007f`},
+		{EscapeJSON, "%s", []interface{}{"bad \xff utf8 ä"}, `bad \ufffd utf8 ä`},
+		{EscapeJSON, "%q", []interface{}{"a\"b"}, `\"a\\\"b\"`},
+		{EscapeJSON, "[%5v]", []interface{}{"\n"}, `[   \n]`},
+		{EscapeJSON, "[%{x:10s}]", []interface{}{"a\nb"}, `[      a\nb]`},
+		{EscapeJSON, "[%{x:-10s}]", []interface{}{"a\nb"}, `[a\nb      ]`},
+		{EscapeJSON, "[%{x:05d}]", []interface{}{-42}, `[-0042]`},
+		{EscapeJSON, "%d", []interface{}{"x\n"}, `%!d(string=x\n)`},
+
+		{EscapeLogfmt, "msg=%v", []interface{}{"a b\r\n\"c\""}, `msg=a b\r\n\"c\"`},
+		{EscapeLogfmt, "%s", []interface{}{"\xff"}, "\xff"},
+
+		{EscapeStripControl, "%v", []interface{}{"a\nb\tc\x1b[31md\u0085e"}, "abc[31mde"},
+
+		{EscapeANSI, "%v", []interface{}{"\x1b[31mred\x1b[0m text"}, "red text"},
+		{EscapeANSI, "%v", []interface{}{"a\nb\tc\rd\u0085"}, "a\nb\tc\\x0dd\\x85"},
+		{EscapeANSI, "%v", []interface{}{"\x1b]0;title\atext"}, "text"},
+		{EscapeANSI, "%v", []interface{}{"\x1b]8;;http://x\x1b\\link"}, "link"},
+		{EscapeANSI, "%v", []interface{}{splitWriter{"\x1b", "[1;3", "1mbold"}}, "bold"},
+		{EscapeANSI, "\x1b[1m%v\x1b[0m", []interface{}{"\x1b[2J"}, "\x1b[1m\x1b[0m"},
+
+		{EscapeHTML, "<b>%v</b>", []interface{}{`<i>'a' & "b"</i>`}, "&lt;b&gt;&lt;i&gt;&#39;a&#39; &amp; &#34;b&#34;&lt;/i&gt;&lt;/b&gt;"},
+		{EscapeHTML, "%{user|<none>}", nil, "&lt;none&gt;"},
+		{EscapeHTML, "[%{user:14s|<none>}]", nil, "[  &lt;none&gt;]"},
+		{EscapeHTML, "ä %v", []interface{}{"ö"}, "ä ö"},
+	}
+
+	for i, test := range cases {
+		t.Run(fmt.Sprintf("%d: %q", i, test.out), func(t *testing.T) {
+			p := Printer{Escape: test.mode}
+			out, _ := p.Sprintf(nil, test.fmt, test.args...)
+			if out != test.out {
+				t.Errorf("Sprintf(%q, %q) = %q, want %q", test.fmt, test.args, out, test.out)
+			}
+		})
+	}
+}
+
+func TestEscapeJSONRoundtrip(t *testing.T) {
+	values := []interface{}{
+		"simple",
+		"quotes \" and \\ backslash",
+		"new\nlines\r\n and\ttabs",
+		"\x00\x01\x1f\x7f",
+		"unicode ä ö ü € 𝄞",
+		map[string]string{"a": "b\n"},
+	}
+
+	p := Printer{Escape: EscapeJSON}
+	for _, v := range values {
+		want, _ := Sprintf(nil, "value: %v", v)
+		escaped, _ := p.Sprintf(nil, "value: %v", v)
+
+		var got string
+		if err := json.Unmarshal([]byte(`"`+escaped+`"`), &got); err != nil {
+			t.Errorf("failed to decode %q: %v", escaped, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("roundtrip failed. Want %q, got %q", want, got)
+		}
+	}
+}
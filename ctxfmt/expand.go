@@ -0,0 +1,138 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+package ctxfmt
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/urso/diag"
+)
+
+// reportExpanded reports the exported fields of a struct, or the entries of a
+// map, as separate fields to the callback. The field names are prefixed
+// with the name of the field-spec, e.g. `req.Method`. Dots in map keys are
+// escaped with diag.EscapeKey.
+// Values that are neither struct nor map are reported as is.
+func (in *interpreter) reportExpanded(fi FieldInfo) {
+	v := reflect.ValueOf(fi.Value)
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		in.reportStructFields(fi, fi.Key, v)
+	case reflect.Map:
+		for iter := newMapIter(v); iter.Next(); {
+			key := iter.Key()
+			name := ""
+			if key.Kind() == reflect.String {
+				name = key.String()
+			} else {
+				name = fmt.Sprint(key)
+			}
+			in.reportExpandedField(fi, fi.Key+"."+diag.EscapeKey(name), iter.Value())
+		}
+	default:
+		in.report(fi)
+	}
+}
+
+// reportStructFields reports all exported fields of the struct v. Fields
+// can be renamed or ignored via the `diag` struct tag, using the syntax
+// `diag:"name,omitempty"`, similar to encoding/json. The tag `diag:"-"`
+// ignores the field. Embedded structs without tag are flattened.
+func (in *interpreter) reportStructFields(fi FieldInfo, prefix string, v reflect.Value) {
+	typ := v.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.PkgPath != "" && !field.Anonymous { // unexported
+			continue
+		}
+
+		tag := field.Tag.Get("diag")
+		if tag == "-" {
+			continue
+		}
+
+		name, opts := tag, ""
+		if idx := strings.IndexByte(tag, ','); idx >= 0 {
+			name, opts = tag[:idx], tag[idx+1:]
+		}
+
+		value := v.Field(i)
+		if field.Anonymous && name == "" {
+			embedded := value
+			if embedded.Kind() == reflect.Ptr {
+				if embedded.IsNil() {
+					continue
+				}
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				in.reportStructFields(fi, prefix, embedded)
+				continue
+			}
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+		if hasTagOption(opts, "omitempty") && isEmptyValue(value) {
+			continue
+		}
+
+		in.reportExpandedField(fi, prefix+"."+name, value)
+	}
+}
+
+func (in *interpreter) reportExpandedField(fi FieldInfo, key string, v reflect.Value) {
+	fi.Key = key
+	fi.Value = v.Interface()
+	if in.cfg.redacts(key) && in.cfg.Redact.MaskCallback {
+		fi.Value = in.cfg.Redact.mask(key, fi.Value)
+	}
+	in.report(fi)
+}
+
+func hasTagOption(opts, name string) bool {
+	for opts != "" {
+		var opt string
+		if idx := strings.IndexByte(opts, ','); idx >= 0 {
+			opt, opts = opts[:idx], opts[idx+1:]
+		} else {
+			opt, opts = opts, ""
+		}
+		if opt == name {
+			return true
+		}
+	}
+	return false
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+package ctxfmt
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type testRequest struct {
+	Method  string
+	URL     string `diag:"url"`
+	Body    []byte `diag:"-"`
+	Agent   string `diag:"agent,omitempty"`
+	Retries int    `diag:",omitempty"`
+	testMeta
+	secret string
+}
+
+type testMeta struct {
+	ID int
+}
+
+func TestExpandFields(t *testing.T) {
+	type kv struct {
+		Key   string
+		Value interface{}
+	}
+
+	req := testRequest{Method: "GET", URL: "/", Body: []byte("x"), testMeta: testMeta{ID: 7}, secret: "s"}
+
+	cases := map[string]struct {
+		printer Printer
+		fmt     string
+		args    []interface{}
+		out     string
+		fields  []kv
+	}{
+		"struct": {
+			fmt:  "%{*req}",
+			args: []interface{}{req},
+			out:  "{GET / [120]  0 {7} s}",
+			fields: []kv{
+				{"req.Method", "GET"},
+				{"req.url", "/"},
+				{"req.ID", 7},
+			},
+		},
+		"pointer with flags": {
+			fmt:  "%{*+req}",
+			args: []interface{}{&testRequest{Method: "POST", Agent: "curl", Retries: 2}},
+			out:  "&{Method:POST URL: Body:[] Agent:curl Retries:2 testMeta:{ID:0} secret:}",
+			fields: []kv{
+				{"req.Method", "POST"},
+				{"req.url", ""},
+				{"req.agent", "curl"},
+				{"req.Retries", 2},
+				{"req.ID", 0},
+			},
+		},
+		"map": {
+			fmt:  "%{*labels}",
+			args: []interface{}{map[string]int{"b": 2, "a": 1}},
+			out:  "map[a:1 b:2]",
+			fields: []kv{
+				{"labels.a", 1},
+				{"labels.b", 2},
+			},
+		},
+		"map with dotted keys": {
+			fmt:    "%{*hosts}",
+			args:   []interface{}{map[string]bool{"db.example.com": true}},
+			out:    "map[db.example.com:true]",
+			fields: []kv{{`hosts.db\.example\.com`, true}},
+		},
+		"map with int keys": {
+			fmt:    "%{*m:d}",
+			args:   []interface{}{map[int]string{2: "b", 1: "a"}},
+			out:    "map[1:%!d(string=a) 2:%!d(string=b)]",
+			fields: []kv{{"m.1", "a"}, {"m.2", "b"}},
+		},
+		"primitive": {
+			fmt:    "%{*n}",
+			args:   []interface{}{3},
+			out:    "3",
+			fields: []kv{{"n", 3}},
+		},
+		"redacted sub-field": {
+			printer: Printer{Redact: &Redaction{Patterns: []string{"*.url"}, MaskCallback: true}},
+			fmt:     "%{*req}",
+			args:    []interface{}{testRequest{Method: "GET", URL: "/admin"}},
+			out:     "{GET /admin []  0 {0} }",
+			fields: []kv{
+				{"req.Method", "GET"},
+				{"req.url", "[REDACTED]"},
+				{"req.ID", 0},
+			},
+		},
+		"redacted field is not expanded": {
+			printer: Printer{Redact: &Redaction{Names: []string{"req"}, MaskCallback: true}},
+			fmt:     "%{*req}",
+			args:    []interface{}{req},
+			out:     "[REDACTED]",
+			fields:  []kv{{"req", "[REDACTED]"}},
+		},
+	}
+
+	for name, test := range cases {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			var fields []kv
+			out, _ := test.printer.Sprintf(func(key string, _ int, val interface{}) {
+				fields = append(fields, kv{key, val})
+			}, test.fmt, test.args...)
+
+			if out != test.out {
+				t.Errorf("output mismatch: got <%s> want <%s>", out, test.out)
+			}
+			if diff := cmp.Diff(test.fields, fields); diff != "" {
+				t.Errorf("fields mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
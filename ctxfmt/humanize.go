@@ -0,0 +1,152 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+package ctxfmt
+
+import (
+	"strconv"
+	"time"
+)
+
+var (
+	iecUnits = [...]string{"B", "KiB", "MiB", "GiB", "TiB", "PiB", "EiB"}
+	siUnits  = [...]string{"B", "kB", "MB", "GB", "TB", "PB", "EB"}
+)
+
+var durationUnits = [...]struct {
+	d    uint64
+	name string
+}{
+	{uint64(24 * time.Hour), "d"},
+	{uint64(time.Hour), "h"},
+	{uint64(time.Minute), "m"},
+	{uint64(time.Second), "s"},
+	{uint64(time.Millisecond), "ms"},
+	{uint64(time.Microsecond), "µs"},
+	{uint64(time.Nanosecond), "ns"},
+}
+
+// fmtByteSize prints v as byte size using IEC units (KiB, MiB, ...). SI units
+// (kB, MB, ...) are used if the '#' flag is set. Sizes of 1KiB or more are
+// printed with one decimal, unless a precision is configured.
+func (in *interpreter) fmtByteSize(tok *formatToken, v float64) {
+	flags := &tok.flags
+
+	base, units := 1024.0, iecUnits[:]
+	if flags.sharp {
+		base, units = 1000.0, siUnits[:]
+	}
+
+	buf := in.fmtBuf[:0]
+	if v < 0 {
+		buf = append(buf, '-')
+		v = -v
+	} else if flags.plus {
+		buf = append(buf, '+')
+	} else if flags.space {
+		buf = append(buf, ' ')
+	}
+
+	i := 0
+	for v >= base && i < len(units)-1 {
+		v /= base
+		i++
+	}
+
+	precision := 0
+	if flags.hasPrecision {
+		precision = tok.precision
+	} else if i > 0 {
+		precision = 1
+	}
+
+	buf = strconv.AppendFloat(buf, v, 'f', precision, 64)
+	buf = append(buf, ' ')
+	buf = append(buf, units[i]...)
+	in.formatPad(tok, buf)
+}
+
+// fmtDuration prints v as compact duration, e.g. `2d3h` or `1m30s`.
+// The value is interpreted as nanoseconds. Only the two most significant units
+// are printed, unless a precision is configured. Units with value 0 are
+// omitted, but still count towards the precision.
+func (in *interpreter) fmtDuration(tok *formatToken, v uint64) {
+	flags := &tok.flags
+
+	buf := in.fmtBuf[:0]
+	if int64(v) < 0 {
+		buf = append(buf, '-')
+		v = -v
+	} else if flags.plus {
+		buf = append(buf, '+')
+	} else if flags.space {
+		buf = append(buf, ' ')
+	}
+
+	if v == 0 {
+		buf = append(buf, "0s"...)
+		in.formatPad(tok, buf)
+		return
+	}
+
+	n := 2
+	if flags.hasPrecision && tok.precision > 0 {
+		n = tok.precision
+	}
+
+	started := false
+	for _, unit := range durationUnits {
+		if n == 0 {
+			break
+		}
+		if v < unit.d {
+			if started {
+				n--
+			}
+			continue
+		}
+
+		buf = strconv.AppendUint(buf, v/unit.d, 10)
+		buf = append(buf, unit.name...)
+		v %= unit.d
+		started = true
+		n--
+	}
+
+	in.formatPad(tok, buf)
+}
+
+// groupDigits inserts thousands separators into the integer part of the
+// formatted number in buf. The first byte in buf must be the sign.
+func groupDigits(buf []byte) []byte {
+	end := 1
+	for end < len(buf) && '0' <= buf[end] && buf[end] <= '9' {
+		end++
+	}
+
+	digits := end - 1
+	if digits <= 3 {
+		return buf
+	}
+
+	seps := (digits - 1) / 3
+	n := len(buf)
+	for i := 0; i < seps; i++ {
+		buf = append(buf, 0)
+	}
+	copy(buf[end+seps:], buf[end:n])
+
+	j := end + seps
+	for i, count := end-1, 1; i >= 1; i, count = i-1, count+1 {
+		j--
+		buf[j] = buf[i]
+		if count%3 == 0 && i > 1 {
+			j--
+			buf[j] = ','
+		}
+	}
+	return buf
+}
@@ -0,0 +1,89 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+package ctxfmt
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/urso/diag"
+)
+
+func TestHumanize(t *testing.T) {
+	cases := []struct {
+		fmt  string
+		args []interface{}
+		out  string
+	}{
+		// byte sizes
+		{"%B", []interface{}{0}, "0 B"},
+		{"%B", []interface{}{1023}, "1023 B"},
+		{"%B", []interface{}{1536}, "1.5 KiB"},
+		{"%{size:B}", []interface{}{uint64(1572864)}, "1.5 MiB"},
+		{"%#B", []interface{}{1500000}, "1.5 MB"},
+		{"%#B", []interface{}{999}, "999 B"},
+		{"%.2B", []interface{}{int64(5 << 30)}, "5.00 GiB"},
+		{"%B", []interface{}{-2048}, "-2.0 KiB"},
+		{"%+B", []interface{}{2048}, "+2.0 KiB"},
+		{"%10B", []interface{}{2048}, "   2.0 KiB"},
+		{"%-10B|", []interface{}{2048}, "2.0 KiB   |"},
+		{"%B", []interface{}{float64(1 << 20)}, "1.0 MiB"},
+		{"%B", []interface{}{uint64(1 << 63)}, "8.0 EiB"},
+		{"%B", []interface{}{"str"}, "%!B(string=str)"},
+
+		// durations
+		{"%D", []interface{}{time.Duration(0)}, "0s"},
+		{"%D", []interface{}{250 * time.Millisecond}, "250ms"},
+		{"%D", []interface{}{1500 * time.Millisecond}, "1s500ms"},
+		{"%D", []interface{}{90 * time.Second}, "1m30s"},
+		{"%D", []interface{}{time.Hour + 5*time.Second}, "1h"},
+		{"%D", []interface{}{51*time.Hour + 20*time.Minute}, "2d3h"},
+		{"%.1D", []interface{}{51*time.Hour + 20*time.Minute}, "2d"},
+		{"%.3D", []interface{}{51*time.Hour + 20*time.Minute}, "2d3h20m"},
+		{"%D", []interface{}{-90 * time.Second}, "-1m30s"},
+		{"%D", []interface{}{1234}, "1µs234ns"},
+		{"%8D|", []interface{}{90 * time.Second}, "   1m30s|"},
+		{"%{took:D}", []interface{}{diag.ValDuration(3 * time.Minute)}, "3m"},
+		{"%D", []interface{}{1.5}, "%!D(float64=1.5)"},
+
+		// grouping
+		{"%'d", []interface{}{1234567}, "1,234,567"},
+		{"%{n:'d}", []interface{}{1234567}, "1,234,567"},
+		{"%'d", []interface{}{-1234}, "-1,234"},
+		{"%'d", []interface{}{123}, "123"},
+		{"%'d", []interface{}{uint64(1000)}, "1,000"},
+		{"%'v", []interface{}{int64(987654321)}, "987,654,321"},
+		{"%'12d|", []interface{}{1234567}, "   1,234,567|"},
+		{"%'.6d", []interface{}{12345}, "012,345"},
+		{"%'.9d", []interface{}{12345}, "000,012,345"},
+		{"%'.9d", []interface{}{-12345}, "-000,012,345"},
+		{"%'.2d", []interface{}{12345}, "12,345"},
+		{"%'010d", []interface{}{12345}, "00,012,345"},
+		{"%'09d", []interface{}{12345}, "0,012,345"},
+		{"%'08d", []interface{}{12345}, "0,012,345"},
+		{"%'012d", []interface{}{1234567}, "0,001,234,567"},
+		{"%'011d", []interface{}{1234567}, "001,234,567"},
+		{"%'012d", []interface{}{-1234567}, "-001,234,567"},
+		{"%'+08d", []interface{}{12345}, "+012,345"},
+		{"%'x", []interface{}{1234567}, "12d687"},
+		{"%'.2f", []interface{}{1234567.891}, "1,234,567.89"},
+		{"%'f", []interface{}{-1234.5}, "-1,234.500000"},
+		{"%'v", []interface{}{12345.5}, "12,345.5"},
+		{"%'.1f", []interface{}{999.9}, "999.9"},
+		{"%'e", []interface{}{1234.5}, "1.234500e+03"},
+	}
+
+	for i, test := range cases {
+		t.Run(fmt.Sprintf("%d: %v -> %v", i, test.fmt, test.out), func(t *testing.T) {
+			out, _ := Sprintf(nil, test.fmt, test.args...)
+			if out != test.out {
+				t.Errorf("Sprintf(%q, %v) = <%s> want <%s>", test.fmt, test.args, out, test.out)
+			}
+		})
+	}
+}
@@ -12,51 +12,361 @@ package ctxfmt
 
 import (
 	"fmt"
+	"io"
 	"reflect"
 	"strconv"
+	"sync"
 	"unicode/utf8"
+
+	"github.com/urso/diag"
 )
 
 type interpreter struct {
-	p    *printer
-	args argstate
-	st   state
-	cb   CB
+	cfg     *Printer
+	p       printer
+	args    argstate
+	st      state
+	cb      CB
+	fieldCB FieldCB
+
+	// bindings holds the named fields printed so far. Back-references are
+	// resolved using the bindings.
+	bindings []binding
+
+	fmtBuf  [128]byte
+	scratch []byte // output buffer reused by Sprintf
+
+	// padded holds the state of a value being captured, such that padding can
+	// be applied after escaping.
+	padded padState
+}
+
+// padState stores the output of the printer while a value is captured into
+// buf.
+type padState struct {
+	active  bool
+	tok     formatToken // token used to format the captured value
+	buf     []byte
+	to      io.Writer
+	out     []byte
+	written int
+}
+
+var interpreterPool = sync.Pool{
+	New: func() interface{} { return new(interpreter) },
+}
 
-	fmtBuf [128]byte
+// maxScratchSize is the max capacity of the output buffer an interpreter can
+// keep, when being returned to the pool.
+const maxScratchSize = 64 << 10
+
+func newInterpreter(cfg *Printer, cb CB, fieldCB FieldCB, args argstate) *interpreter {
+	in := interpreterPool.Get().(*interpreter)
+	in.cfg = cfg
+	in.cb = cb
+	in.fieldCB = fieldCB
+	in.args = args
+	return in
+}
+
+// free resets the interpreter and returns it to the pool. The interpreter
+// must not be used after free has been called.
+func (in *interpreter) free() {
+	if cap(in.scratch) > maxScratchSize {
+		in.scratch = nil
+	}
+	if cap(in.padded.buf) > maxScratchSize {
+		in.padded.buf = nil
+	}
+
+	in.cfg = nil
+	in.cb = nil
+	in.fieldCB = nil
+	in.args = argstate{}
+	in.st = state{}
+	for i := range in.bindings {
+		in.bindings[i] = binding{}
+	}
+	in.bindings = in.bindings[:0]
+	in.p.reset()
+	interpreterPool.Put(in)
 }
 
 type state struct {
 	inError bool
 	inPanic bool
+	nesting int // nesting level of composite values
 	arg     interface{}
 	val     reflect.Value
 }
 
+type binding struct {
+	key string
+	arg interface{}
+
+	// missing is set if the field was optional or had a default value, but no
+	// argument was given. The default value, if any, is stored in def.
+	missing bool
+	def     string
+}
+
 type formatterState struct {
 	*printer
-	tok *formatToken
+	tok formatToken
 }
 
 const lHexDigits = "0123456789abcdefx"
 const uHexDigits = "0123456789ABCDEFX"
 
+// run interprets the format string and returns the list of unprocessed
+// arguments. Errors found in the unprocessed arguments are reported to the
+// callback.
+func (in *interpreter) run(msg string) (rest []interface{}) {
+	parser := parser{handler: in, verbs: in.cfg.validVerbs}
+	parser.parse(msg)
+
+	used := in.args.idx
+	if used >= len(in.args.args) || in.args.names != nil {
+		return nil
+	}
+
+	// collect errors from extra variables
+	rest = in.args.args[used:]
+	for i := range rest {
+		if isErrorValue(rest[i]) {
+			in.report(FieldInfo{Index: used + i, Value: rest[i], Start: -1, End: -1})
+		}
+	}
+	return rest
+}
+
 func (in *interpreter) onString(s string) {
+	in.beginText()
 	in.p.WriteString(s)
+	in.endText()
 }
 
 func (in *interpreter) onToken(tok formatToken) {
-	arg, argIdx, exists := in.args.next()
+	if tok.flags.backref {
+		in.onBackref(&tok)
+		return
+	}
+
+	var (
+		arg    interface{}
+		argIdx int
+		exists bool
+	)
+	if tok.flags.named && in.args.names != nil {
+		arg, argIdx, exists = in.args.named(tok.field)
+	} else {
+		arg, argIdx, exists = in.args.next()
+	}
+	if (tok.flags.optional || tok.flags.hasDefault) && (!exists || isNilValue(arg)) {
+		if tok.flags.named {
+			in.bindings = append(in.bindings, binding{key: tok.field, missing: true, def: tok.def})
+		}
+		if tok.flags.hasDefault {
+			in.fmtDefault(&tok, tok.def)
+		}
+		return
+	}
+
 	if !exists {
 		in.formatErr(&tok, exists, arg, errMissingArg)
 		return
 	}
 
-	if tok.flags.named || isErrorValue(arg) || isFieldValue(arg) {
-		in.cb(tok.field, argIdx, arg)
+	if tok.flags.named {
+		in.bindings = append(in.bindings, binding{key: tok.field, arg: arg})
 	}
 
-	in.formatArg(&tok, arg)
+	value := arg
+	redact := tok.flags.named && in.cfg.redacts(tok.field)
+	var masked string
+	if redact {
+		masked = in.cfg.Redact.mask(tok.field, arg)
+		if in.cfg.Redact.MaskCallback {
+			value = masked
+		}
+	}
+
+	report := tok.flags.named || isErrorValue(arg) || isFieldValue(arg)
+	fi := FieldInfo{
+		Key:          tok.field,
+		Index:        argIdx,
+		Value:        value,
+		Verb:         tok.verb,
+		Width:        tok.width,
+		Precision:    tok.precision,
+		HasWidth:     tok.flags.hasWidth,
+		HasPrecision: tok.flags.hasPrecision,
+		flags:        tok.flags,
+	}
+
+	// CB is called before the value is printed. FieldCB is called after the
+	// value has been printed, such that its position can be reported.
+	if report && in.fieldCB == nil {
+		in.reportField(fi)
+	}
+
+	if tok.flags.named {
+		in.openField(tok.field, arg)
+	}
+
+	fi.Start = in.p.written
+	argTok := in.beginArg(&tok)
+	if redact {
+		in.fmtStr(argTok, masked)
+	} else {
+		in.formatArg(argTok, arg)
+	}
+	in.endArg(&tok)
+	fi.End = in.p.written
+
+	if tok.flags.named {
+		in.closeField(tok.field, arg)
+	}
+
+	if report && in.fieldCB != nil {
+		in.reportField(fi)
+	}
+}
+
+// reportField reports a captured field. Fields with the expand flag are
+// reported as one field per struct field or map entry, unless the masked
+// value is reported.
+func (in *interpreter) reportField(fi FieldInfo) {
+	if fi.flags.expand && !(in.cfg.redacts(fi.Key) && in.cfg.Redact.MaskCallback) {
+		in.reportExpanded(fi)
+	} else {
+		in.report(fi)
+	}
+}
+
+// onBackref prints the value of a field bound by an earlier field-spec.
+// Back-references do not trigger the callback.
+func (in *interpreter) onBackref(tok *formatToken) {
+	for i := len(in.bindings) - 1; i >= 0; i-- {
+		if b := &in.bindings[i]; b.key == tok.field {
+			if b.missing {
+				in.fmtDefault(tok, b.def)
+				return
+			}
+			in.openField(tok.field, b.arg)
+			argTok := in.beginArg(tok)
+			if in.cfg.redacts(tok.field) {
+				in.fmtStr(argTok, in.cfg.Redact.mask(tok.field, b.arg))
+			} else {
+				in.formatArg(argTok, b.arg)
+			}
+			in.endArg(tok)
+			in.closeField(tok.field, b.arg)
+			return
+		}
+	}
+
+	switch {
+	case tok.flags.hasDefault:
+		in.fmtDefault(tok, tok.def)
+	case tok.flags.optional:
+		// unbound optional reference => print nothing
+	default:
+		in.formatErr(tok, false, nil, errUnboundField)
+	}
+}
+
+// beginArg marks the start of an interpolated argument in the output. The
+// argument must be formatted using the returned token.
+func (in *interpreter) beginArg(tok *formatToken) *formatToken {
+	in.p.setEscaping(in.cfg.Escape)
+	if max := in.cfg.Limits.MaxBytes; max > 0 {
+		in.p.setLimit(max)
+	}
+	return in.beginPadded(tok)
+}
+
+// endArg marks the end of an interpolated argument in the output.
+func (in *interpreter) endArg(tok *formatToken) {
+	truncated := in.p.truncated
+	in.p.setEscaping(EscapeNone)
+	in.p.setLimit(-1)
+	if truncated {
+		in.p.WriteString(truncatedMarker)
+	}
+	in.endPadded(tok)
+}
+
+// fmtDefault prints the default value of a field-spec.
+func (in *interpreter) fmtDefault(tok *formatToken, def string) {
+	in.beginText()
+	in.fmtStr(in.beginPadded(tok), def)
+	in.endText()
+	in.endPadded(tok)
+}
+
+// beginPadded prepares the printer for printing a value padded to the width
+// configured in tok. If the value is escaped or truncated, the padding must be
+// computed from the final value. In this case the value is captured into a
+// buffer, and the returned token has no width set. The padding is applied by
+// endPadded.
+// Zero padding is considered part of the value and is always printed by the
+// formatter.
+func (in *interpreter) beginPadded(tok *formatToken) *formatToken {
+	if !tok.flags.hasWidth || (tok.flags.zero && !tok.flags.minus) || in.p.direct() {
+		return tok
+	}
+
+	st := &in.padded
+	st.active = true
+	st.tok = *tok
+	st.tok.flags.hasWidth, st.tok.flags.zero, st.tok.width = false, false, 0
+	st.to, st.out, st.written = in.p.To, in.p.buf, in.p.written
+	in.p.To, in.p.buf = nil, st.buf[:0]
+	return &st.tok
+}
+
+// endPadded writes the value captured since beginPadded, padded to the width
+// configured in tok.
+func (in *interpreter) endPadded(tok *formatToken) {
+	st := &in.padded
+	if !st.active {
+		return
+	}
+
+	val := in.p.buf
+	st.buf = val[:0]
+	in.p.To, in.p.buf, in.p.written = st.to, st.out, st.written
+	st.active, st.to, st.out = false, nil, nil
+
+	width := tok.width - utf8.RuneCount(val)
+	if !tok.flags.minus {
+		in.p.pad(width, ' ')
+	}
+	in.p.Write(val)
+	if tok.flags.minus {
+		in.p.pad(width, ' ')
+	}
+}
+
+// beginText enables escaping of literal text and defaults, if required by the
+// escaping policy.
+func (in *interpreter) beginText() {
+	in.p.setEscaping(in.cfg.Escape.textEscaping())
+}
+
+// endText marks the end of literal text in the output.
+func (in *interpreter) endText() {
+	in.p.setEscaping(EscapeNone)
+}
+
+// report passes the captured field to the configured callback.
+func (in *interpreter) report(fi FieldInfo) {
+	if in.fieldCB != nil {
+		in.fieldCB(fi)
+	} else if in.cb != nil {
+		in.cb(fi.Key, fi.Index, fi.Value)
+	}
 }
 
 func (in *interpreter) onParseError(tok formatToken, err error) {
@@ -86,6 +396,12 @@ func (in *interpreter) formatErr(tok *formatToken, hasArg bool, arg interface{},
 		in.p.WriteString("%!")
 		in.p.WriteRune(tok.verb)
 		in.p.WriteString("(MISSING)")
+	case errUnboundField:
+		in.p.WriteString("%!")
+		in.p.WriteRune(tok.verb)
+		in.p.WriteString("(UNBOUND=")
+		in.p.WriteString(tok.field)
+		in.p.WriteByte(')')
 	}
 }
 
@@ -100,7 +416,8 @@ func (in *interpreter) formatErrArg(tok *formatToken, arg interface{}) {
 	in.p.WriteByte('=')
 	tmpTok := *tok
 	tmpTok.verb = 'v'
-	in.formatArg(&tmpTok, arg)
+	in.formatArg(in.beginArg(&tmpTok), arg)
+	in.endArg(&tmpTok)
 	in.p.WriteByte(')')
 }
 
@@ -109,6 +426,10 @@ func (in *interpreter) formatArg(tok *formatToken, arg interface{}) {
 	in.st.val = reflect.Value{}
 	verb := tok.verb
 
+	if in.handleCustom(tok, arg) {
+		return
+	}
+
 	if arg == nil {
 		switch verb {
 		case 'T', 'v':
@@ -168,7 +489,16 @@ func (in *interpreter) formatArg(tok *formatToken, arg interface{}) {
 	case complex128:
 		in.fmtComplex(tok, value, 128)
 	case reflect.Value:
+		if value.IsValid() && value.CanInterface() && in.handleCustom(tok, value.Interface()) {
+			return
+		}
 		in.fmtValue(tok, value, 0)
+	case diag.Value:
+		in.fmtDiagValue(tok, &value)
+	case diag.Field:
+		in.fmtDiagField(tok, &value)
+	case *diag.Context:
+		in.fmtDiagContext(tok, value)
 
 	default:
 		in.fmtValue(tok, reflect.ValueOf(arg), 0)
@@ -176,13 +506,21 @@ func (in *interpreter) formatArg(tok *formatToken, arg interface{}) {
 }
 
 func (in *interpreter) fmtValue(tok *formatToken, v reflect.Value, depth int) {
-	if in.handleMethods(tok, v) {
+	if in.handleMethods(tok, v, depth) {
 		return
 	}
 
 	in.st.arg = nil
 	in.st.val = v
 
+	if in.cfg.Limits.MaxDepth > 0 {
+		if !in.enterNesting(v) {
+			in.p.WriteString(truncatedMarker)
+			return
+		}
+		defer in.leaveNesting(v)
+	}
+
 	verb, flags := tok.verb, &tok.flags
 
 	switch v.Kind() {
@@ -257,6 +595,10 @@ func (in *interpreter) fmtValue(tok *formatToken, v reflect.Value, depth int) {
 					in.p.WriteByte(' ')
 				}
 			}
+			if in.tooManyElements(i) {
+				in.fmtMore(v.Len() - i)
+				break
+			}
 
 			key := iter.Key()
 			val := iter.Value()
@@ -336,6 +678,10 @@ func (in *interpreter) fmtValue(tok *formatToken, v reflect.Value, depth int) {
 				if i > 0 {
 					in.p.WriteString(", ")
 				}
+				if in.tooManyElements(i) {
+					in.fmtMore(v.Len() - i)
+					break
+				}
 				in.fmtValue(tok, v.Index(i), depth+1)
 			}
 			in.p.WriteByte('}')
@@ -347,13 +693,20 @@ func (in *interpreter) fmtValue(tok *formatToken, v reflect.Value, depth int) {
 			if i > 0 {
 				in.p.WriteByte(' ')
 			}
+			if in.tooManyElements(i) {
+				in.fmtMore(v.Len() - i)
+				break
+			}
 			in.fmtValue(tok, v.Index(i), depth+1)
 		}
 		in.p.WriteByte(']')
 	}
 }
 
-func (in *interpreter) handleMethods(tok *formatToken, v reflect.Value) bool {
+// handleMethods formats v using custom formatters or the formatting
+// interfaces implemented by v. Custom formatters for top-level arguments
+// are already handled by formatArg.
+func (in *interpreter) handleMethods(tok *formatToken, v reflect.Value, depth int) bool {
 	flags := &tok.flags
 
 	if in.st.inError || !v.IsValid() || !v.CanInterface() {
@@ -361,9 +714,13 @@ func (in *interpreter) handleMethods(tok *formatToken, v reflect.Value) bool {
 	}
 
 	arg := v.Interface()
+	if depth > 0 && in.handleCustom(tok, arg) {
+		return true
+	}
+
 	if formatter, ok := arg.(fmt.Formatter); ok {
 		defer in.recoverPanic(tok, arg)
-		formatter.Format(&formatterState{in.p, tok}, rune(tok.verb))
+		formatter.Format(&formatterState{&in.p, *tok}, rune(tok.verb))
 		return true
 	}
 
@@ -396,8 +753,28 @@ func (in *interpreter) handleMethods(tok *formatToken, v reflect.Value) bool {
 	return true
 }
 
+// handleCustom formats arg using the custom formatters registered with the
+// Printer. The formatter registered for the type of arg is tried first,
+// followed by the formatter registered for the verb. It returns false if no
+// custom formatter did handle the value.
+func (in *interpreter) handleCustom(tok *formatToken, arg interface{}) bool {
+	if fn := in.cfg.lookupType(arg); fn != nil && in.callCustom(fn, tok, arg) {
+		return true
+	}
+	if fn := in.cfg.lookupVerb(tok.verb); fn != nil && in.callCustom(fn, tok, arg) {
+		return true
+	}
+	return false
+}
+
+func (in *interpreter) callCustom(fn FormatFunc, tok *formatToken, arg interface{}) (handled bool) {
+	handled = true // report value as handled if fn panics
+	defer in.recoverPanic(tok, arg)
+	return fn(&formatterState{&in.p, *tok}, tok.verb, arg)
+}
+
 func (in *interpreter) recoverPanic(tok *formatToken, arg interface{}) {
-	p := in.p
+	p := &in.p
 
 	if err := recover(); err != nil {
 		if v := reflect.ValueOf(arg); v.Kind() == reflect.Ptr && v.IsNil() {
@@ -467,6 +844,14 @@ func (in *interpreter) fmtInt(tok *formatToken, v uint64, signed bool) {
 		}
 	case 'U':
 		in.fmtUnicode(tok, v)
+	case 'B':
+		if signed {
+			in.fmtByteSize(tok, float64(int64(v)))
+		} else {
+			in.fmtByteSize(tok, float64(v))
+		}
+	case 'D':
+		in.fmtDuration(tok, v)
 	default:
 		in.formatBadVerb(tok)
 	}
@@ -553,6 +938,9 @@ func (in *interpreter) fmtIntBase(tok *formatToken, v uint64, base int, signed b
 	buf := in.fmtBuf[:]
 	if flags.hasWidth || flags.hasPrecision {
 		width := 3 + tok.width + tok.precision
+		if flags.grouping {
+			width += width / 3
+		}
 		if width > len(buf) {
 			buf = make([]byte, width)
 		}
@@ -574,13 +962,18 @@ func (in *interpreter) fmtIntBase(tok *formatToken, v uint64, base int, signed b
 
 	// print right-to-left
 	i := len(buf)
+	ndigits := 1
 	switch base {
 	case 10:
-		for v >= 10 {
+		for ; v >= 10; ndigits++ {
 			next := v / 10
 			i--
 			buf[i] = byte('0' + v - next*10)
 			v = next
+			if flags.grouping && ndigits%3 == 0 {
+				i--
+				buf[i] = ','
+			}
 		}
 	case 16:
 		for ; v >= 16; v >>= 4 {
@@ -604,9 +997,31 @@ func (in *interpreter) fmtIntBase(tok *formatToken, v uint64, base int, signed b
 	buf[i] = digits[v]
 
 	// left-pad zeros
-	for i > 0 && precision > len(buf)-i {
-		i--
-		buf[i] = '0'
+	if flags.grouping && base == 10 {
+		// The precision counts digits only. Zero padding to the width counts
+		// separators as well. The padding must not start with a separator, so
+		// the output is one byte wider than the width if the width ends at a
+		// separator.
+		for i > 1 {
+			if flags.hasPrecision && ndigits >= precision {
+				break
+			}
+			if !flags.hasPrecision && len(buf)-i >= precision {
+				break
+			}
+			if ndigits%3 == 0 {
+				i--
+				buf[i] = ','
+			}
+			i--
+			buf[i] = '0'
+			ndigits++
+		}
+	} else {
+		for i > 0 && precision > len(buf)-i {
+			i--
+			buf[i] = '0'
+		}
 	}
 
 	// '#' triggers prefix
@@ -696,6 +1111,10 @@ func (in *interpreter) fmtBytes(tok *formatToken, typeName string, b []byte) {
 				if i > 0 {
 					in.p.WriteString(", ")
 				}
+				if in.tooManyElements(i) {
+					in.fmtMore(len(b) - i)
+					break
+				}
 				in.fmtHex64(tok, uint64(c), true)
 			}
 			in.p.WriteByte('}')
@@ -705,6 +1124,10 @@ func (in *interpreter) fmtBytes(tok *formatToken, typeName string, b []byte) {
 				if i > 0 {
 					in.p.WriteByte(' ')
 				}
+				if in.tooManyElements(i) {
+					in.fmtMore(len(b) - i)
+					break
+				}
 				in.fmtIntBase(tok, uint64(c), 10, false, lHexDigits)
 			}
 			in.p.WriteByte(']')
@@ -838,6 +1261,8 @@ func (in *interpreter) fmtFloat(tok *formatToken, f float64, sz int) {
 		in.fmtFloatBase(tok, f, sz, verb, 6)
 	case 'F':
 		in.fmtFloatBase(tok, f, sz, 'f', 6)
+	case 'B':
+		in.fmtByteSize(tok, f)
 	default:
 		in.formatBadVerb(tok)
 	}
@@ -917,6 +1342,10 @@ func (in *interpreter) fmtFloatBase(tok *formatToken, f float64, sz int, verb ru
 		buf = append(buf, exp...)
 	}
 
+	if flags.grouping && verb != 'b' && verb != 'x' && verb != 'X' {
+		buf = groupDigits(buf)
+	}
+
 	// print number with sign
 	if flags.plus || buf[0] != '+' {
 		if flags.zero && flags.hasWidth && tok.width > len(buf) {
@@ -1097,23 +1526,7 @@ func (in *interpreter) formatPadding(tok *formatToken, n int) {
 }
 
 func (in *interpreter) formatPaddingWith(n int, padByte byte) {
-	if n <= 0 {
-		return
-	}
-
-	var padBuf [128]byte
-	for n > 0 {
-		buf := padBuf[:]
-		if n < len(padBuf) {
-			buf = buf[:n]
-		}
-		for i := range buf {
-			buf[i] = padByte
-		}
-
-		in.p.Write(buf)
-		n -= len(buf)
-	}
+	in.p.pad(n, padByte)
 }
 
 // Width returns the value of the width option and whether it has been set.
@@ -1128,7 +1541,10 @@ func (f *formatterState) Precision() (prec int, ok bool) {
 
 // Flag reports whether the flag c, a character, has been set.
 func (f *formatterState) Flag(c int) bool {
-	flags := &f.tok.flags
+	return f.tok.flags.has(c)
+}
+
+func (flags *flags) has(c int) bool {
 	switch c {
 	case '-':
 		return flags.minus
@@ -1140,6 +1556,8 @@ func (f *formatterState) Flag(c int) bool {
 		return flags.space
 	case '0':
 		return flags.zero
+	case '\'':
+		return flags.grouping
 	default:
 		return false
 	}
@@ -0,0 +1,65 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+package ctxfmt
+
+import (
+	"reflect"
+	"strconv"
+)
+
+// Limits bounds the size of the output generated for a single argument.
+// A limit is not applied if set to 0.
+type Limits struct {
+	// MaxBytes limits the number of bytes printed per argument. Truncated
+	// values are followed by "...". Padding does not count against the
+	// limit, except for zero padding.
+	MaxBytes int
+
+	// MaxElements limits the number of elements printed for slices, arrays,
+	// and maps. The number of elements omitted is printed as "...N more".
+	MaxElements int
+
+	// MaxDepth limits the nesting depth of structs, maps, slices and arrays.
+	// Values nested deeper are printed as "...".
+	MaxDepth int
+}
+
+const truncatedMarker = "..."
+
+// enterNesting increases the nesting level if v is a composite value.
+// It returns false if the value is too deeply nested and must not be printed.
+func (in *interpreter) enterNesting(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Map, reflect.Struct, reflect.Array, reflect.Slice:
+		if in.st.nesting >= in.cfg.Limits.MaxDepth {
+			return false
+		}
+		in.st.nesting++
+	}
+	return true
+}
+
+func (in *interpreter) leaveNesting(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Map, reflect.Struct, reflect.Array, reflect.Slice:
+		in.st.nesting--
+	}
+}
+
+// tooManyElements checks if the i-th element of a collection must not be
+// printed anymore.
+func (in *interpreter) tooManyElements(i int) bool {
+	max := in.cfg.Limits.MaxElements
+	return max > 0 && i >= max
+}
+
+// fmtMore prints the number of elements omitted from a collection.
+func (in *interpreter) fmtMore(n int) {
+	in.p.WriteString(truncatedMarker)
+	in.p.Write(strconv.AppendInt(in.fmtBuf[:0], int64(n), 10))
+	in.p.WriteString(" more")
+}
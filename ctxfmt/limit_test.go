@@ -0,0 +1,101 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+package ctxfmt
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestLimits(t *testing.T) {
+	type node struct {
+		Name  string
+		Child *node
+	}
+
+	type nested struct {
+		A []int
+		M map[string][]int
+	}
+
+	tree := &node{"a", &node{"b", &node{"c", nil}}}
+
+	cases := []struct {
+		limits Limits
+		fmt    string
+		args   []interface{}
+		out    string
+	}{
+		{Limits{}, "%v", []interface{}{[]int{1, 2, 3, 4, 5}}, "[1 2 3 4 5]"},
+
+		// max bytes
+		{Limits{MaxBytes: 5}, "%v", []interface{}{"hello world"}, "hello..."},
+		{Limits{MaxBytes: 5}, "%v", []interface{}{"hello"}, "hello"},
+		{Limits{MaxBytes: 5}, "%v and %v", []interface{}{"hello world", "bye"}, "hello... and bye"},
+		{Limits{MaxBytes: 5}, "%{msg}: literal text is not truncated", []interface{}{"ok"}, "ok: literal text is not truncated"},
+		{Limits{MaxBytes: 5}, "%v", []interface{}{"äöü"}, "äö..."},
+		{Limits{MaxBytes: 4}, "%8v|", []interface{}{"x"}, "       x|"},
+		{Limits{MaxBytes: 5}, "[%{x:10s}]", []interface{}{"ab"}, "[        ab]"},
+		{Limits{MaxBytes: 5}, "[%{x:-10s}]", []interface{}{"ab"}, "[ab        ]"},
+		{Limits{MaxBytes: 5}, "[%{x:8d}]", []interface{}{42}, "[      42]"},
+		{Limits{MaxBytes: 5}, "[%{x:10s}]", []interface{}{"hello world"}, "[  hello...]"},
+		{Limits{MaxBytes: 5}, "[%{x:-10s}]", []interface{}{"hello world"}, "[hello...  ]"},
+		{Limits{MaxBytes: 5}, "[%{x:08d}]", []interface{}{42}, "[00000...]"},
+		{Limits{MaxBytes: 8}, "%v", []interface{}{[]int{1, 2, 3, 4, 5, 6, 7}}, "[1 2 3 4..."},
+
+		// max elements
+		{Limits{MaxElements: 3}, "%v", []interface{}{[]int{1, 2, 3, 4, 5}}, "[1 2 3 ...2 more]"},
+		{Limits{MaxElements: 3}, "%v", []interface{}{[]int{1, 2, 3}}, "[1 2 3]"},
+		{Limits{MaxElements: 2}, "%#v", []interface{}{[]int{1, 2, 3}}, "[]int{1, 2, ...1 more}"},
+		{Limits{MaxElements: 2}, "%v", []interface{}{[4]string{"a", "b", "c", "d"}}, "[a b ...2 more]"},
+		{Limits{MaxElements: 2}, "%v", []interface{}{map[int]int{1: 1, 2: 2, 3: 3}}, "map[1:1 2:2 ...1 more]"},
+		{Limits{MaxElements: 2}, "%v", []interface{}{[]byte{1, 2, 3}}, "[1 2 ...1 more]"},
+		{Limits{MaxElements: 2}, "%s", []interface{}{[]byte("hello")}, "hello"},
+
+		// max depth
+		{Limits{MaxDepth: 1}, "%v", []interface{}{[][]int{{1}, {2}}}, "[... ...]"},
+		{Limits{MaxDepth: 2}, "%v", []interface{}{[][]int{{1}, {2}}}, "[[1] [2]]"},
+		{Limits{MaxDepth: 2}, "%+v", []interface{}{tree}, "&{Name:a Child:0xPTR}"},
+		{Limits{MaxDepth: 1}, "%+v", []interface{}{nested{A: []int{1}, M: map[string][]int{"a": {1}}}}, "{A:... M:...}"},
+		{Limits{MaxDepth: 2}, "%+v", []interface{}{nested{A: []int{1}, M: map[string][]int{"a": {1}}}}, "{A:[1] M:map[a:...]}"},
+		{Limits{MaxDepth: 1}, "%v %v", []interface{}{[]int{1}, []int{2}}, "[1] [2]"},
+	}
+
+	for i, test := range cases {
+		t.Run(fmt.Sprintf("%d: %v", i, test.out), func(t *testing.T) {
+			p := Printer{Limits: test.limits}
+			out, _ := p.Sprintf(nil, test.fmt, test.args...)
+			if i := strings.Index(out, "0x"); i >= 0 && strings.Contains(test.out, "0xPTR") {
+				j := i + 2
+				for j < len(out) && strings.IndexByte("0123456789abcdef", out[j]) >= 0 {
+					j++
+				}
+				out = out[:i] + "0xPTR" + out[j:]
+			}
+			if out != test.out {
+				t.Errorf("Sprintf(%q, %v) = <%s> want <%s>", test.fmt, test.args, out, test.out)
+			}
+		})
+	}
+}
+
+func TestLimitsEscaped(t *testing.T) {
+	p := Printer{
+		Escape: EscapeJSON,
+		Limits: Limits{MaxBytes: 4},
+	}
+	out, _ := p.Sprintf(nil, "%v", "ab\ncd")
+	if want := `ab\n...`; out != want {
+		t.Errorf("want <%s>, got <%s>", want, out)
+	}
+
+	out, _ = p.Sprintf(nil, "%v", "abc\n")
+	if want := `abc...`; out != want {
+		t.Errorf("escape sequences must not be split: want <%s>, got <%s>", want, out)
+	}
+}
@@ -0,0 +1,203 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctxfmt
+
+import (
+	"reflect"
+	"sort"
+)
+
+// sortedMap holds the keys and values of a map, sorted by key.
+// Keys are ordered using the same rules as the fmt package:
+//   - ints, floats, and strings are ordered by <
+//   - NaN compares less than non-NaN floats
+//   - bool compares false before true
+//   - complex compares real, then imag
+//   - pointers and channels compare by machine address
+//   - structs and arrays compare each field/element in turn
+//   - interface values compare first by reflect.Type describing the concrete
+//     type and then by concrete value as described in the previous rules
+//   - nil compares less than any non-nil value.
+type sortedMap struct {
+	keys   []reflect.Value
+	values []reflect.Value
+	pos    int
+}
+
+func newMapIter(m reflect.Value) *sortedMap {
+	n := m.Len()
+	sm := &sortedMap{
+		keys:   make([]reflect.Value, 0, n),
+		values: make([]reflect.Value, 0, n),
+		pos:    -1,
+	}
+	for iter := m.MapRange(); iter.Next(); {
+		sm.keys = append(sm.keys, iter.Key())
+		sm.values = append(sm.values, iter.Value())
+	}
+	sort.Stable(sm)
+	return sm
+}
+
+func (sm *sortedMap) Next() bool {
+	sm.pos++
+	return sm.pos < len(sm.keys)
+}
+
+func (sm *sortedMap) Key() reflect.Value   { return sm.keys[sm.pos] }
+func (sm *sortedMap) Value() reflect.Value { return sm.values[sm.pos] }
+
+func (sm *sortedMap) Len() int           { return len(sm.keys) }
+func (sm *sortedMap) Less(i, j int) bool { return compare(sm.keys[i], sm.keys[j]) < 0 }
+func (sm *sortedMap) Swap(i, j int) {
+	sm.keys[i], sm.keys[j] = sm.keys[j], sm.keys[i]
+	sm.values[i], sm.values[j] = sm.values[j], sm.values[i]
+}
+
+// compare compares two values of the same type. It returns -1, 0, 1
+// according to whether a > b (1), a == b (0), or a < b (-1).
+// If the types differ, it returns -1.
+func compare(a, b reflect.Value) int {
+	if a.Type() != b.Type() {
+		return -1 // No good answer possible, but don't return 0: they're not equal.
+	}
+
+	switch a.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		a, b := a.Int(), b.Int()
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		default:
+			return 0
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		a, b := a.Uint(), b.Uint()
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		default:
+			return 0
+		}
+	case reflect.String:
+		a, b := a.String(), b.String()
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		default:
+			return 0
+		}
+	case reflect.Float32, reflect.Float64:
+		return floatCompare(a.Float(), b.Float())
+	case reflect.Complex64, reflect.Complex128:
+		a, b := a.Complex(), b.Complex()
+		if c := floatCompare(real(a), real(b)); c != 0 {
+			return c
+		}
+		return floatCompare(imag(a), imag(b))
+	case reflect.Bool:
+		a, b := a.Bool(), b.Bool()
+		switch {
+		case a == b:
+			return 0
+		case a:
+			return 1
+		default:
+			return -1
+		}
+	case reflect.Ptr, reflect.UnsafePointer:
+		return pointerCompare(a.Pointer(), b.Pointer())
+	case reflect.Chan:
+		if c, ok := nilCompare(a, b); ok {
+			return c
+		}
+		return pointerCompare(a.Pointer(), b.Pointer())
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			if c := compare(a.Field(i), b.Field(i)); c != 0 {
+				return c
+			}
+		}
+		return 0
+	case reflect.Array:
+		for i := 0; i < a.Len(); i++ {
+			if c := compare(a.Index(i), b.Index(i)); c != 0 {
+				return c
+			}
+		}
+		return 0
+	case reflect.Interface:
+		if c, ok := nilCompare(a, b); ok {
+			return c
+		}
+		c := compare(reflect.ValueOf(a.Elem().Type()), reflect.ValueOf(b.Elem().Type()))
+		if c != 0 {
+			return c
+		}
+		return compare(a.Elem(), b.Elem())
+	default:
+		// Certain types cannot appear as keys (maps, funcs, slices), but be explicit.
+		panic("bad type in compare: " + a.Type().String())
+	}
+}
+
+// nilCompare checks whether either value is nil. If not, the boolean is false.
+// If either value is nil, the boolean is true and the integer is the comparison
+// value. The comparison is defined to be 0 if both are nil, otherwise the one
+// nil value compares low.
+func nilCompare(a, b reflect.Value) (int, bool) {
+	if a.IsNil() {
+		if b.IsNil() {
+			return 0, true
+		}
+		return -1, true
+	}
+	if b.IsNil() {
+		return 1, true
+	}
+	return 0, false
+}
+
+// floatCompare compares two floating-point values. NaNs compare low.
+func floatCompare(a, b float64) int {
+	switch {
+	case isNaN(a):
+		return -1 // No good answer if b is a NaN so don't bother checking.
+	case isNaN(b):
+		return 1
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func pointerCompare(a, b uintptr) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func isNaN(a float64) bool {
+	return a != a
+}
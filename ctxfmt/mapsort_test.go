@@ -0,0 +1,55 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+package ctxfmt
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+)
+
+func TestSortedMaps(t *testing.T) {
+	var ints [3]int
+	pointers := regexp.MustCompile("0x[0-9a-f]+")
+
+	type key struct {
+		a string
+		b int
+	}
+
+	cases := []struct {
+		fmt string
+		val interface{}
+		out string
+	}{
+		{"%v", map[int]string{3: "c", 1: "a", 2: "b", -1: "z"}, "map[-1:z 1:a 2:b 3:c]"},
+		{"%v", map[uint8]int{3: 3, 1: 1, 2: 2}, "map[1:1 2:2 3:3]"},
+		{"%v", map[string]int{"c": 3, "a": 1, "b": 2}, "map[a:1 b:2 c:3]"},
+		{"%v", map[bool]int{true: 1, false: 0}, "map[false:0 true:1]"},
+		{"%v", map[float64]int{2.5: 2, NaN: 0, -1: 1}, "map[NaN:0 -1:1 2.5:2]"},
+		{"%v", map[complex128]int{2 + 1i: 2, 1 + 3i: 1, 1 + 1i: 0}, "map[(1+1i):0 (1+3i):1 (2+1i):2]"},
+		{"%v", map[[2]int]int{{2, 1}: 2, {1, 2}: 1, {1, 1}: 0}, "map[[1 1]:0 [1 2]:1 [2 1]:2]"},
+		{"%v", map[key]int{{"b", 1}: 2, {"a", 2}: 1, {"a", 1}: 0}, "map[{a 1}:0 {a 2}:1 {b 1}:2]"},
+		{"%v", map[interface{}]int{"b": 2, nil: 0, "a": 1}, "map[<nil>:0 a:1 b:2]"},
+		{"%#v", map[string]int{"c": 3, "a": 1, "b": 2}, `map[string]int{"a":1, "b":2, "c":3}`},
+		{"%+v", map[int]string{2: "b", 1: "a"}, "map[1:a 2:b]"},
+		{"%v", map[*int]int{&ints[2]: 2, &ints[0]: 0, &ints[1]: 1}, "map[PTR:0 PTR:1 PTR:2]"},
+		{"%v", map[chan int]int{nil: 0}, "map[<nil>:0]"},
+	}
+
+	for i, test := range cases {
+		t.Run(fmt.Sprintf("%d: %v", i, test.out), func(t *testing.T) {
+			for n := 0; n < 10; n++ {
+				out, _ := Sprintf(nil, test.fmt, test.val)
+				out = pointers.ReplaceAllString(out, "PTR")
+				if out != test.out {
+					t.Fatalf("Sprintf(%q, %v) = <%s> want <%s>", test.fmt, test.val, out, test.out)
+				}
+			}
+		})
+	}
+}
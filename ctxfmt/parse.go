@@ -10,6 +10,7 @@ import "unicode/utf8"
 
 type parser struct {
 	handler tokenHandler
+	verbs   *[256]bool // set of accepted verbs. validVerbs is used if verbs is nil
 }
 
 type tokenHandler interface {
@@ -20,6 +21,7 @@ type tokenHandler interface {
 
 type formatToken struct {
 	field     string
+	def       string // default value of an optional field
 	verb      rune
 	width     int
 	precision int
@@ -28,6 +30,10 @@ type formatToken struct {
 
 type flags struct {
 	named        bool
+	backref      bool // field references a value bound by an earlier field-spec
+	expand       bool // report struct fields and map entries as separate fields
+	optional     bool // field is optional. Print nothing if arg is missing or nil
+	hasDefault   bool // field is optional. Print default if arg is missing or nil
 	hasWidth     bool
 	hasPrecision bool
 	plus         bool
@@ -37,17 +43,23 @@ type flags struct {
 	sharpV       bool
 	space        bool
 	zero         bool
+	grouping     bool // '\'' flag: print thousands separators
 }
 
 var validVerbs [256]bool
 
 func init() {
-	for _, v := range "vtTbcdoOqxXUeEfFgGsqxXp" {
+	for _, v := range "vtTbcdoOqxXUeEfFgGsqxXpBD" {
 		validVerbs[v] = true
 	}
 }
 
 func (p *parser) parse(msg string) {
+	verbs := p.verbs
+	if verbs == nil {
+		verbs = &validVerbs
+	}
+
 	var i int
 	end := len(msg)
 	for i < end {
@@ -84,7 +96,7 @@ func (p *parser) parse(msg string) {
 		i, tok, err = parseFmt(msg, i, end)
 		if err != nil {
 			p.handler.onParseError(tok, err)
-		} else if tok.verb > utf8.RuneSelf || !validVerbs[tok.verb] {
+		} else if tok.verb > utf8.RuneSelf || !verbs[tok.verb] {
 			p.handler.onParseError(tok, errInvalidVerb)
 		} else {
 			if tok.verb == 'v' {
@@ -182,12 +194,22 @@ func parseFmtSpec(tok *formatToken, msg string, start, end int) (int, error) {
 }
 
 // parseField parses a named field format specifier into st.
-// The syntax of a field formatter is '%{[+#@]<name>[:<format>]}'.
+// The syntax of a field formatter is '%{[=][*][+#@]<name>[?][:<format>][|<default>]}'.
 //
 // The prefix '+', '#', '@' modify the printing if no format is configured.
 // In this case the 'v' verb is assumed. The '@' flag is synonymous to '#'.
 //
 // The 'format' section can be any valid format specification
+//
+// The optional suffix '?' marks a field as optional. Optional fields are not
+// printed if the argument is missing or nil. The '|' separator configures a
+// default string to be printed if the argument is missing or nil.
+//
+// The prefix '=' marks the field-spec as back-reference to a field used
+// earlier in the format string. A back-reference does not consume an argument.
+//
+// The prefix '*' reports the fields of a struct or the entries of a map as
+// separate fields to the callback.
 func parseField(msg string, start, end int) (i int, tok formatToken, err error) {
 	tok.flags.named = true
 	tok.verb = 'v' // default verb for fields is 'v'
@@ -197,6 +219,22 @@ func parseField(msg string, start, end int) (i int, tok formatToken, err error)
 		return end, tok, errCloseMissing
 	}
 
+	if msg[i] == '=' {
+		tok.flags.backref = true
+		i++
+		if i >= end {
+			return end, tok, errCloseMissing
+		}
+	}
+
+	if msg[i] == '*' {
+		tok.flags.expand = true
+		i++
+		if i >= end {
+			return end, tok, errCloseMissing
+		}
+	}
+
 	switch msg[i] {
 	case '+':
 		tok.flags.plus = true
@@ -207,7 +245,7 @@ func parseField(msg string, start, end int) (i int, tok formatToken, err error)
 	}
 
 	pos := i
-	for i < end && msg[i] != '}' && msg[i] != ':' {
+	for i < end && !isFieldNameEnd(msg[i]) {
 		i++
 	}
 
@@ -216,30 +254,53 @@ func parseField(msg string, start, end int) (i int, tok formatToken, err error)
 	}
 	tok.field = msg[pos:i]
 
+	if i < end && msg[i] == '?' {
+		tok.flags.optional = true
+		i++
+	}
+
 	if i >= end {
 		return i, tok, errCloseMissing
 	}
 
-	if msg[i] == '}' {
-		return i + 1, tok, nil
-	}
+	if msg[i] == ':' {
+		// msg[i] == ':' => parse format specification
+		i, err = parseFmtSpec(&tok, msg, i+1, end)
+		if err != nil {
+			return i, tok, nil
+		}
 
-	// msg[i] == ':' => parse format specification
-	i, err = parseFmtSpec(&tok, msg, i+1, end)
-	if err != nil {
-		return i, tok, nil
+		// skip to end of formatter or begin of default value:
+		for i < end && msg[i] != '}' && msg[i] != '|' {
+			i++
+		}
 	}
 
-	// skip to end of formatter:
-	for i < end && msg[i] != '}' {
+	if i < end && msg[i] == '|' {
 		i++
+		pos := i
+		for i < end && msg[i] != '}' {
+			i++
+		}
+		tok.def = msg[pos:i]
+		tok.flags.hasDefault = true
 	}
-	if i >= end {
+
+	if i >= end || msg[i] != '}' {
 		return end, tok, errCloseMissing
 	}
 	return i + 1, tok, nil
 }
 
+func isFieldNameEnd(c byte) bool {
+	switch c {
+	case '}', ':', '?', '|':
+		return true
+	default:
+		return false
+	}
+}
+
 func parseFlag(flags *flags, msg string, pos int) (int, bool) {
 	switch msg[pos] {
 	case '#':
@@ -258,6 +319,9 @@ func parseFlag(flags *flags, msg string, pos int) (int, bool) {
 	case ' ':
 		flags.space = true
 		return pos + 1, true
+	case '\'':
+		flags.grouping = true
+		return pos + 1, true
 	}
 
 	return 0, false
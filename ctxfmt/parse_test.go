@@ -134,6 +134,52 @@ func TestParser(t *testing.T) {
 		"%{field:a}": {
 			errInvalidVerb,
 		},
+		"%{field?}": {
+			formatToken{verb: 'v', field: "field", flags: flags{named: true, optional: true}},
+		},
+		"%{field?:5d}": {
+			formatToken{verb: 'd', field: "field", width: 5, flags: flags{hasWidth: true, named: true, optional: true}},
+		},
+		"%{field|default value}": {
+			formatToken{verb: 'v', field: "field", def: "default value", flags: flags{named: true, hasDefault: true}},
+		},
+		"%{field:q|}": {
+			formatToken{verb: 'q', field: "field", flags: flags{named: true, hasDefault: true}},
+		},
+		"%{+field|-}": {
+			formatToken{verb: 'v', field: "field", def: "-", flags: flags{plusV: true, named: true, hasDefault: true}},
+		},
+		"%{field?": {
+			errCloseMissing,
+		},
+		"%{=field}": {
+			formatToken{verb: 'v', field: "field", flags: flags{named: true, backref: true}},
+		},
+		"%{=#field}": {
+			formatToken{verb: 'v', field: "field", flags: flags{sharpV: true, named: true, backref: true}},
+		},
+		"%{=field:x}": {
+			formatToken{verb: 'x', field: "field", flags: flags{named: true, backref: true}},
+		},
+		"%{=": {
+			errCloseMissing,
+		},
+		"%{=}": {
+			errNoFieldName,
+			"}",
+		},
+		"%{field|oops": {
+			errCloseMissing,
+		},
+		"%{*field}": {
+			formatToken{verb: 'v', field: "field", flags: flags{named: true, expand: true}},
+		},
+		"%{*+field}": {
+			formatToken{verb: 'v', field: "field", flags: flags{plusV: true, named: true, expand: true}},
+		},
+		"%{*": {
+			errCloseMissing,
+		},
 	}
 
 	for str, want := range cases {
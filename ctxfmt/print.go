@@ -11,10 +11,56 @@ import (
 	"unicode/utf8"
 )
 
+// printer writes the formatted output to To. If To is nil, all output is
+// appended to buf.
+// If escape is set, the escaping policy is applied to all bytes written,
+// except padding.
+// If limited is set, at most limit more bytes will be written. All other
+// bytes are dropped.
 type printer struct {
 	To      io.Writer
+	buf     []byte
 	written int
 	err     error
+
+	escape Escaping
+	ansi   ansiState
+
+	limited   bool
+	truncated bool
+	limit     int
+
+	tmp [128]byte // scratch buffer used for writing runes and padding to To
+	esc [8]byte   // scratch buffer for escape sequences
+}
+
+func (p *printer) reset() {
+	p.To = nil
+	p.buf = nil
+	p.written = 0
+	p.err = nil
+	p.setEscaping(EscapeNone)
+	p.setLimit(-1)
+}
+
+// setEscaping configures the escaping policy for the following writes.
+func (p *printer) setEscaping(e Escaping) {
+	p.escape = e
+	p.ansi = ansiNone
+}
+
+// setLimit configures the maximum number of bytes to be written. No limit
+// is applied if n < 0.
+func (p *printer) setLimit(n int) {
+	p.limited = n >= 0
+	p.truncated = false
+	p.limit = n
+}
+
+// direct reports if writes can be passed to the output without being
+// filtered.
+func (p *printer) direct() bool {
+	return p.escape == EscapeNone && !p.limited
 }
 
 func (p *printer) Write(buf []byte) (int, error) {
@@ -22,10 +68,50 @@ func (p *printer) Write(buf []byte) (int, error) {
 		return 0, p.err
 	}
 
+	if p.escape != EscapeNone {
+		return p.writeEscaped(buf)
+	}
 	return p.doWrite(buf)
 }
 
+// doWrite writes buf to the output. If a limit is configured, then buf will
+// be truncated at the last UTF-8 rune boundary fitting into the limit.
 func (p *printer) doWrite(buf []byte) (int, error) {
+	if p.limited && len(buf) > p.limit {
+		n := p.limit
+		for n > 0 && !utf8.RuneStart(buf[n]) {
+			n--
+		}
+		_, err := p.rawWrite(buf[:n])
+		p.limit, p.truncated = 0, true
+		if err != nil {
+			return 0, err
+		}
+		return len(buf), nil
+	}
+
+	return p.rawWrite(buf)
+}
+
+// doWriteAll writes buf to the output. If a limit is configured and buf does
+// not fit into the remaining limit, then buf is dropped.
+func (p *printer) doWriteAll(buf []byte) (int, error) {
+	if p.limited && len(buf) > p.limit {
+		p.limit, p.truncated = 0, true
+		return len(buf), nil
+	}
+	return p.rawWrite(buf)
+}
+
+func (p *printer) rawWrite(buf []byte) (int, error) {
+	if p.limited {
+		p.limit -= len(buf)
+	}
+
+	if p.To == nil {
+		p.buf = append(p.buf, buf...)
+		return p.upd(len(buf), nil)
+	}
 	return p.upd(p.To.Write(buf))
 }
 
@@ -40,6 +126,18 @@ func (p *printer) WriteByte(b byte) error {
 		return p.err
 	}
 
+	if !p.direct() {
+		p.tmp[0] = b
+		_, err := p.Write(p.tmp[:1])
+		return err
+	}
+
+	if p.To == nil {
+		p.buf = append(p.buf, b)
+		p.written++
+		return nil
+	}
+
 	if bw, ok := p.To.(io.ByteWriter); ok {
 		err := bw.WriteByte(b)
 		if err != nil {
@@ -50,7 +148,8 @@ func (p *printer) WriteByte(b byte) error {
 		return err
 	}
 
-	_, err := p.doWrite([]byte{b})
+	p.tmp[0] = b
+	_, err := p.rawWrite(p.tmp[:1])
 	return err
 }
 
@@ -59,10 +158,19 @@ func (p *printer) WriteString(s string) (int, error) {
 		return 0, p.err
 	}
 
+	if !p.direct() {
+		return p.Write(unsafeBytes(s))
+	}
+
+	if p.To == nil {
+		p.buf = append(p.buf, s...)
+		return p.upd(len(s), nil)
+	}
+
 	if sw, ok := p.To.(interface{ WriteString(string) (int, error) }); ok {
 		return p.upd(sw.WriteString(s))
 	}
-	return p.doWrite(unsafeBytes(s))
+	return p.rawWrite(unsafeBytes(s))
 }
 
 func (p *printer) WriteRune(r rune) error {
@@ -70,21 +178,52 @@ func (p *printer) WriteRune(r rune) error {
 		return p.err
 	}
 
-	if rw, ok := p.To.(interface{ WriteRune(rune) error }); ok {
-		p.err = rw.WriteRune(r)
-		return p.err
-	}
-
 	if r < utf8.RuneSelf {
 		return p.WriteByte(byte(r))
 	}
 
-	var runeBuf [utf8.UTFMax]byte
-	n := utf8.EncodeRune(runeBuf[:], r)
-	_, err := p.doWrite(runeBuf[:n])
+	if p.To != nil && p.direct() {
+		if rw, ok := p.To.(interface{ WriteRune(rune) (int, error) }); ok {
+			_, err := p.upd(rw.WriteRune(r))
+			return err
+		}
+	}
+
+	n := utf8.EncodeRune(p.tmp[:], r)
+	_, err := p.Write(p.tmp[:n])
 	return err
 }
 
+// pad writes n copies of padByte.
+func (p *printer) pad(n int, padByte byte) {
+	if p.err != nil || n <= 0 {
+		return
+	}
+
+	if p.To == nil && !p.limited {
+		for i := 0; i < n; i++ {
+			p.buf = append(p.buf, padByte)
+		}
+		p.written += n
+		return
+	}
+
+	for n > 0 {
+		buf := p.tmp[:]
+		if n < len(buf) {
+			buf = buf[:n]
+		}
+		for i := range buf {
+			buf[i] = padByte
+		}
+
+		if _, err := p.doWrite(buf); err != nil {
+			return
+		}
+		n -= len(buf)
+	}
+}
+
 func (p *printer) onString(s string) {
 	p.WriteString(s)
 }
@@ -0,0 +1,187 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+package ctxfmt
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+)
+
+// Printer holds formatting configuration to be shared between print calls.
+// Custom verbs and formatters for types can be registered with a printer.
+// Registered formatters are consulted before the built-in formatting logic.
+//
+// The zero value is ready to use and formats like the package level
+// functions. A Printer must not be modified while in use.
+type Printer struct {
+	// Escape configures the escaping policy applied to all interpolated
+	// arguments.
+	Escape Escaping
+
+	// Redact configures the redaction of sensitive named fields.
+	// No field is redacted if Redact is nil.
+	Redact *Redaction
+
+	// Limits bounds the output generated for a single argument.
+	Limits Limits
+
+	// Decorate adds markup around the values of named fields.
+	Decorate Decorator
+
+	verbs map[rune]FormatFunc
+	types map[reflect.Type]FormatFunc
+
+	// validVerbs is the set of verbs accepted by the parser. It is nil if no
+	// custom verb has been registered.
+	validVerbs *[256]bool
+}
+
+// FormatFunc formats arg by writing to f. The state f reports the flags,
+// width, and precision configured in the format string.
+// If FormatFunc returns false, arg is formatted by the next formatter that
+// supports the value, or by the built-in formatting logic. Formatters
+// registered for a type are tried before formatters registered for a verb.
+// A FormatFunc returning false must not write to f.
+type FormatFunc func(f fmt.State, verb rune, arg interface{}) bool
+
+var defaultPrinter Printer
+
+// RegisterVerb adds a custom verb to the printer. The verb must be an ASCII
+// letter. Registering a built-in verb overwrites the built-in formatting of
+// the verb for all types.
+func (p *Printer) RegisterVerb(verb rune, fn FormatFunc) {
+	if !('a' <= verb && verb <= 'z') && !('A' <= verb && verb <= 'Z') {
+		panic("ctxfmt: custom verb must be an ASCII letter")
+	}
+
+	if p.verbs == nil {
+		p.verbs = map[rune]FormatFunc{}
+	}
+	p.verbs[verb] = fn
+
+	if p.validVerbs == nil {
+		tmp := validVerbs
+		p.validVerbs = &tmp
+	}
+	p.validVerbs[verb] = true
+}
+
+// RegisterType adds a custom formatter for values of type typ. The formatter
+// is used for all verbs.
+func (p *Printer) RegisterType(typ reflect.Type, fn FormatFunc) {
+	if p.types == nil {
+		p.types = map[reflect.Type]FormatFunc{}
+	}
+	p.types[typ] = fn
+}
+
+// Printf formats according to the format specifier and writes to stdout.
+// It returns the unprocessed arguments.
+func (p *Printer) Printf(cb CB, msg string, vs ...interface{}) (rest []interface{}, n int, err error) {
+	return p.Fprintf(os.Stdout, cb, msg, vs...)
+}
+
+// Sprintf formats according to the format specifier and returns the resulting
+// string and the list of unprocessed arguments.
+func (p *Printer) Sprintf(cb CB, msg string, vs ...interface{}) (string, []interface{}) {
+	return p.sprintf(cb, nil, msg, argstate{args: vs})
+}
+
+// Fprintf formats according to the format specifier and writes to w.
+// It returns the unprocessed arguments.
+func (p *Printer) Fprintf(w io.Writer, cb CB, msg string, vs ...interface{}) (rest []interface{}, n int, err error) {
+	return p.fprintf(w, cb, nil, msg, argstate{args: vs})
+}
+
+// Appendf formats according to the format specifier, appends the result to
+// dst, and returns the updated buffer and the list of unprocessed arguments.
+func (p *Printer) Appendf(dst []byte, cb CB, msg string, vs ...interface{}) ([]byte, []interface{}) {
+	return p.appendf(dst, cb, nil, msg, argstate{args: vs})
+}
+
+// PrintfFields formats according to the format specifier and writes to stdout.
+// The callback receives the field its formatting directives and position
+// within the output.
+// It returns the unprocessed arguments.
+func (p *Printer) PrintfFields(cb FieldCB, msg string, vs ...interface{}) (rest []interface{}, n int, err error) {
+	return p.FprintfFields(os.Stdout, cb, msg, vs...)
+}
+
+// SprintfFields formats according to the format specifier and returns the
+// resulting string and the list of unprocessed arguments.
+// The callback receives the field its formatting directives and position
+// within the output.
+func (p *Printer) SprintfFields(cb FieldCB, msg string, vs ...interface{}) (string, []interface{}) {
+	return p.sprintf(nil, cb, msg, argstate{args: vs})
+}
+
+// FprintfFields formats according to the format specifier and writes to w.
+// The callback receives the field its formatting directives and position
+// within the output.
+// It returns the unprocessed arguments.
+func (p *Printer) FprintfFields(w io.Writer, cb FieldCB, msg string, vs ...interface{}) (rest []interface{}, n int, err error) {
+	return p.fprintf(w, nil, cb, msg, argstate{args: vs})
+}
+
+// AppendfFields formats according to the format specifier, appends the result
+// to dst, and returns the updated buffer and the list of unprocessed arguments.
+// The callback receives the field its formatting directives and position
+// within the output. Positions are relative to the end of dst.
+func (p *Printer) AppendfFields(dst []byte, cb FieldCB, msg string, vs ...interface{}) ([]byte, []interface{}) {
+	return p.appendf(dst, nil, cb, msg, argstate{args: vs})
+}
+
+func (p *Printer) fprintf(w io.Writer, cb CB, fieldCB FieldCB, msg string, args argstate) (rest []interface{}, n int, err error) {
+	in := newInterpreter(p, cb, fieldCB, args)
+	in.p.To = w
+	rest = in.run(msg)
+	n, err = in.p.written, in.p.err
+	in.free()
+	return rest, n, err
+}
+
+func (p *Printer) sprintf(cb CB, fieldCB FieldCB, msg string, args argstate) (string, []interface{}) {
+	in := newInterpreter(p, cb, fieldCB, args)
+	in.p.buf = in.scratch[:0]
+	rest := in.run(msg)
+	s := string(in.p.buf)
+	in.scratch = in.p.buf
+	in.free()
+	return s, rest
+}
+
+func (p *Printer) appendf(dst []byte, cb CB, fieldCB FieldCB, msg string, args argstate) ([]byte, []interface{}) {
+	in := newInterpreter(p, cb, fieldCB, args)
+	in.p.buf = dst
+	rest := in.run(msg)
+	dst = in.p.buf
+	in.free()
+	return dst, rest
+}
+
+// redacts checks if the named field must be redacted.
+func (p *Printer) redacts(key string) bool {
+	return p.Redact != nil && p.Redact.matches(key)
+}
+
+// lookupType returns the custom formatter registered for the type of arg.
+func (p *Printer) lookupType(arg interface{}) FormatFunc {
+	if len(p.types) == 0 || arg == nil {
+		return nil
+	}
+	return p.types[reflect.TypeOf(arg)]
+}
+
+// lookupVerb returns the custom formatter registered for verb.
+func (p *Printer) lookupVerb(verb rune) FormatFunc {
+	if len(p.verbs) == 0 {
+		return nil
+	}
+	return p.verbs[verb]
+}
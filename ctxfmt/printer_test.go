@@ -0,0 +1,103 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+package ctxfmt
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+type testID [4]byte
+
+func TestPrinterCustomFormatters(t *testing.T) {
+	var p Printer
+	p.RegisterVerb('K', func(f fmt.State, verb rune, arg interface{}) bool {
+		n, ok := arg.(int)
+		if !ok {
+			return false
+		}
+		if f.Flag('+') {
+			fmt.Fprint(f, "+")
+		}
+		fmt.Fprintf(f, "%dB", n)
+		return true
+	})
+	p.RegisterType(reflect.TypeOf(testID{}), func(f fmt.State, verb rune, arg interface{}) bool {
+		id := arg.(testID)
+		fmt.Fprintf(f, "%x-%x", id[:2], id[2:])
+		return true
+	})
+	p.RegisterType(reflect.TypeOf(""), func(f fmt.State, verb rune, arg interface{}) bool {
+		return false
+	})
+	p.RegisterVerb('P', func(f fmt.State, verb rune, arg interface{}) bool {
+		panic("oops")
+	})
+
+	cases := []struct {
+		fmt  string
+		args []interface{}
+		out  string
+	}{
+		{"%K", []interface{}{10}, "10B"},
+		{"%+K", []interface{}{10}, "+10B"},
+		{"%{size:K}", []interface{}{10}, "10B"},
+		{"%v", []interface{}{[]int{1, 2}}, "[1 2]"},
+		{"%K", []interface{}{[]int{1, 2}}, "[1B 2B]"},
+		{"%K", []interface{}{"str"}, "%!K(string=str)"},
+		{"%v", []interface{}{testID{1, 2, 3, 4}}, "0102-0304"},
+		{"%{id}", []interface{}{testID{1, 2, 3, 4}}, "0102-0304"},
+		{"%v", []interface{}{[]testID{{1, 2, 3, 4}}}, "[0102-0304]"},
+		{"%s", []interface{}{"fallback"}, "fallback"},
+		{"%P", []interface{}{1}, "%!P(PANIC=oops)"},
+		{"%Y", []interface{}{1}, "%!Y(INVALID)(int=1)"},
+	}
+
+	for i, test := range cases {
+		t.Run(fmt.Sprintf("%d: %v -> %v", i, test.fmt, test.out), func(t *testing.T) {
+			out, _ := p.Sprintf(func(_ string, _ int, _ interface{}) {}, test.fmt, test.args...)
+			if out != test.out {
+				t.Errorf("Sprintf(%q, %v) = <%s> want <%s>", test.fmt, test.args, out, test.out)
+			}
+		})
+	}
+
+	t.Run("package level functions ignore registry", func(t *testing.T) {
+		out, _ := Sprintf(nil, "%K", 10)
+		if want := "%!K(INVALID)(int=10)"; out != want {
+			t.Errorf("got <%s> want <%s>", out, want)
+		}
+	})
+}
+
+func TestPrinterCustomFormatterFallback(t *testing.T) {
+	var p Printer
+	var typeCalls int
+	p.RegisterType(reflect.TypeOf(testID{}), func(f fmt.State, verb rune, arg interface{}) bool {
+		typeCalls++
+		return false
+	})
+	p.RegisterVerb('K', func(f fmt.State, verb rune, arg interface{}) bool {
+		fmt.Fprint(f, "verb")
+		return true
+	})
+
+	out, _ := p.Sprintf(nil, "%K", testID{})
+	if out != "verb" {
+		t.Errorf("got <%s> want <verb>", out)
+	}
+
+	typeCalls = 0
+	out, _ = p.Sprintf(nil, "%v", testID{1, 2, 3, 4})
+	if want := "[1 2 3 4]"; out != want {
+		t.Errorf("got <%s> want <%s>", out, want)
+	}
+	if typeCalls != 1 {
+		t.Errorf("type formatter called %v times, want 1", typeCalls)
+	}
+}
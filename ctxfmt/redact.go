@@ -0,0 +1,84 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+package ctxfmt
+
+import (
+	"path"
+	"unicode/utf8"
+)
+
+// Redaction configures the redaction of sensitive named fields. The values of
+// matching field-specs are replaced in the output. Back-references to redacted
+// fields are redacted as well.
+type Redaction struct {
+	// Names lists the field names to be redacted.
+	Names []string
+
+	// Patterns lists glob patterns (see path.Match) of field names to be
+	// redacted. For example "*.secret" matches "db.secret".
+	Patterns []string
+
+	// Match is an optional custom function to select the fields to be redacted.
+	Match func(key string) bool
+
+	// Mask returns the string to be printed instead of the original value.
+	// "[REDACTED]" is printed if Mask is nil.
+	Mask func(key string, val interface{}) string
+
+	// MaskCallback configures the value passed to the callback.
+	// If set, the masked string is passed to the callback instead of the
+	// original value.
+	MaskCallback bool
+}
+
+const redacted = "[REDACTED]"
+
+// matches checks if the field key must be redacted.
+func (r *Redaction) matches(key string) bool {
+	for _, name := range r.Names {
+		if name == key {
+			return true
+		}
+	}
+	for _, pattern := range r.Patterns {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return r.Match != nil && r.Match(key)
+}
+
+func (r *Redaction) mask(key string, val interface{}) string {
+	if r.Mask == nil {
+		return redacted
+	}
+	return r.Mask(key, val)
+}
+
+// MaskLast creates a Mask function for use with Redaction, that masks all but
+// the last n characters of the formatted value. For example MaskLast(4)
+// prints "****1234" for the value "secret1234". Values with n or less
+// characters are fully masked.
+func MaskLast(n int) func(key string, val interface{}) string {
+	return func(_ string, val interface{}) string {
+		s, _ := Sprintf(nil, "%v", val)
+
+		count := utf8.RuneCountInString(s)
+		if count <= n {
+			return "****"
+		}
+
+		skip := count - n
+		for i := range s {
+			if skip == 0 {
+				return "****" + s[i:]
+			}
+			skip--
+		}
+		return "****"
+	}
+}
@@ -0,0 +1,171 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+package ctxfmt
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRedaction(t *testing.T) {
+	type cbRecord struct {
+		Key string
+		Val interface{}
+	}
+	type records []cbRecord
+
+	cases := map[string]struct {
+		redact Redaction
+		fmt    string
+		args   []interface{}
+		out    string
+		want   records
+	}{
+		"no match": {
+			redact: Redaction{Names: []string{"password"}},
+			fmt:    "user=%{user}",
+			args:   []interface{}{"test"},
+			out:    "user=test",
+			want:   records{{"user", "test"}},
+		},
+		"exact name": {
+			redact: Redaction{Names: []string{"password"}},
+			fmt:    "user=%{user} password=%{password}",
+			args:   []interface{}{"test", "secret"},
+			out:    "user=test password=[REDACTED]",
+			want:   records{{"user", "test"}, {"password", "secret"}},
+		},
+		"glob pattern": {
+			redact: Redaction{Patterns: []string{"*.secret"}},
+			fmt:    "%{db.secret} %{db.user}",
+			args:   []interface{}{"pw", "admin"},
+			out:    "[REDACTED] admin",
+			want:   records{{"db.secret", "pw"}, {"db.user", "admin"}},
+		},
+		"custom matcher": {
+			redact: Redaction{Match: func(key string) bool { return strings.HasSuffix(key, "token") }},
+			fmt:    "%{api_token}",
+			args:   []interface{}{"abc"},
+			out:    "[REDACTED]",
+			want:   records{{"api_token", "abc"}},
+		},
+		"mask callback": {
+			redact: Redaction{Names: []string{"token"}, MaskCallback: true},
+			fmt:    "%{token}",
+			args:   []interface{}{"abc"},
+			out:    "[REDACTED]",
+			want:   records{{"token", "[REDACTED]"}},
+		},
+		"partial mask": {
+			redact: Redaction{Names: []string{"card"}, Mask: MaskLast(4)},
+			fmt:    "card: %{card}",
+			args:   []interface{}{"4111111111111234"},
+			out:    "card: ****1234",
+			want:   records{{"card", "4111111111111234"}},
+		},
+		"partial mask of short value": {
+			redact: Redaction{Names: []string{"card"}, Mask: MaskLast(4)},
+			fmt:    "card: %{card}",
+			args:   []interface{}{1234},
+			out:    "card: ****",
+			want:   records{{"card", 1234}},
+		},
+		"respect width": {
+			redact: Redaction{Names: []string{"password"}},
+			fmt:    "[%{password:15s}] [%{password:-12v}]",
+			args:   []interface{}{"a", "b"},
+			out:    "[     [REDACTED]] [[REDACTED]  ]",
+			want:   records{{"password", "a"}, {"password", "b"}},
+		},
+		"anonymous args are not redacted": {
+			redact: Redaction{Names: []string{"password"}},
+			fmt:    "%v",
+			args:   []interface{}{"secret"},
+			out:    "secret",
+		},
+		"redact back-references": {
+			redact: Redaction{Names: []string{"password"}},
+			fmt:    "%{password} %{=password:q}",
+			args:   []interface{}{"secret"},
+			out:    "[REDACTED] [REDACTED]",
+			want:   records{{"password", "secret"}},
+		},
+	}
+
+	for name, test := range cases {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			var actual records
+			p := Printer{Redact: &test.redact}
+			out, _ := p.Sprintf(func(key string, _ int, val interface{}) {
+				actual = append(actual, cbRecord{key, val})
+			}, test.fmt, test.args...)
+
+			if out != test.out {
+				t.Errorf("output missmatch. Want <%s>, got <%s>", test.out, out)
+			}
+			if diff := cmp.Diff(test.want, actual); diff != "" {
+				t.Errorf("callback missmatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRedactionEscaping(t *testing.T) {
+	mask := Redaction{Names: []string{"tok"}, Mask: MaskLast(3)}
+	cases := map[string]struct {
+		printer Printer
+		fmt     string
+		out     string
+	}{
+		"json escaping": {
+			printer: Printer{Redact: &mask, Escape: EscapeJSON},
+			fmt:     "tok=%{tok} %{=tok}",
+			out:     `tok=****\"\n1 ****\"\n1`,
+		},
+		"byte limit": {
+			printer: Printer{Redact: &mask, Limits: Limits{MaxBytes: 2}},
+			fmt:     "tok=%{tok}",
+			out:     "tok=**...",
+		},
+	}
+
+	for name, test := range cases {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			out, _ := test.printer.Sprintf(nil, test.fmt, "secret\"\n1")
+			if out != test.out {
+				t.Errorf("output missmatch. Want <%s>, got <%s>", test.out, out)
+			}
+		})
+	}
+}
+
+func TestMaskLast(t *testing.T) {
+	cases := []struct {
+		n    int
+		in   interface{}
+		want string
+	}{
+		{4, "secret1234", "****1234"},
+		{4, "1234", "****"},
+		{2, "äöüß", "****üß"},
+		{0, "secret", "****"},
+		{3, 1234567, "****567"},
+	}
+
+	for _, test := range cases {
+		t.Run(fmt.Sprint(test.in), func(t *testing.T) {
+			if got := MaskLast(test.n)("key", test.in); got != test.want {
+				t.Errorf("want %q, got %q", test.want, got)
+			}
+		})
+	}
+}
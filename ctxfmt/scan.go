@@ -0,0 +1,299 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+package ctxfmt
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/urso/diag"
+)
+
+// Template is a compiled format string, that can be used to extract the
+// fields from messages rendered with the same format string.
+// A Template is safe for concurrent use.
+type Template struct {
+	format string
+	parts  []templatePart
+
+	// memo[i] is set if the result of matching the parts starting at i only
+	// depends on the position in the message, that is, if no back-reference
+	// in parts[i:] refers to a part before i.
+	memo []bool
+}
+
+type templatePart struct {
+	text    string // literal text. Only used if isToken is false
+	tok     formatToken
+	isToken bool
+	ref     int // index of the part a back-reference refers to. -1 if unused
+}
+
+// templateBuilder collects the tokens reported by the parser.
+type templateBuilder struct {
+	parts []templatePart
+	err   error
+}
+
+type scanState struct {
+	t      *Template
+	msg    string
+	vals   []diag.Value
+	texts  []string
+	exists []bool
+
+	// failed records the (part, position) pairs known not to match. It bounds
+	// backtracking to a polynomial number of steps.
+	failed map[[2]int]bool
+}
+
+// ErrNoMatch is returned by Scan if the message has not been rendered using
+// the template.
+var ErrNoMatch = errors.New("message does not match template")
+
+var errAmbiguousFields = errors.New("ambiguous adjacent fields")
+
+// Scan extracts the named fields from a message, that has been rendered using
+// the format string.
+// Scan compiles the format string on every call. Use Compile in order to
+// scan many messages using the same format string.
+func Scan(format, rendered string) (*diag.Context, error) {
+	t, err := Compile(format)
+	if err != nil {
+		return nil, err
+	}
+	return t.Scan(rendered)
+}
+
+// Compile parses the format string into a Template.
+// An error is returned if the format string is invalid, or if two verbs or
+// field-specs are not separated by literal text. Without a separator the
+// boundary between the values can not be determined when scanning.
+func Compile(format string) (*Template, error) {
+	b := templateBuilder{}
+	p := parser{handler: &b}
+	p.parse(format)
+	if b.err != nil {
+		return nil, fmt.Errorf("invalid template %q: %v", format, b.err)
+	}
+	return &Template{format: format, parts: b.parts, memo: memoizable(b.parts)}, nil
+}
+
+func memoizable(parts []templatePart) []bool {
+	memo := make([]bool, len(parts)+1)
+	minRef := len(parts)
+	for i := len(parts); i >= 0; i-- {
+		memo[i] = minRef >= i
+		if i > 0 && parts[i-1].ref >= 0 && parts[i-1].ref < minRef {
+			minRef = parts[i-1].ref
+		}
+	}
+	return memo
+}
+
+// String returns the format string the template has been compiled from.
+func (t *Template) String() string {
+	return t.format
+}
+
+// Scan extracts the named fields from a rendered message. The fields are
+// added to the returned context in the order they appear in the template.
+//
+// The type of a value is derived from the verb. Integers are parsed for 'd',
+// floating point numbers for 'e', 'E', 'f', 'F', 'g', 'G', booleans for 't',
+// and quoted strings for 'q'. All other values are returned as string.
+// Values of optional fields are not added to the context if they are empty or
+// equal to the configured default.
+//
+// If multiple matches are possible, the first match, assigning the shortest
+// value to each field, is returned. ErrNoMatch is returned if the message
+// does not match the template.
+func (t *Template) Scan(rendered string) (*diag.Context, error) {
+	st := scanState{
+		t:      t,
+		msg:    rendered,
+		vals:   make([]diag.Value, len(t.parts)),
+		texts:  make([]string, len(t.parts)),
+		exists: make([]bool, len(t.parts)),
+	}
+	if !st.match(0, 0) {
+		return nil, ErrNoMatch
+	}
+
+	ctx := diag.NewContext(nil, nil)
+	for i := range t.parts {
+		part := &t.parts[i]
+		if part.isToken && part.tok.flags.named && part.ref < 0 && st.exists[i] {
+			ctx.Add(part.tok.field, st.vals[i])
+		}
+	}
+	return ctx, nil
+}
+
+func (b *templateBuilder) onString(s string) {
+	if n := len(b.parts); n > 0 && !b.parts[n-1].isToken {
+		b.parts[n-1].text += s
+		return
+	}
+	b.parts = append(b.parts, templatePart{text: s, ref: -1})
+}
+
+func (b *templateBuilder) onToken(tok formatToken) {
+	if n := len(b.parts); n > 0 && b.parts[n-1].isToken && b.err == nil {
+		b.err = errAmbiguousFields
+	}
+
+	ref := -1
+	if tok.flags.backref {
+		for i := len(b.parts) - 1; i >= 0; i-- {
+			if part := &b.parts[i]; part.isToken && part.tok.flags.named && part.tok.field == tok.field {
+				ref = i
+				break
+			}
+		}
+		if ref < 0 && b.err == nil {
+			b.err = errUnboundField
+		}
+	}
+
+	b.parts = append(b.parts, templatePart{tok: tok, isToken: true, ref: ref})
+}
+
+func (b *templateBuilder) onParseError(_ formatToken, err error) {
+	if b.err == nil {
+		b.err = err
+	}
+}
+
+// match reports whether the message, starting at pos, matches the template
+// starting with part i. Values are matched non-greedy, backtracking to the
+// next occurrence of the following literal text if the remaining message does
+// not match.
+func (st *scanState) match(i, pos int) bool {
+	parts := st.t.parts
+	if i == len(parts) {
+		return pos == len(st.msg)
+	}
+
+	if !st.t.memo[i] {
+		return st.matchPart(i, pos)
+	}
+	key := [2]int{i, pos}
+	if st.failed[key] {
+		return false
+	}
+	if st.matchPart(i, pos) {
+		return true
+	}
+	if st.failed == nil {
+		st.failed = map[[2]int]bool{}
+	}
+	st.failed[key] = true
+	return false
+}
+
+func (st *scanState) matchPart(i, pos int) bool {
+	parts := st.t.parts
+
+	part := &parts[i]
+	rest := st.msg[pos:]
+	if !part.isToken {
+		return strings.HasPrefix(rest, part.text) && st.match(i+1, pos+len(part.text))
+	}
+
+	if part.ref >= 0 {
+		text := st.texts[part.ref]
+		return strings.HasPrefix(rest, text) && st.match(i+1, pos+len(text))
+	}
+
+	if i+1 == len(parts) {
+		return st.accept(i, rest)
+	}
+
+	// adjacent tokens are rejected by Compile => next part is literal text
+	lit := parts[i+1].text
+	for off := 0; off <= len(rest); off++ {
+		idx := strings.Index(rest[off:], lit)
+		if idx < 0 {
+			return false
+		}
+
+		off += idx
+		if st.accept(i, rest[:off]) && st.match(i+1, pos+off) {
+			return true
+		}
+	}
+	return false
+}
+
+func (st *scanState) accept(i int, text string) bool {
+	val, exists, ok := scanValue(&st.t.parts[i].tok, text)
+	if !ok {
+		return false
+	}
+	st.vals[i], st.exists[i], st.texts[i] = val, exists, text
+	return true
+}
+
+// scanValue parses the rendered text of a single value based on the verb
+// used to print the value. The exists flag is false if an optional field has
+// not been printed.
+func scanValue(tok *formatToken, text string) (val diag.Value, exists, ok bool) {
+	flags := &tok.flags
+	if (flags.optional && text == "") || (flags.hasDefault && text == tok.def) {
+		return diag.Value{}, false, true
+	}
+
+	if flags.hasWidth {
+		text = strings.TrimSpace(text)
+	}
+
+	switch tok.verb {
+	case 'd':
+		if flags.grouping {
+			text = strings.Replace(text, ",", "", -1)
+		}
+		if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return diag.ValInt64(i), true, true
+		}
+		if u, err := strconv.ParseUint(text, 10, 64); err == nil {
+			return diag.ValUint64(u), true, true
+		}
+		return diag.Value{}, false, false
+
+	case 'e', 'E', 'f', 'F', 'g', 'G':
+		if flags.grouping {
+			text = strings.Replace(text, ",", "", -1)
+		}
+		f, err := strconv.ParseFloat(strings.TrimPrefix(text, "+"), 64)
+		if err != nil {
+			return diag.Value{}, false, false
+		}
+		return diag.ValFloat(f), true, true
+
+	case 't':
+		switch text {
+		case "true":
+			return diag.ValBool(true), true, true
+		case "false":
+			return diag.ValBool(false), true, true
+		}
+		return diag.Value{}, false, false
+
+	case 'q':
+		s, err := strconv.Unquote(text)
+		if err != nil {
+			return diag.Value{}, false, false
+		}
+		return diag.ValString(s), true, true
+
+	default:
+		return diag.ValString(text), true, true
+	}
+}
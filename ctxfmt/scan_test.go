@@ -0,0 +1,212 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+package ctxfmt
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/urso/diag"
+)
+
+type mapVisitor map[string]interface{}
+
+func (v mapVisitor) OnObjStart(_ string) error { return nil }
+func (v mapVisitor) OnObjEnd() error           { return nil }
+func (v mapVisitor) OnValue(key string, val diag.Value) error {
+	v[key] = val.Interface()
+	return nil
+}
+
+func ctxToMap(ctx *diag.Context) map[string]interface{} {
+	m := mapVisitor{}
+	ctx.VisitKeyValues(m)
+	return m
+}
+
+func TestScan(t *testing.T) {
+	cases := map[string]struct {
+		format   string
+		rendered string
+		want     map[string]interface{}
+	}{
+		"no fields": {
+			format:   "hello world",
+			rendered: "hello world",
+			want:     map[string]interface{}{},
+		},
+		"string field": {
+			format:   "hello %{who}!",
+			rendered: "hello big world!",
+			want:     map[string]interface{}{"who": "big world"},
+		},
+		"field at end": {
+			format:   "user=%{user}",
+			rendered: "user=a=b",
+			want:     map[string]interface{}{"user": "a=b"},
+		},
+		"typed fields": {
+			format:   "%{n:d} %{f:.2f} %{ok:t} %{s:q}",
+			rendered: "-42 3.14 true \"a b\"",
+			want:     map[string]interface{}{"n": int64(-42), "f": 3.14, "ok": true, "s": "a b"},
+		},
+		"large unsigned": {
+			format:   "%{n:d}",
+			rendered: "18446744073709551615",
+			want:     map[string]interface{}{"n": uint64(18446744073709551615)},
+		},
+		"anonymous verbs are skipped": {
+			format:   "%v: %{code:d} %s",
+			rendered: "GET: 404 not found",
+			want:     map[string]interface{}{"code": int64(404)},
+		},
+		"backtracking on type": {
+			format:   "%{a} %{n:d}",
+			rendered: "x y 12",
+			want:     map[string]interface{}{"a": "x y", "n": int64(12)},
+		},
+		"backtracking on literal": {
+			format:   "%{a}, %{b}!",
+			rendered: "x, y, z!",
+			want:     map[string]interface{}{"a": "x", "b": "y, z"},
+		},
+		"padded values": {
+			format:   "[%{n:5d}] [%{s:-4s}]",
+			rendered: "[   12] [ab  ]",
+			want:     map[string]interface{}{"n": int64(12), "s": "ab"},
+		},
+		"grouping": {
+			format:   "%{n:'d} bytes",
+			rendered: "1,234,567 bytes",
+			want:     map[string]interface{}{"n": int64(1234567)},
+		},
+		"escaped percent": {
+			format:   "%{p:d}%% done",
+			rendered: "50% done",
+			want:     map[string]interface{}{"p": int64(50)},
+		},
+		"back-reference": {
+			format:   "%{id} failed, retrying %{=id}.",
+			rendered: "a.b failed, retrying a.b.",
+			want:     map[string]interface{}{"id": "a.b"},
+		},
+		"optional missing": {
+			format:   "user %{user?}.",
+			rendered: "user .",
+			want:     map[string]interface{}{},
+		},
+		"default": {
+			format:   "user %{user|anonymous}.",
+			rendered: "user anonymous.",
+			want:     map[string]interface{}{},
+		},
+		"default overwritten": {
+			format:   "user %{user|anonymous}.",
+			rendered: "user alice.",
+			want:     map[string]interface{}{"user": "alice"},
+		},
+	}
+
+	for name, test := range cases {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			ctx, err := Scan(test.format, test.rendered)
+			if err != nil {
+				t.Fatalf("Scan(%q, %q) failed: %v", test.format, test.rendered, err)
+			}
+			if diff := cmp.Diff(test.want, ctxToMap(ctx)); diff != "" {
+				t.Errorf("Scan(%q, %q) mismatch (-want +got):\n%s", test.format, test.rendered, diff)
+			}
+		})
+	}
+}
+
+func TestScanNoMatch(t *testing.T) {
+	cases := map[string]struct {
+		format   string
+		rendered string
+	}{
+		"literal mismatch":    {"hello %{who}", "bye world"},
+		"trailing text":       {"a=%{a:d}", "a=1 b"},
+		"not an integer":      {"%{n:d} items", "many items"},
+		"not a bool":          {"%{ok:t}", "yes"},
+		"back-ref mismatch":   {"%{a} %{=a}", "x y"},
+		"missing literal end": {"%{a}!", "x"},
+	}
+
+	for name, test := range cases {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			_, err := Scan(test.format, test.rendered)
+			if err != ErrNoMatch {
+				t.Errorf("Scan(%q, %q) = %v, want ErrNoMatch", test.format, test.rendered, err)
+			}
+		})
+	}
+}
+
+func TestScanBacktrackingBounded(t *testing.T) {
+	format := "%{a} %{b} %{c} %{d} %{e} %{f} %{g} %{h}!"
+	rendered := strings.Repeat(" ", 500)
+
+	if _, err := Scan(format, rendered); err != ErrNoMatch {
+		t.Errorf("got %v, want ErrNoMatch", err)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	cases := []string{
+		"%{a}%{b}",
+		"%v%d",
+		"%{a",
+		"%{=a}",
+		"%Y",
+		"%",
+	}
+
+	for _, format := range cases {
+		if _, err := Compile(format); err == nil {
+			t.Errorf("Compile(%q) succeeded, want error", format)
+		}
+	}
+}
+
+func TestTemplateRoundtrip(t *testing.T) {
+	tmpl, err := Compile("%{method} %{path} -> %{status:d} in %{took:.3f}s")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args := []interface{}{"GET", "/a b", 200, 0.25}
+	rendered, _ := Sprintf(nil, tmpl.String(), args...)
+
+	ctx, err := tmpl.Scan(rendered)
+	if err != nil {
+		t.Fatalf("Scan(%q) failed: %v", rendered, err)
+	}
+
+	want := map[string]interface{}{"method": "GET", "path": "/a b", "status": int64(200), "took": 0.25}
+	if diff := cmp.Diff(want, ctxToMap(ctx)); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func BenchmarkTemplateScan(b *testing.B) {
+	tmpl, err := Compile("%{method} %{path} -> %{status:d} in %{took:.3f}s")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	msg := "GET /index.html -> 200 in 0.250s"
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := tmpl.Scan(msg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
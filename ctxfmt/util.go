@@ -21,13 +21,33 @@ func isErrorValue(v interface{}) bool {
 	return false
 }
 
+// isNilValue checks if v is nil or a nil pointer, map, slice, channel,
+// function, or interface.
+func isNilValue(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Chan, reflect.Func, reflect.Interface, reflect.UnsafePointer:
+		return rv.IsNil()
+	default:
+		return false
+	}
+}
+
 func unsafeString(b []byte) string {
 	return *(*string)(unsafe.Pointer(&b))
 }
 
-func unsafeBytes(s string) []byte {
-	sh := *((*reflect.SliceHeader)(unsafe.Pointer(&s)))
-	return *(*[]byte)((unsafe.Pointer)(&reflect.SliceHeader{Data: sh.Data, Len: sh.Len, Cap: sh.Cap}))
+// unsafeBytes converts s into a byte slice without copying. The capacity of
+// the slice is set to the string length, such that the slice can be resliced
+// safely by escaping writers.
+func unsafeBytes(s string) (b []byte) {
+	sh := (*reflect.StringHeader)(unsafe.Pointer(&s))
+	bh := (*reflect.SliceHeader)(unsafe.Pointer(&b))
+	bh.Data, bh.Len, bh.Cap = sh.Data, sh.Len, sh.Len
+	return b
 }
 
 func isFieldValue(v interface{}) bool {
@@ -230,7 +230,8 @@ func (c *Context) VisitKeyValues(v Visitor) error {
 
 // VisitStructured reports the context its structure to the visitor.
 // Fields having the same prefix separated by dots will be combined into
-// a common object.
+// a common object. Dots escaped with a backslash do not separate objects.
+// Object and field names are reported unescaped.
 func (c *Context) VisitStructured(v Visitor) error {
 	view := newView(c, c.mode&fieldsClosure == 0)
 	return view.VisitStructured(v)
@@ -243,9 +244,18 @@ func newView(ctx *Context, localOnly bool) *view {
 }
 
 func (view *view) VisitKeyValues(v Visitor) error {
+	return view.eachField(func(fld *Field) error {
+		return v.OnValue(fld.Key, fld.Value)
+	})
+}
+
+// eachField calls fn for all fields in the view, that are not shadowed by
+// other fields.
+func (view *view) eachField(fn func(fld *Field) error) error {
 	o := &view.order
 	L := o.Len()
 
+	var keySegs, otherSegs []string
 	for i := 0; i < L; i++ {
 		ctx, idx := o.ctx[i], o.idx[i]
 		fld := &ctx.fields[idx]
@@ -257,12 +267,16 @@ func (view *view) VisitKeyValues(v Visitor) error {
 				continue // ignore older duplicates
 			}
 
-			if strings.HasPrefix(other, key) && other[len(key)] == '.' {
-				continue // ignore value if it's overwritten by an object
+			if strings.HasPrefix(other, key) {
+				keySegs = splitKey(keySegs[:0], key)
+				otherSegs = splitKey(otherSegs[:0], other)
+				if hasPathPrefix(otherSegs, keySegs) {
+					continue // ignore value if it's overwritten by an object
+				}
 			}
 		}
 
-		if err := v.OnValue(key, fld.Value); err != nil {
+		if err := fn(fld); err != nil {
 			return err
 		}
 	}
@@ -271,93 +285,45 @@ func (view *view) VisitKeyValues(v Visitor) error {
 }
 
 func (view *view) VisitStructured(v Visitor) error {
-	o := &view.order
-	L := o.Len()
-
-	objPrefix := ""
-	level := 0
-
-	// TODO: this function keeps track of the number of ObjStart and ObjEnd events
-	// by scanning for `.` in the fields names.
-	// Instead of scanning let's check if we can store the string index in
-	// a slice (use as a stack) or if we should try to use recursion in order
-	// to track the 'index' stack on the go-routine stack itself.
-
-	for i := 0; i < L; i++ {
-		ctx, idx := o.ctx[i], o.idx[i]
-		fld := &ctx.fields[idx]
-		key := fld.Key
-
-		if j := i + 1; j < L {
-			other := o.key(j)
-			if key == other {
-				continue // ignore older duplicates
-			}
-
-			if strings.HasPrefix(other, key) && other[len(key)] == '.' {
-				continue // ignore value if it's overwritten by an object
-			}
+	// objects holds the escaped path segments of the currently open objects.
+	// VisitStructured relies on the fields being sorted by key, such that all
+	// fields of an object are reported in sequence.
+	var objects, segs []string
+
+	err := view.eachField(func(fld *Field) error {
+		segs = splitKey(segs[:0], fld.Key)
+		parents := segs[:len(segs)-1]
+
+		// close objects until the current and last key have the same path prefix
+		common := 0
+		for common < len(objects) && common < len(parents) && objects[common] == parents[common] {
+			common++
 		}
-
-		// decrease object level until last and current key have same path prefix
-		if L := commonPrefix(key, objPrefix); L < len(objPrefix) {
-			for L > 0 && key[L-1] != '.' {
-				L--
-			}
-
-			// remove levels
-			if L > 0 {
-				for delta := objPrefix[L:]; len(delta) > 0; {
-					idx := strings.IndexRune(delta, '.')
-					if idx < 0 {
-						break
-					}
-
-					delta = delta[idx+1:]
-					level--
-					if err := v.OnObjEnd(); err != nil {
-						return err
-					}
-				}
-
-				objPrefix = key[:L]
-			} else {
-				for ; level > 0; level-- {
-					if err := v.OnObjEnd(); err != nil {
-						return err
-					}
-				}
-				objPrefix = ""
+		for ; len(objects) > common; objects = objects[:len(objects)-1] {
+			if err := v.OnObjEnd(); err != nil {
+				return err
 			}
 		}
 
-		// increase object level
-		for {
-			start := len(objPrefix)
-			idx := strings.IndexRune(key[start:], '.')
-			if idx < 0 {
-				break
-			}
-
-			level++
-			objPrefix = key[:len(objPrefix)+idx+1]
-			if err := v.OnObjStart(key[start : start+idx]); err != nil {
+		// open objects
+		for _, name := range parents[common:] {
+			objects = append(objects, name)
+			if err := v.OnObjStart(UnescapeKey(name)); err != nil {
 				return err
 			}
 		}
 
-		k := key[len(objPrefix):]
-		if err := v.OnValue(k, fld.Value); err != nil {
-			return err
-		}
+		return v.OnValue(UnescapeKey(segs[len(segs)-1]), fld.Value)
+	})
+	if err != nil {
+		return err
 	}
 
-	for ; level > 0; level-- {
+	for ; len(objects) > 0; objects = objects[:len(objects)-1] {
 		if err := v.OnObjEnd(); err != nil {
 			return err
 		}
 	}
-
 	return nil
 }
 
@@ -417,17 +383,3 @@ func (o *order) Swap(i, j int) {
 	o.idx[i], o.idx[j] = o.idx[j], o.idx[i]
 	o.ctx[i], o.ctx[j] = o.ctx[j], o.ctx[i]
 }
-
-func commonPrefix(a, b string) int {
-	end := len(a)
-	if alt := len(b); alt < end {
-		end = alt
-	}
-
-	for i := 0; i < end; i++ {
-		if a[i] != b[i] {
-			return i
-		}
-	}
-	return end
-}
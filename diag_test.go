@@ -326,6 +326,23 @@ func TestCtxVisitKeyValues(t *testing.T) {
 	}, v.Get())
 }
 
+func TestCtxVisitKeyValuesEscapedShadowing(t *testing.T) {
+	ctx := makeCtx(nil, nil,
+		diag.Int(`a\`, 1),
+		diag.Int(`a\.b`, 2),
+		diag.Int(`c\\`, 3),
+		diag.Int(`c\\.d`, 4))
+
+	var v testVisitor
+	requireNoError(t, ctx.VisitKeyValues(&v))
+
+	requireEqual(t, map[string]interface{}{
+		`a\`:     1,
+		`a\.b`:   2,
+		`c\\.d`: 4,
+	}, v.Get())
+}
+
 func TestCtxVisitStructured(t *testing.T) {
 	ctx := makeCtx(nil, nil,
 		diag.String("a.b.field1", "test"),
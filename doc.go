@@ -37,4 +37,9 @@
 //
 //     ctx.AddField(myfields.Host("localhost"))
 //
+// Field keys use dots to separate nested objects. Use AddPath or JoinPath to
+// build keys from segments that contain dots themselves, like hostnames:
+//
+//     ctx.AddPath([]string{"hosts", "db.example.com"}, diag.ValBool(true))
+//
 package diag
@@ -0,0 +1,321 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+// Package cbor encodes diagnostic contexts in the Concise Binary Object
+// Representation (CBOR, RFC 8949), and decodes CBOR maps into diagnostic
+// contexts.
+//
+// Contexts are encoded as maps of indefinite length, such that fields can be
+// streamed without counting them first. Values are mapped to CBOR types
+// based on their diag.Type:
+//
+//	BoolType                    simple values true and false
+//	IntType, Int64Type          major type 0 (unsigned) or 1 (negative) integer
+//	Uint64Type                  major type 0 integer
+//	Float64Type                 64 bit float
+//	StringType                  major type 3 text string
+//	DurationType                integer nanoseconds
+//	TimestampType               tag 1 epoch time. Integer seconds, or float
+//	                            seconds with microsecond precision
+//
+// Other values are encoded based on their Go type. Errors are encoded by
+// their error message. Maps and structs are encoded as maps with the keys
+// sorted by their encoding. Map keys are converted to text strings, and
+// structs use the names of their exported fields as keys.
+package cbor
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/urso/diag"
+)
+
+// Encoder writes diagnostic contexts as CBOR maps.
+// An Encoder must not be used concurrently.
+type Encoder struct {
+	// Flat configures the encoder to write all fields into one map using
+	// dotted keys. By default fields with dotted keys are combined into nested
+	// maps.
+	Flat bool
+
+	w   io.Writer
+	buf []byte
+}
+
+// CBOR major types
+const (
+	majorUint   byte = 0 << 5
+	majorNegInt byte = 1 << 5
+	majorBytes  byte = 2 << 5
+	majorText   byte = 3 << 5
+	majorArray  byte = 4 << 5
+	majorMap    byte = 5 << 5
+	majorTag    byte = 6 << 5
+	majorSimple byte = 7 << 5
+)
+
+const (
+	simpleFalse byte = majorSimple | 20
+	simpleTrue  byte = majorSimple | 21
+	simpleNull  byte = majorSimple | 22
+	simpleUndef byte = majorSimple | 23
+	typeFloat16 byte = majorSimple | 25
+	typeFloat32 byte = majorSimple | 26
+	typeFloat64 byte = majorSimple | 27
+	indefMap    byte = majorMap | 31
+	breakCode   byte = 0xff
+
+	tagEpochTime = 1
+)
+
+// NewEncoder creates a new Encoder writing to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Marshal encodes the context as nested CBOR map.
+func Marshal(ctx *diag.Context) ([]byte, error) {
+	var e Encoder
+	return e.Append(nil, ctx)
+}
+
+// Encode writes the context as CBOR map to the writer configured with
+// NewEncoder.
+func (e *Encoder) Encode(ctx *diag.Context) error {
+	e.buf = e.buf[:0]
+	if err := e.encode(ctx); err != nil {
+		return err
+	}
+	_, err := e.w.Write(e.buf)
+	return err
+}
+
+// Append appends the CBOR encoded context to dst and returns the extended
+// buffer.
+func (e *Encoder) Append(dst []byte, ctx *diag.Context) ([]byte, error) {
+	scratch := e.buf
+	e.buf = dst
+	err := e.encode(ctx)
+	dst = e.buf
+	e.buf = scratch[:0]
+	return dst, err
+}
+
+func (e *Encoder) encode(ctx *diag.Context) error {
+	e.buf = append(e.buf, indefMap)
+
+	var err error
+	if e.Flat {
+		err = ctx.VisitKeyValues(e)
+	} else {
+		err = ctx.VisitStructured(e)
+	}
+	if err != nil {
+		return err
+	}
+
+	e.buf = append(e.buf, breakCode)
+	return nil
+}
+
+// OnObjStart starts a nested map.
+func (e *Encoder) OnObjStart(key string) error {
+	e.buf = appendText(e.buf, key)
+	e.buf = append(e.buf, indefMap)
+	return nil
+}
+
+// OnObjEnd closes the current map.
+func (e *Encoder) OnObjEnd() error {
+	e.buf = append(e.buf, breakCode)
+	return nil
+}
+
+// OnValue writes a field to the current map.
+func (e *Encoder) OnValue(key string, v diag.Value) error {
+	e.buf = appendText(e.buf, key)
+	if v.Reporter == nil {
+		e.buf = append(e.buf, simpleNull)
+		return nil
+	}
+
+	switch v.Reporter.Type() {
+	case diag.BoolType:
+		e.buf = appendBool(e.buf, v.Primitive != 0)
+	case diag.IntType, diag.Int64Type, diag.DurationType:
+		e.buf = appendInt(e.buf, int64(v.Primitive))
+	case diag.Uint64Type:
+		e.buf = appendHead(e.buf, majorUint, v.Primitive)
+	case diag.Float64Type:
+		e.buf = appendFloat(e.buf, math.Float64frombits(v.Primitive))
+	case diag.StringType:
+		e.buf = appendText(e.buf, v.String)
+	default:
+		e.buf = appendAny(e.buf, reflect.ValueOf(v.Interface()), 0)
+	}
+	return nil
+}
+
+// appendHead writes the initial byte of a data item, followed by the
+// argument n using the shortest encoding.
+func appendHead(b []byte, major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return append(b, major|byte(n))
+	case n <= math.MaxUint8:
+		return append(b, major|24, byte(n))
+	case n <= math.MaxUint16:
+		return append(b, major|25, byte(n>>8), byte(n))
+	case n <= math.MaxUint32:
+		return append(b, major|26, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	default:
+		var tmp [8]byte
+		binary.BigEndian.PutUint64(tmp[:], n)
+		return append(append(b, major|27), tmp[:]...)
+	}
+}
+
+func appendBool(b []byte, v bool) []byte {
+	if v {
+		return append(b, simpleTrue)
+	}
+	return append(b, simpleFalse)
+}
+
+func appendInt(b []byte, i int64) []byte {
+	if i < 0 {
+		return appendHead(b, majorNegInt, uint64(-1-i))
+	}
+	return appendHead(b, majorUint, uint64(i))
+}
+
+func appendFloat(b []byte, f float64) []byte {
+	var tmp [8]byte
+	binary.BigEndian.PutUint64(tmp[:], math.Float64bits(f))
+	return append(append(b, typeFloat64), tmp[:]...)
+}
+
+func appendText(b []byte, s string) []byte {
+	return append(appendHead(b, majorText, uint64(len(s))), s...)
+}
+
+func appendTime(b []byte, ts time.Time) []byte {
+	b = appendHead(b, majorTag, tagEpochTime)
+	sec, usec := ts.Unix(), ts.Nanosecond()/1000
+	if usec == 0 {
+		return appendInt(b, sec)
+	}
+	return appendFloat(b, float64(sec)+float64(usec)/1e6)
+}
+
+// mapEntry holds the encoded key and the value of a map entry or struct
+// field.
+type mapEntry struct {
+	key   []byte
+	value reflect.Value
+}
+
+// appendEntries encodes entries as a map. The entries are sorted by their
+// encoded keys, as required for deterministic encoding (RFC 8949, section
+// 4.2.1).
+func appendEntries(b []byte, entries []mapEntry, depth int) []byte {
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].key, entries[j].key) < 0
+	})
+
+	b = appendHead(b, majorMap, uint64(len(entries)))
+	for _, e := range entries {
+		b = append(b, e.key...)
+		b = appendAny(b, e.value, depth+1)
+	}
+	return b
+}
+
+// maxDepth limits the nesting of values encoded or decoded.
+const maxDepth = 256
+
+// appendAny encodes values of type IfcType based on their Go type.
+func appendAny(b []byte, v reflect.Value, depth int) []byte {
+	if !v.IsValid() {
+		return append(b, simpleNull)
+	}
+	if depth > maxDepth {
+		return append(b, simpleUndef)
+	}
+
+	if v.CanInterface() {
+		switch x := v.Interface().(type) {
+		case time.Time:
+			return appendTime(b, x)
+		case time.Duration:
+			return appendInt(b, int64(x))
+		case []byte:
+			return append(appendHead(b, majorBytes, uint64(len(x))), x...)
+		case error:
+			return appendText(b, x.Error())
+		case encoding.TextMarshaler:
+			if text, err := x.MarshalText(); err == nil {
+				return appendText(b, string(text))
+			}
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return appendBool(b, v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return appendInt(b, v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return appendHead(b, majorUint, v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return appendFloat(b, v.Float())
+	case reflect.String:
+		return appendText(b, v.String())
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return append(b, simpleNull)
+		}
+		b = appendHead(b, majorArray, uint64(v.Len()))
+		for i := 0; i < v.Len(); i++ {
+			b = appendAny(b, v.Index(i), depth+1)
+		}
+		return b
+	case reflect.Map:
+		if v.IsNil() {
+			return append(b, simpleNull)
+		}
+		entries := make([]mapEntry, 0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			entries = append(entries, mapEntry{appendText(nil, fmt.Sprint(iter.Key())), iter.Value()})
+		}
+		return appendEntries(b, entries, depth)
+	case reflect.Struct:
+		t := v.Type()
+		entries := make([]mapEntry, 0, t.NumField())
+		for i := 0; i < t.NumField(); i++ {
+			if f := t.Field(i); f.PkgPath == "" {
+				entries = append(entries, mapEntry{appendText(nil, f.Name), v.Field(i)})
+			}
+		}
+		return appendEntries(b, entries, depth)
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return append(b, simpleNull)
+		}
+		return appendAny(b, v.Elem(), depth+1)
+	default:
+		return appendText(b, fmt.Sprint(v))
+	}
+}
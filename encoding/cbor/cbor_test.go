@@ -0,0 +1,266 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+package cbor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/urso/diag"
+	"github.com/urso/diag/encoding/json"
+	"github.com/urso/diag/internal/testctx"
+)
+
+func TestEncoder(t *testing.T) {
+	cases := map[string]struct {
+		encoder Encoder
+		ctx     *diag.Context
+		want    string // hex encoded CBOR
+	}{
+		"empty": {
+			ctx:  testctx.New(),
+			want: "bfff",
+		},
+		"small ints": {
+			ctx:  testctx.New(diag.Int("a", 0), diag.Int("b", 23), diag.Int("c", 24), diag.Int("d", -1)),
+			want: "bf" + "6161" + "00" + "6162" + "17" + "6163" + "1818" + "6164" + "20" + "ff",
+		},
+		"large ints": {
+			ctx:  testctx.New(diag.Int64("a", 1000000), diag.Uint64("b", math.MaxUint64), diag.Int64("c", -1000)),
+			want: "bf" + "6161" + "1a000f4240" + "6162" + "1bffffffffffffffff" + "6163" + "3903e7" + "ff",
+		},
+		"simple values": {
+			ctx:  testctx.New(diag.Bool("a", false), diag.Bool("b", true), "c", nil),
+			want: "bf" + "6161" + "f4" + "6162" + "f5" + "6163" + "f6" + "ff",
+		},
+		"float": {
+			ctx:  testctx.New(diag.Float("f", 1.1)),
+			want: "bf" + "6166" + "fb3ff199999999999a" + "ff",
+		},
+		"string": {
+			ctx:  testctx.New(diag.String("s", "IETF")),
+			want: "bf" + "6173" + "6449455446" + "ff",
+		},
+		"timestamp": {
+			ctx:  testctx.New(diag.Timestamp("t", time.Unix(1363896240, 0))),
+			want: "bf" + "6174" + "c11a514b67b0" + "ff",
+		},
+		"fractional timestamp": {
+			ctx:  testctx.New(diag.Timestamp("t", time.Unix(1363896240, 500000000))),
+			want: "bf" + "6174" + "c1fb41d452d9ec200000" + "ff",
+		},
+		"duration": {
+			ctx:  testctx.New(diag.Duration("d", time.Microsecond)),
+			want: "bf" + "6164" + "1903e8" + "ff",
+		},
+		"nested": {
+			ctx:  testctx.New(diag.Int("a.b", 1), diag.Int("c", 2)),
+			want: "bf" + "6161" + "bf" + "6162" + "01" + "ff" + "6163" + "02" + "ff",
+		},
+		"flat": {
+			encoder: Encoder{Flat: true},
+			ctx:     testctx.New(diag.Int("a.b", 1)),
+			want:    "bf" + "63612e62" + "01" + "ff",
+		},
+		"interface values": {
+			ctx:  testctx.New("a", []int{1, 2}, "e", errors.New("x"), "b", []byte{1}),
+			want: "bf" + "6161" + "820102" + "6162" + "4101" + "6165" + "6178" + "ff",
+		},
+		"sorted map keys": {
+			ctx:  testctx.New("m", map[string]int{"bb": 2, "c": 3, "a": 1}),
+			want: "bf" + "616d" + "a3" + "6161" + "01" + "6163" + "03" + "626262" + "02" + "ff",
+		},
+		"struct": {
+			ctx:  testctx.New("s", struct{ B, A, c int }{1, 2, 3}),
+			want: "bf" + "6173" + "a2" + "6141" + "02" + "6142" + "01" + "ff",
+		},
+	}
+
+	for name, test := range cases {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			got, err := test.encoder.Append(nil, test.ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if h := hex.EncodeToString(got); h != test.want {
+				t.Errorf("got  %v\nwant %v", h, test.want)
+			}
+		})
+	}
+}
+
+func TestRoundtripJSON(t *testing.T) {
+	ctx := testctx.New(
+		diag.Bool("ok", true),
+		diag.Int("http.status", 200),
+		diag.String("http.method", "GET"),
+		diag.Int64("min", math.MinInt64),
+		diag.Uint64("max", math.MaxUint64),
+		diag.Float("load", 0.25),
+		diag.Float("neg", -1e300),
+		diag.Duration("took", 1500*time.Millisecond),
+		diag.Timestamp("ts", time.Date(2020, 1, 2, 3, 4, 5, 123456000, time.UTC)),
+		diag.Timestamp("before.epoch", time.Date(1960, 1, 2, 3, 4, 5, 250000000, time.UTC)),
+		diag.String("msg", "hello \"wörld\"\n"),
+		"list", []interface{}{"a", 1, []string{"b"}},
+		"empty", []string{},
+		"nil", nil,
+	)
+	ctx.AddPath([]string{"db.example.com"}, diag.ValInt(1))
+	ctx.AddPath([]string{"hosts", "web.example.com"}, diag.ValInt(2))
+
+	data, err := Marshal(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded := diag.NewContext(nil, nil)
+	if err := Unmarshal(data, decoded); err != nil {
+		t.Fatalf("decoding failed: %v", err)
+	}
+
+	want, err := json.Marshal(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := json.Marshal(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(want, got) {
+		t.Errorf("roundtrip mismatch\nwant %s\ngot  %s", want, got)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	cases := map[string]struct {
+		flat  bool
+		input string // hex encoded CBOR
+		want  string // JSON encoding of the decoded context
+	}{
+		"definite map": {
+			input: "a2" + "6161" + "01" + "6162" + "a1" + "6163" + "02",
+			want:  `{"a":1,"b":{"c":2}}`,
+		},
+		"dotted keys": {
+			input: "a2" + "6161" + "a1" + "63622e63" + "01" + "63642e65" + "02",
+			want:  `{"a":{"b.c":1},"d.e":2}`,
+		},
+		"flat dotted top-level keys": {
+			flat:  true,
+			input: "a2" + "6161" + "a1" + "63622e63" + "01" + "63642e65" + "02",
+			want:  `{"a":{"b.c":1},"d":{"e":2}}`,
+		},
+		"half floats": {
+			input: "a3" + "6161" + "f93e00" + "6162" + "f97c00" + "6163" + "f90001",
+			want:  `{"a":1.5,"b":null,"c":5.960464477539063e-8}`,
+		},
+		"single float": {
+			input: "a1" + "6161" + "fa47c35000",
+			want:  `{"a":100000}`,
+		},
+		"indefinite strings": {
+			input: "a1" + "7f" + "6161" + "6162" + "ff" + "7f" + "6163" + "ff",
+			want:  `{"ab":"c"}`,
+		},
+		"unknown tags are ignored": {
+			input: "a1" + "6161" + "d82076687474703a2f2f7777772e6578616d706c652e636f6d",
+			want:  `{"a":"http://www.example.com"}`,
+		},
+		"map in array": {
+			input: "a1" + "6161" + "9f" + "a1" + "6162" + "01" + "ff",
+			want:  `{"a":[{"b":1}]}`,
+		},
+	}
+
+	for name, test := range cases {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			data, _ := hex.DecodeString(test.input)
+			dec := NewDecoder(bytes.NewReader(data))
+			dec.Flat = test.flat
+			ctx := diag.NewContext(nil, nil)
+			if err := dec.Decode(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := json.Marshal(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != test.want {
+				t.Errorf("got  %s\nwant %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	cases := map[string]string{
+		"not a map":      "01",
+		"truncated":      "bf6161",
+		"truncated text": "a16161" + "65616263",
+		"integer key":    "a10101",
+		"huge length":    "a16161" + "7bffffffffffffffff",
+		"stray break":    "a16161ff",
+		"invalid info":   "a161611c",
+	}
+
+	for name, input := range cases {
+		data, _ := hex.DecodeString(input)
+		ctx := diag.NewContext(nil, nil)
+		if err := Unmarshal(data, ctx); err == nil {
+			t.Errorf("%v: expected error", name)
+		}
+		if ctx.Len() != 0 {
+			t.Errorf("%v: fields added on error", name)
+		}
+	}
+}
+
+func TestEncoderStream(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	for i := 0; i < 3; i++ {
+		if err := enc.Encode(testctx.New(diag.Int("i", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dec := NewDecoder(&buf)
+	for i := 0; i < 3; i++ {
+		ctx := diag.NewContext(nil, nil)
+		if err := dec.Decode(ctx); err != nil {
+			t.Fatal(err)
+		}
+		got, _ := json.Marshal(ctx)
+		if want := `{"i":` + string(rune('0'+i)) + `}`; string(got) != want {
+			t.Errorf("got %s, want %s", got, want)
+		}
+	}
+}
+
+func BenchmarkEncoder(b *testing.B) {
+	ctx := testctx.New(
+		diag.String("http.method", "GET"),
+		diag.Int("http.status", 200),
+		diag.Duration("took", time.Second),
+		diag.String("msg", "hello world"),
+	)
+
+	var enc Encoder
+	var buf []byte
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf, _ = enc.Append(buf[:0], ctx)
+	}
+}
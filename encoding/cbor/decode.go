@@ -0,0 +1,429 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+package cbor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/urso/diag"
+)
+
+// Decoder reads CBOR maps from an input stream and adds the fields to
+// diagnostic contexts. Nested maps are flattened into dotted keys.
+// Keys are escaped with diag.EscapeKey, such that keys containing dots are
+// reported as a single name by VisitStructured. Set Flat to decode maps
+// written with dotted keys into nested objects.
+//
+// Unsigned integers are decoded as int64, or uint64 if too large for int64.
+// Negative integers are decoded as int64. Floats of any size are decoded as
+// float64. Epoch timestamps (tag 1) are decoded as time.Time in UTC. All other
+// tags are ignored. Arrays are decoded as []interface{}, byte strings as
+// []byte, and maps nested in arrays as map[string]interface{}.
+type Decoder struct {
+	// Flat configures the decoder to split the keys of the top-level map at
+	// dots, as written by an Encoder with Flat set. Keys of nested maps are
+	// always escaped.
+	Flat bool
+
+	r reader
+}
+
+type reader interface {
+	io.Reader
+	io.ByteReader
+}
+
+var (
+	errNoMap        = errors.New("cbor: input is not a map")
+	errInvalidKey   = errors.New("cbor: map key is not a string")
+	errTooDeep      = errors.New("cbor: nesting too deep")
+	errInvalidBreak = errors.New("cbor: unexpected break")
+)
+
+// NewDecoder creates a new Decoder reading from r.
+func NewDecoder(r io.Reader) *Decoder {
+	br, ok := r.(reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &Decoder{r: br}
+}
+
+// Unmarshal decodes the CBOR map in data and adds its fields to ctx.
+func Unmarshal(data []byte, ctx *diag.Context) error {
+	return NewDecoder(bytes.NewReader(data)).Decode(ctx)
+}
+
+// Decode reads the next CBOR map from the input and adds its fields to ctx.
+// Decode returns io.EOF if the input is exhausted.
+func (d *Decoder) Decode(ctx *diag.Context) error {
+	ib, err := d.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if ib&0xe0 != majorMap {
+		return errNoMap
+	}
+
+	var fields []diag.Field
+	if err := d.decodeMap(ib, "", &fields, 0); err != nil {
+		return unexpectedEOF(err)
+	}
+	ctx.AddFields(fields...)
+	return nil
+}
+
+// decodeMap reads the entries of a map, after the initial byte ib has been
+// read. Nested maps are flattened into fields.
+func (d *Decoder) decodeMap(ib byte, prefix string, fields *[]diag.Field, depth int) error {
+	if depth > maxDepth {
+		return errTooDeep
+	}
+
+	n, indef, err := d.length(ib)
+	if err != nil {
+		return err
+	}
+
+	for i := uint64(0); indef || i < n; i++ {
+		kb, err := d.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if indef && kb == breakCode {
+			return nil
+		}
+		if kb&0xe0 != majorText {
+			return errInvalidKey
+		}
+		key, err := d.text(kb)
+		if err != nil {
+			return err
+		}
+		if prefix != "" || !d.Flat {
+			key = prefix + diag.EscapeKey(key)
+		}
+
+		vb, err := d.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if vb&0xe0 == majorMap {
+			if err := d.decodeMap(vb, key+".", fields, depth+1); err != nil {
+				return err
+			}
+			continue
+		}
+
+		val, err := d.value(vb, depth+1)
+		if err != nil {
+			return err
+		}
+		*fields = append(*fields, diag.Field{Key: key, Value: toValue(val)})
+	}
+	return nil
+}
+
+func toValue(v interface{}) diag.Value {
+	switch x := v.(type) {
+	case bool:
+		return diag.ValBool(x)
+	case int64:
+		return diag.ValInt64(x)
+	case uint64:
+		return diag.ValUint64(x)
+	case float64:
+		return diag.ValFloat(x)
+	case string:
+		return diag.ValString(x)
+	case time.Time:
+		return diag.ValTime(x)
+	default:
+		return diag.ValAny(x)
+	}
+}
+
+// value decodes a single data item, after the initial byte ib has been read.
+func (d *Decoder) value(ib byte, depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, errTooDeep
+	}
+
+	switch major := ib & 0xe0; major {
+	case majorUint:
+		n, err := d.arg(ib)
+		if err != nil {
+			return nil, err
+		}
+		if n <= math.MaxInt64 {
+			return int64(n), nil
+		}
+		return n, nil
+
+	case majorNegInt:
+		n, err := d.arg(ib)
+		if err != nil {
+			return nil, err
+		}
+		if n > math.MaxInt64 {
+			return nil, fmt.Errorf("cbor: negative integer -1-%v out of range", n)
+		}
+		return -1 - int64(n), nil
+
+	case majorBytes:
+		return d.bytes(ib)
+
+	case majorText:
+		return d.text(ib)
+
+	case majorArray:
+		n, indef, err := d.length(ib)
+		if err != nil {
+			return nil, err
+		}
+
+		arr := []interface{}{}
+		for i := uint64(0); indef || i < n; i++ {
+			eb, err := d.r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			if indef && eb == breakCode {
+				break
+			}
+			elem, err := d.value(eb, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, elem)
+		}
+		return arr, nil
+
+	case majorMap:
+		n, indef, err := d.length(ib)
+		if err != nil {
+			return nil, err
+		}
+
+		m := map[string]interface{}{}
+		for i := uint64(0); indef || i < n; i++ {
+			kb, err := d.r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			if indef && kb == breakCode {
+				break
+			}
+			if kb&0xe0 != majorText {
+				return nil, errInvalidKey
+			}
+			key, err := d.text(kb)
+			if err != nil {
+				return nil, err
+			}
+
+			vb, err := d.r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			if m[key], err = d.value(vb, depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+
+	case majorTag:
+		tag, err := d.arg(ib)
+		if err != nil {
+			return nil, err
+		}
+		cb, err := d.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		content, err := d.value(cb, depth+1)
+		if err != nil || tag != tagEpochTime {
+			return content, err
+		}
+		return epochTime(content)
+
+	default: // majorSimple
+		switch ib {
+		case simpleFalse:
+			return false, nil
+		case simpleTrue:
+			return true, nil
+		case simpleNull, simpleUndef:
+			return nil, nil
+		case typeFloat16:
+			var tmp [2]byte
+			if _, err := io.ReadFull(d.r, tmp[:]); err != nil {
+				return nil, err
+			}
+			return halfToFloat(binary.BigEndian.Uint16(tmp[:])), nil
+		case typeFloat32:
+			var tmp [4]byte
+			if _, err := io.ReadFull(d.r, tmp[:]); err != nil {
+				return nil, err
+			}
+			return float64(math.Float32frombits(binary.BigEndian.Uint32(tmp[:]))), nil
+		case typeFloat64:
+			var tmp [8]byte
+			if _, err := io.ReadFull(d.r, tmp[:]); err != nil {
+				return nil, err
+			}
+			return math.Float64frombits(binary.BigEndian.Uint64(tmp[:])), nil
+		case breakCode:
+			return nil, errInvalidBreak
+		default:
+			if _, err := d.arg(ib); err != nil {
+				return nil, err
+			}
+			return nil, nil // unassigned simple values
+		}
+	}
+}
+
+// arg reads the argument of the data item with initial byte ib.
+func (d *Decoder) arg(ib byte) (uint64, error) {
+	info := ib & 0x1f
+	if info < 24 {
+		return uint64(info), nil
+	}
+
+	var size int
+	switch info {
+	case 24:
+		size = 1
+	case 25:
+		size = 2
+	case 26:
+		size = 4
+	case 27:
+		size = 8
+	default:
+		return 0, fmt.Errorf("cbor: invalid additional information %v", info)
+	}
+
+	var tmp [8]byte
+	if _, err := io.ReadFull(d.r, tmp[8-size:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(tmp[:]), nil
+}
+
+// length reads the length of a string, array, or map. The indef flag is set
+// if the data item has indefinite length.
+func (d *Decoder) length(ib byte) (n uint64, indef bool, err error) {
+	if ib&0x1f == 31 {
+		return 0, true, nil
+	}
+	n, err = d.arg(ib)
+	return n, false, err
+}
+
+func (d *Decoder) text(ib byte) (string, error) {
+	b, err := d.bytes(ib)
+	return string(b), err
+}
+
+// bytes reads a byte or text string. Chunks of strings with indefinite length
+// are concatenated.
+func (d *Decoder) bytes(ib byte) ([]byte, error) {
+	major := ib & 0xe0
+	n, indef, err := d.length(ib)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if !indef {
+		if n > math.MaxInt64 {
+			return nil, fmt.Errorf("cbor: string length %v out of range", n)
+		}
+
+		// copy incrementally, such that an invalid length does not trigger
+		// a huge allocation.
+		if _, err := io.CopyN(&buf, d.r, int64(n)); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	for {
+		cb, err := d.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if cb == breakCode {
+			return buf.Bytes(), nil
+		}
+		if cb&0xe0 != major || cb&0x1f == 31 {
+			return nil, fmt.Errorf("cbor: invalid chunk in indefinite length string")
+		}
+
+		chunk, err := d.bytes(cb)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(chunk)
+	}
+}
+
+func epochTime(v interface{}) (interface{}, error) {
+	switch x := v.(type) {
+	case int64:
+		return time.Unix(x, 0).UTC(), nil
+	case uint64:
+		return nil, fmt.Errorf("cbor: epoch time %v out of range", x)
+	case float64:
+		if math.IsNaN(x) || math.IsInf(x, 0) {
+			return nil, fmt.Errorf("cbor: invalid epoch time %v", x)
+		}
+		sec := math.Floor(x)
+		usec := math.Round((x - sec) * 1e6)
+		return time.Unix(int64(sec), int64(usec)*1000).UTC(), nil
+	default:
+		return nil, fmt.Errorf("cbor: invalid epoch time of type %T", v)
+	}
+}
+
+// halfToFloat converts an IEEE 754 half precision float to float64.
+func halfToFloat(h uint16) float64 {
+	sign := 1.0
+	if h&0x8000 != 0 {
+		sign = -1
+	}
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+
+	switch exp {
+	case 0:
+		return sign * math.Ldexp(mant, -24)
+	case 0x1f:
+		if mant == 0 {
+			return math.Inf(int(sign))
+		}
+		return math.NaN()
+	default:
+		return sign * math.Ldexp(mant+1024, exp-25)
+	}
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,440 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+// Package console renders diagnostic contexts in a human friendly format for
+// terminals.
+//
+// Each context is printed as one line. Leading fields like time, level, and
+// message are printed first without key, followed by all other fields as
+// dimmed key=value pairs:
+//
+//	15:04:05.000 INFO  request handled http.method=GET http.status=200
+//
+// If Indent is set, the remaining fields are printed on separate lines
+// instead, with nested objects being indented.
+package console
+
+import (
+	"encoding"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/urso/diag"
+)
+
+// Encoder writes diagnostic contexts to a console.
+// An Encoder must not be used concurrently.
+type Encoder struct {
+	// LeadingKeys configures the fields to be printed first, without key.
+	// DefaultLeadingKeys is used if LeadingKeys is nil.
+	LeadingKeys []Column
+
+	// LevelKey names the field holding the log level. The level is printed
+	// in upper case and colored by severity. Defaults to "level".
+	LevelKey string
+
+	// TimeFormat configures the layout used to print timestamps.
+	// Defaults to "15:04:05.000".
+	TimeFormat string
+
+	// Indent prints the remaining fields on separate lines, indenting nested
+	// objects. By default nested objects are flattened into dotted keys.
+	Indent bool
+
+	// Color configures the use of ANSI colors.
+	Color ColorMode
+
+	// IsTerminal reports whether the writer is a terminal. It is used to
+	// decide if colors should be used with ColorAuto. Colors are disabled in
+	// ColorAuto mode if IsTerminal is nil.
+	IsTerminal func(w io.Writer) bool
+
+	w       io.Writer
+	buf     []byte
+	colored bool
+	leading []diag.Value
+	found   []bool
+	path    []string
+	printed int // number of object names in path already printed
+}
+
+// Column configures a leading field.
+type Column struct {
+	Key   string // field name
+	Width int    // minimum width of the column. Shorter values are padded
+}
+
+// ColorMode selects when colors are used.
+type ColorMode uint8
+
+const (
+	// ColorAuto uses colors if the writer is a terminal.
+	ColorAuto ColorMode = iota
+
+	// ColorAlways always uses colors.
+	ColorAlways
+
+	// ColorNever disables colors.
+	ColorNever
+)
+
+// DefaultLeadingKeys lists the leading fields printed if not configured
+// otherwise.
+var DefaultLeadingKeys = []Column{
+	{Key: "time"},
+	{Key: "level", Width: 5},
+	{Key: "message"},
+}
+
+const (
+	colorReset = "\x1b[0m"
+	colorDim   = "\x1b[2m"
+	colorRed   = "\x1b[31m"
+	colorGreen = "\x1b[32m"
+	colorYel   = "\x1b[33m"
+	colorBlue  = "\x1b[34m"
+
+	defaultTimeFormat = "15:04:05.000"
+
+	hexDigits = "0123456789abcdef"
+)
+
+// NewEncoder creates a new Encoder writing to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes the context to the writer configured with NewEncoder.
+func (e *Encoder) Encode(ctx *diag.Context) error {
+	switch e.Color {
+	case ColorAlways:
+		e.colored = true
+	case ColorNever:
+		e.colored = false
+	default:
+		e.colored = e.IsTerminal != nil && e.IsTerminal(e.w)
+	}
+
+	e.buf = e.buf[:0]
+	if err := e.encode(ctx); err != nil {
+		return err
+	}
+	_, err := e.w.Write(e.buf)
+	return err
+}
+
+func (e *Encoder) encode(ctx *diag.Context) error {
+	columns := e.columns()
+	e.leading = e.leading[:0]
+	e.found = e.found[:0]
+	for range columns {
+		e.leading = append(e.leading, diag.Value{})
+		e.found = append(e.found, false)
+	}
+
+	if err := ctx.VisitKeyValues(leadingCollector{e}); err != nil {
+		return err
+	}
+
+	first := true
+	for i, col := range columns {
+		if !e.found[i] {
+			continue
+		}
+		if !first {
+			e.buf = append(e.buf, ' ')
+		}
+		first = false
+		e.appendLeading(col, &e.leading[i])
+	}
+
+	e.path = e.path[:0]
+	e.printed = 0
+	var err error
+	if e.Indent {
+		err = ctx.VisitStructured(indentVisitor{e})
+	} else {
+		err = ctx.VisitKeyValues(flatVisitor{e})
+	}
+	if err != nil {
+		return err
+	}
+
+	e.buf = append(e.buf, '\n')
+	return nil
+}
+
+func (e *Encoder) columns() []Column {
+	if e.LeadingKeys == nil {
+		return DefaultLeadingKeys
+	}
+	return e.LeadingKeys
+}
+
+func (e *Encoder) levelKey() string {
+	if e.LevelKey == "" {
+		return "level"
+	}
+	return e.LevelKey
+}
+
+// leadingIndex returns the index of the leading column for key, or -1.
+func (e *Encoder) leadingIndex(key string) int {
+	for i, col := range e.columns() {
+		if col.Key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func (e *Encoder) appendLeading(col Column, v *diag.Value) {
+	start := len(e.buf)
+	color := ""
+
+	switch {
+	case col.Key == e.levelKey():
+		level := strings.ToUpper(e.valueString(v, false))
+		color = levelColor(level)
+		e.buf = append(e.buf, level...)
+	case v.Reporter != nil && v.Reporter.Type() == diag.TimestampType:
+		color = colorDim
+		e.buf = e.appendValue(e.buf, v, false)
+	default:
+		e.buf = e.appendValue(e.buf, v, false)
+	}
+
+	if n := utf8.RuneCount(e.buf[start:]); n < col.Width {
+		e.buf = append(e.buf, strings.Repeat(" ", col.Width-n)...)
+	}
+
+	if color != "" && e.colored {
+		text := string(e.buf[start:])
+		e.buf = append(e.buf[:start], color...)
+		e.buf = append(e.buf, text...)
+		e.buf = append(e.buf, colorReset...)
+	}
+}
+
+func levelColor(level string) string {
+	switch level {
+	case "ERROR", "ERR", "FATAL", "PANIC", "CRITICAL":
+		return colorRed
+	case "WARN", "WARNING":
+		return colorYel
+	case "INFO":
+		return colorGreen
+	case "DEBUG", "TRACE":
+		return colorBlue
+	default:
+		return ""
+	}
+}
+
+func (e *Encoder) dim() {
+	if e.colored {
+		e.buf = append(e.buf, colorDim...)
+	}
+}
+
+func (e *Encoder) reset() {
+	if e.colored {
+		e.buf = append(e.buf, colorReset...)
+	}
+}
+
+func (e *Encoder) valueString(v *diag.Value, quote bool) string {
+	return string(e.appendValue(nil, v, quote))
+}
+
+// appendValue prints the value. Strings are quoted if quote is set and the
+// string contains spaces, quotes, '=', or non-printable characters. Unquoted
+// strings are escaped.
+func (e *Encoder) appendValue(b []byte, v *diag.Value, quote bool) []byte {
+	if v.Reporter == nil {
+		return append(b, "<nil>"...)
+	}
+
+	switch v.Reporter.Type() {
+	case diag.BoolType:
+		return strconv.AppendBool(b, v.Primitive != 0)
+	case diag.IntType, diag.Int64Type:
+		return strconv.AppendInt(b, int64(v.Primitive), 10)
+	case diag.Uint64Type:
+		return strconv.AppendUint(b, v.Primitive, 10)
+	case diag.Float64Type:
+		return strconv.AppendFloat(b, math.Float64frombits(v.Primitive), 'g', -1, 64)
+	case diag.DurationType:
+		return append(b, time.Duration(v.Primitive).String()...)
+	case diag.StringType:
+		return appendString(b, v.String, quote)
+	case diag.TimestampType:
+		if ts, ok := v.Ifc.(time.Time); ok {
+			layout := e.TimeFormat
+			if layout == "" {
+				layout = defaultTimeFormat
+			}
+			return ts.AppendFormat(b, layout)
+		}
+	}
+
+	var s string
+	switch x := v.Interface().(type) {
+	case nil:
+		s = "<nil>"
+	case error:
+		s = x.Error()
+	case encoding.TextMarshaler:
+		if text, err := x.MarshalText(); err == nil {
+			s = string(text)
+		} else {
+			s = fmt.Sprintf("!ERROR(%v)", err)
+		}
+	default:
+		s = fmt.Sprint(x)
+	}
+	return appendString(b, s, quote)
+}
+
+func appendString(b []byte, s string, quote bool) []byte {
+	if quote && needsQuoting(s) {
+		return strconv.AppendQuote(b, s)
+	}
+	return appendEscaped(b, s)
+}
+
+// appendEscaped writes s, replacing non-printable characters and invalid
+// UTF-8 with Go escape sequences, such that control characters and terminal
+// escape sequences are never written to the console.
+func appendEscaped(b []byte, s string) []byte {
+	start := 0
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == ' ' || (strconv.IsPrint(r) && !(r == utf8.RuneError && size == 1)) {
+			i += size
+			continue
+		}
+
+		b = append(b, s[start:i]...)
+		if r == utf8.RuneError && size == 1 {
+			b = append(b, `\x`...)
+			b = append(b, hexDigits[s[i]>>4], hexDigits[s[i]&0xf])
+		} else {
+			q := strconv.QuoteRune(r)
+			b = append(b, q[1:len(q)-1]...)
+		}
+		i += size
+		start = i
+	}
+	return append(b, s[start:]...)
+}
+
+func needsQuoting(s string) bool {
+	if s == "" {
+		return true
+	}
+	for _, r := range s {
+		if r <= ' ' || r == '=' || r == '"' || r == utf8.RuneError || !strconv.IsPrint(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// leadingCollector finds the values of the leading fields.
+type leadingCollector struct{ e *Encoder }
+
+func (c leadingCollector) OnObjStart(_ string) error { return nil }
+func (c leadingCollector) OnObjEnd() error           { return nil }
+func (c leadingCollector) OnValue(key string, v diag.Value) error {
+	if i := c.e.leadingIndex(key); i >= 0 {
+		c.e.leading[i], c.e.found[i] = v, true
+	}
+	return nil
+}
+
+// flatVisitor prints the remaining fields as key=value pairs.
+type flatVisitor struct{ e *Encoder }
+
+func (v flatVisitor) OnObjStart(_ string) error { return nil }
+func (v flatVisitor) OnObjEnd() error           { return nil }
+func (v flatVisitor) OnValue(key string, val diag.Value) error {
+	e := v.e
+	if e.leadingIndex(key) >= 0 {
+		return nil
+	}
+
+	if len(e.buf) > 0 {
+		e.buf = append(e.buf, ' ')
+	}
+	e.dim()
+	e.buf = appendEscaped(e.buf, key)
+	e.buf = append(e.buf, '=')
+	e.buf = e.appendValue(e.buf, &val, true)
+	e.reset()
+	return nil
+}
+
+// indentVisitor prints the remaining fields on separate lines. The names of
+// nested objects are only printed once the first field in the object is
+// printed, such that objects only holding leading fields are omitted.
+type indentVisitor struct{ e *Encoder }
+
+func (v indentVisitor) OnObjStart(key string) error {
+	v.e.path = append(v.e.path, key)
+	return nil
+}
+
+func (v indentVisitor) OnObjEnd() error {
+	e := v.e
+	e.path = e.path[:len(e.path)-1]
+	if e.printed > len(e.path) {
+		e.printed = len(e.path)
+	}
+	return nil
+}
+
+func (v indentVisitor) OnValue(key string, val diag.Value) error {
+	e := v.e
+	// The path segments and key are unescaped, but leading keys are matched
+	// against escaped keys.
+	full := diag.EscapeKey(key)
+	if len(e.path) > 0 {
+		full = diag.JoinPath(e.path...) + "." + full
+	}
+	if e.leadingIndex(full) >= 0 {
+		return nil
+	}
+
+	for ; e.printed < len(e.path); e.printed++ {
+		v.newline(e.printed)
+		e.dim()
+		e.buf = appendEscaped(e.buf, e.path[e.printed])
+		e.buf = append(e.buf, ':')
+		e.reset()
+	}
+
+	v.newline(len(e.path))
+	e.dim()
+	e.buf = appendEscaped(e.buf, key)
+	e.buf = append(e.buf, ": "...)
+	e.buf = e.appendValue(e.buf, &val, false)
+	e.reset()
+	return nil
+}
+
+func (v indentVisitor) newline(depth int) {
+	e := v.e
+	e.buf = append(e.buf, '\n')
+	for i := 0; i <= depth; i++ {
+		e.buf = append(e.buf, "    "...)
+	}
+}
@@ -0,0 +1,145 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+package console
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/urso/diag"
+	"github.com/urso/diag/internal/testctx"
+)
+
+func TestEncoder(t *testing.T) {
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 6000000, time.UTC)
+	event := testctx.New(
+		diag.Timestamp("time", ts),
+		diag.String("level", "info"),
+		diag.String("message", "request handled"),
+		diag.String("http.method", "GET"),
+		diag.Int("http.status", 200),
+		diag.String("user", "jane doe"),
+	)
+
+	cases := map[string]struct {
+		encoder Encoder
+		ctx     *diag.Context
+		want    string
+	}{
+		"default": {
+			ctx:  event,
+			want: "03:04:05.006 INFO  request handled http.method=GET http.status=200 user=\"jane doe\"\n",
+		},
+		"missing leading fields": {
+			ctx:  testctx.New(diag.String("message", "hello"), "err", errors.New("oops")),
+			want: "hello err=oops\n",
+		},
+		"no leading fields": {
+			encoder: Encoder{LeadingKeys: []Column{}},
+			ctx:     testctx.New(diag.String("message", "hello"), diag.Duration("took", time.Second)),
+			want:    "message=hello took=1s\n",
+		},
+		"custom leading keys": {
+			encoder: Encoder{LeadingKeys: []Column{{Key: "lvl", Width: 6}, {Key: "msg"}}, LevelKey: "lvl"},
+			ctx:     testctx.New(diag.String("msg", "hello"), diag.String("lvl", "warn"), diag.Bool("ok", false)),
+			want:    "WARN   hello ok=false\n",
+		},
+		"escape control characters": {
+			ctx: testctx.New(
+				diag.String("message", "login\nINFO fake entry \x1b[31m"),
+				diag.String("user", "a\x1b]0;x\x07"),
+				diag.String("k\r", "\u202e\xff"),
+			),
+			want: `login\nINFO fake entry \x1b[31m k\r="\u202e\xff" user="a\x1b]0;x\a"` + "\n",
+		},
+		"escape indented values": {
+			encoder: Encoder{Indent: true},
+			ctx:     testctx.New(diag.String("message", "x"), diag.String("a.b", "1\n2")),
+			want:    "x\n    a:\n        b: 1\\n2\n",
+		},
+		"custom time format": {
+			encoder: Encoder{TimeFormat: time.RFC3339},
+			ctx:     testctx.New(diag.Timestamp("time", ts), diag.String("message", "x")),
+			want:    "2020-01-02T03:04:05Z x\n",
+		},
+		"colors": {
+			encoder: Encoder{Color: ColorAlways},
+			ctx:     event,
+			want: "\x1b[2m03:04:05.006\x1b[0m \x1b[32mINFO \x1b[0m request handled " +
+				"\x1b[2mhttp.method=GET\x1b[0m \x1b[2mhttp.status=200\x1b[0m \x1b[2muser=\"jane doe\"\x1b[0m\n",
+		},
+		"error level color": {
+			encoder: Encoder{Color: ColorAlways, LeadingKeys: []Column{{Key: "level"}}},
+			ctx:     testctx.New(diag.String("level", "error")),
+			want:    "\x1b[31mERROR\x1b[0m\n",
+		},
+		"auto color without terminal": {
+			encoder: Encoder{Color: ColorAuto, IsTerminal: func(io.Writer) bool { return false }},
+			ctx:     testctx.New(diag.String("level", "error")),
+			want:    "ERROR\n",
+		},
+		"auto color with terminal": {
+			encoder: Encoder{Color: ColorAuto, IsTerminal: func(io.Writer) bool { return true }},
+			ctx:     testctx.New(diag.String("level", "error")),
+			want:    "\x1b[31mERROR\x1b[0m\n",
+		},
+		"indent": {
+			encoder: Encoder{Indent: true},
+			ctx:     event,
+			want: "03:04:05.006 INFO  request handled\n" +
+				"    http:\n" +
+				"        method: GET\n" +
+				"        status: 200\n" +
+				"    user: jane doe\n",
+		},
+		"indent skips nested leading fields": {
+			encoder: Encoder{Indent: true, LeadingKeys: []Column{{Key: "log.level"}}, LevelKey: "log.level"},
+			ctx:     testctx.New(diag.String("log.level", "debug"), diag.String("a.b.c", "x"), diag.Int("a.d", 1)),
+			want: "DEBUG\n" +
+				"    a:\n" +
+				"        b:\n" +
+				"            c: x\n" +
+				"        d: 1\n",
+		},
+		"indent skips leading fields with escaped dots": {
+			encoder: Encoder{Indent: true, LeadingKeys: []Column{{Key: `svc.log\.level`}}, LevelKey: `svc.log\.level`},
+			ctx:     testctx.New(diag.String(`svc.log\.level`, "debug"), diag.Int("svc.id", 1)),
+			want: "DEBUG\n" +
+				"    svc:\n" +
+				"        id: 1\n",
+		},
+	}
+
+	for name, test := range cases {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			enc := test.encoder
+			enc.w = &buf
+			if err := enc.Encode(test.ctx); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != test.want {
+				t.Errorf("got  %q\nwant %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNewEncoder(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.Encode(testctx.New(diag.String("message", "a")))
+	enc.Encode(testctx.New(diag.String("message", "b")))
+
+	if got, want := buf.String(), "a\nb\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
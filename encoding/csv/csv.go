@@ -0,0 +1,359 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+// Package csv encodes batches of diagnostic contexts as CSV or TSV tables.
+//
+// Each context is written as one row. Columns are named by the flattened
+// dotted keys as reported by VisitKeyValues. If no columns are configured,
+// the column schema is derived from the union of keys of all contexts in a
+// batch, sorted by key. Cells for keys missing in a context are written
+// according to the configured MissingPolicy.
+package csv
+
+import (
+	stdcsv "encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/urso/diag"
+	"github.com/urso/diag/internal/jsonenc"
+	"github.com/urso/diag/internal/textenc"
+)
+
+// Encoder writes diagnostic contexts as rows of a CSV table.
+// An Encoder must not be used concurrently.
+type Encoder struct {
+	// Comma is the field delimiter. ',' is used if Comma is 0. Use '\t' to
+	// write TSV.
+	Comma rune
+
+	// Columns configures a fixed column schema. Fields not listed in Columns
+	// are not written. If Columns is empty, the schema is derived from the
+	// union of keys of all contexts passed to EncodeAll.
+	Columns []string
+
+	// NoHeader disables the header row with the column names.
+	NoHeader bool
+
+	// Missing configures the handling of columns not present in a context.
+	Missing MissingPolicy
+
+	// Placeholder is written for missing columns if Missing is
+	// MissingPlaceholder.
+	Placeholder string
+
+	// TimeFormat configures the layout used to encode timestamps.
+	// time.RFC3339Nano is used if TimeFormat is empty.
+	TimeFormat string
+
+	// Durations configures the encoding of durations.
+	Durations DurationFormat
+
+	w             io.Writer
+	headerWritten bool
+	columns       []string // active column schema
+	row           []string
+	index         map[string]int
+	present       []bool
+}
+
+// MissingPolicy selects how columns that are not present in a context are
+// written.
+type MissingPolicy uint8
+
+const (
+	// MissingEmpty writes an empty cell.
+	MissingEmpty MissingPolicy = iota
+
+	// MissingPlaceholder writes the configured Placeholder.
+	MissingPlaceholder
+
+	// MissingError fails encoding the row.
+	MissingError
+)
+
+// DurationFormat selects the encoding of durations.
+type DurationFormat = jsonenc.DurationFormat
+
+const (
+	// DurationNanos encodes durations as integer nanoseconds.
+	DurationNanos = jsonenc.DurationNanos
+
+	// DurationString encodes durations as string, e.g. 1m30s.
+	DurationString = jsonenc.DurationString
+
+	// DurationSeconds encodes durations as floating point seconds.
+	DurationSeconds = jsonenc.DurationSeconds
+)
+
+// MissingColumnError is returned by the Encoder if a column is not present
+// in a context and Missing is set to MissingError.
+type MissingColumnError struct {
+	Row    int    // index of the context in the batch
+	Column string // name of the missing column
+}
+
+// ErrNoColumns is returned by Encode if no column schema has been
+// configured.
+var ErrNoColumns = errors.New("csv: no columns configured")
+
+// NewEncoder creates a new Encoder writing to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// NewTSVEncoder creates a new Encoder writing tab separated values to w.
+func NewTSVEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w, Comma: '\t'}
+}
+
+// Marshal encodes a batch of contexts as CSV table using the default
+// settings. The header row lists the union of all keys.
+func Marshal(ctxs ...*diag.Context) ([]byte, error) {
+	var e Encoder
+	return e.Append(nil, ctxs...)
+}
+
+func (e *MissingColumnError) Error() string {
+	return fmt.Sprintf("csv: row %v is missing column %q", e.Row, e.Column)
+}
+
+// Columns returns the sorted union of keys of all contexts.
+func Columns(ctxs ...*diag.Context) []string {
+	seen := map[string]bool{}
+	var columns []string
+	for _, ctx := range ctxs {
+		ctx.VisitKeyValues(keyCollector(func(key string) {
+			if !seen[key] {
+				seen[key] = true
+				columns = append(columns, key)
+			}
+		}))
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+// EncodeAll writes the header row, if not written yet, and one row per
+// context. If no columns are configured, the column schema is derived from
+// the union of keys of all contexts, and is kept for subsequent calls.
+// All rows are encoded before writing. Nothing is written if a row can not be
+// encoded.
+func (e *Encoder) EncodeAll(ctxs ...*diag.Context) error {
+	e.selectColumns(ctxs)
+
+	w := stdcsv.NewWriter(e.w)
+	if e.Comma != 0 {
+		w.Comma = e.Comma
+	}
+
+	return e.encodeAll(w, ctxs)
+}
+
+// Encode writes a single row. Encode requires Columns to be configured, or
+// the schema to be derived by a previous call to EncodeAll.
+// The header row is written before the first row.
+func (e *Encoder) Encode(ctx *diag.Context) error {
+	if len(e.Columns) == 0 && len(e.columns) == 0 {
+		return ErrNoColumns
+	}
+	return e.EncodeAll(ctx)
+}
+
+// Append appends the CSV table of the contexts to dst, and returns the
+// extended buffer. The header row is always written, unless NoHeader is set.
+// If no columns are configured, the union of keys of all contexts is used.
+func (e *Encoder) Append(dst []byte, ctxs ...*diag.Context) ([]byte, error) {
+	tmp := *e
+	tmp.headerWritten = false
+	tmp.index = nil
+	tmp.columns = nil
+	tmp.selectColumns(ctxs)
+
+	buf := appendWriter{buf: dst}
+	w := stdcsv.NewWriter(&buf)
+	if e.Comma != 0 {
+		w.Comma = e.Comma
+	}
+	if err := tmp.encodeAll(w, ctxs); err != nil {
+		return dst, err
+	}
+	return buf.buf, nil
+}
+
+// selectColumns configures the column schema for the batch. If no columns are
+// configured, the schema derived from the first batch is used.
+func (e *Encoder) selectColumns(ctxs []*diag.Context) {
+	switch {
+	case len(e.Columns) > 0:
+		e.columns = e.Columns
+	case len(e.columns) == 0:
+		e.columns = Columns(ctxs...)
+	}
+}
+
+// encodeAll encodes all rows before writing the header and the rows to w.
+// The header is marked as written only after w has been flushed successfully.
+func (e *Encoder) encodeAll(w *stdcsv.Writer, ctxs []*diag.Context) error {
+	e.init()
+
+	rows := make([][]string, len(ctxs))
+	for i, ctx := range ctxs {
+		if err := e.encodeRow(i, ctx); err != nil {
+			return err
+		}
+		rows[i] = append([]string(nil), e.row...)
+	}
+
+	writeHeader := !e.headerWritten && !e.NoHeader && len(e.columns) > 0
+	if writeHeader {
+		if err := w.Write(e.columns); err != nil {
+			return err
+		}
+	}
+	if err := w.WriteAll(rows); err != nil {
+		return err
+	}
+
+	if writeHeader {
+		e.headerWritten = true
+	}
+	return nil
+}
+
+func (e *Encoder) init() {
+	if e.hasSchema() {
+		return
+	}
+
+	e.row = make([]string, len(e.columns))
+	e.present = make([]bool, len(e.columns))
+	e.index = make(map[string]int, len(e.columns))
+	for i, col := range e.columns {
+		e.index[col] = i
+	}
+}
+
+func (e *Encoder) hasSchema() bool {
+	if e.index == nil || len(e.index) != len(e.columns) {
+		return false
+	}
+	for i, col := range e.columns {
+		if idx, exists := e.index[col]; !exists || idx != i {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *Encoder) encodeRow(rowIdx int, ctx *diag.Context) error {
+	for i := range e.row {
+		e.row[i] = ""
+		e.present[i] = false
+	}
+
+	if err := ctx.VisitKeyValues(e); err != nil {
+		return err
+	}
+
+	for i, ok := range e.present {
+		if ok {
+			continue
+		}
+
+		switch e.Missing {
+		case MissingPlaceholder:
+			e.row[i] = e.Placeholder
+		case MissingError:
+			return &MissingColumnError{Row: rowIdx, Column: e.columns[i]}
+		}
+	}
+	return nil
+}
+
+// OnObjStart does nothing. Columns are named by the full dotted key.
+func (e *Encoder) OnObjStart(_ string) error { return nil }
+
+// OnObjEnd does nothing.
+func (e *Encoder) OnObjEnd() error { return nil }
+
+// OnValue stores the value in the column named by key. Values for unknown
+// columns are ignored.
+func (e *Encoder) OnValue(key string, v diag.Value) error {
+	idx, exists := e.index[key]
+	if !exists {
+		return nil
+	}
+
+	e.present[idx] = true
+	e.row[idx] = e.formatValue(v)
+	return nil
+}
+
+func (e *Encoder) formatValue(v diag.Value) string {
+	if v.Reporter == nil {
+		return ""
+	}
+
+	switch v.Reporter.Type() {
+	case diag.BoolType:
+		return strconv.FormatBool(v.Primitive != 0)
+	case diag.IntType, diag.Int64Type:
+		return strconv.FormatInt(int64(v.Primitive), 10)
+	case diag.Uint64Type:
+		return strconv.FormatUint(v.Primitive, 10)
+	case diag.Float64Type:
+		return textenc.FormatFloat(math.Float64frombits(v.Primitive))
+	case diag.DurationType:
+		return e.formatDuration(time.Duration(v.Primitive))
+	case diag.StringType:
+		return v.String
+	case diag.TimestampType:
+		if ts, ok := v.Ifc.(time.Time); ok {
+			if e.TimeFormat == "" {
+				return ts.Format(time.RFC3339Nano)
+			}
+			return ts.Format(e.TimeFormat)
+		}
+		return textenc.FormatAny(v.Interface())
+	default:
+		return textenc.FormatAny(v.Interface())
+	}
+}
+
+func (e *Encoder) formatDuration(d time.Duration) string {
+	switch e.Durations {
+	case DurationString:
+		return d.String()
+	case DurationSeconds:
+		return textenc.FormatFloat(d.Seconds())
+	default:
+		return strconv.FormatInt(int64(d), 10)
+	}
+}
+
+type keyCollector func(key string)
+
+func (keyCollector) OnObjStart(_ string) error { return nil }
+func (keyCollector) OnObjEnd() error           { return nil }
+func (fn keyCollector) OnValue(key string, _ diag.Value) error {
+	fn(key)
+	return nil
+}
+
+type appendWriter struct {
+	buf []byte
+}
+
+func (w *appendWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	return len(p), nil
+}
@@ -0,0 +1,200 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+package csv
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/urso/diag"
+	"github.com/urso/diag/internal/testctx"
+)
+
+func TestColumns(t *testing.T) {
+	got := Columns(
+		testctx.New("b", 1, "a.x", 2),
+		testctx.New("c", 3, "a.x", 4),
+		testctx.New(),
+	)
+	want := []string{"a.x", "b", "c"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("missmatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestEncoder(t *testing.T) {
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+
+	cases := map[string]struct {
+		encoder Encoder
+		ctxs    []*diag.Context
+		want    string
+	}{
+		"empty batch": {
+			want: "",
+		},
+		"union of keys": {
+			ctxs: []*diag.Context{
+				testctx.New("host", "a", "status", 200),
+				testctx.New("status", 404, "error.message", "not found"),
+			},
+			want: "" +
+				"error.message,host,status\n" +
+				",a,200\n" +
+				"not found,,404\n",
+		},
+		"fixed columns": {
+			encoder: Encoder{Columns: []string{"status", "host"}},
+			ctxs: []*diag.Context{
+				testctx.New("host", "a", "status", 200, "ignored", true),
+			},
+			want: "status,host\n200,a\n",
+		},
+		"placeholder": {
+			encoder: Encoder{Missing: MissingPlaceholder, Placeholder: "-"},
+			ctxs: []*diag.Context{
+				testctx.New("a", 1),
+				testctx.New("b", 2),
+			},
+			want: "a,b\n1,-\n-,2\n",
+		},
+		"tsv without header": {
+			encoder: Encoder{Comma: '\t', NoHeader: true},
+			ctxs: []*diag.Context{
+				testctx.New("a", "x y", "b", 1.5),
+			},
+			want: "x y\t1.5\n",
+		},
+		"quoting": {
+			ctxs: []*diag.Context{
+				testctx.New("a", "x,y", "b", `say "hi"`, "c", "line\nbreak"),
+			},
+			want: "a,b,c\n\"x,y\",\"say \"\"hi\"\"\",\"line\nbreak\"\n",
+		},
+		"types": {
+			ctxs: []*diag.Context{
+				testctx.New(
+					diag.Bool("b", true),
+					diag.Uint64("u", math.MaxUint64),
+					diag.Float("f", math.Inf(1)),
+					diag.Duration("d", 1500*time.Millisecond),
+					diag.Timestamp("ts", ts),
+					"err", errors.New("oops"),
+					"nil", nil,
+				),
+			},
+			want: "b,d,err,f,nil,ts,u\n" +
+				"true,1500000000,oops,+Inf,,2020-01-02T03:04:05.000000006Z,18446744073709551615\n",
+		},
+		"durations and time format": {
+			encoder: Encoder{Durations: DurationSeconds, TimeFormat: "2006-01-02"},
+			ctxs: []*diag.Context{
+				testctx.New(diag.Duration("d", 1500*time.Millisecond), diag.Timestamp("ts", ts)),
+			},
+			want: "d,ts\n1.5,2020-01-02\n",
+		},
+	}
+
+	for name, test := range cases {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			got, err := test.encoder.Append(nil, test.ctxs...)
+			if err != nil {
+				t.Fatalf("encoding failed: %v", err)
+			}
+			if string(got) != test.want {
+				t.Errorf("got:\n%q\nwant:\n%q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestEncoderMissingError(t *testing.T) {
+	enc := Encoder{Columns: []string{"a", "b"}, Missing: MissingError}
+	_, err := enc.Append(nil, testctx.New("a", 1, "b", 2), testctx.New("a", 1))
+
+	var missing *MissingColumnError
+	if !errors.As(err, &missing) {
+		t.Fatalf("expected MissingColumnError, got %v", err)
+	}
+	if missing.Row != 1 || missing.Column != "b" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestEncodeStream(t *testing.T) {
+	t.Run("requires columns", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := NewEncoder(&buf).Encode(testctx.New("a", 1)); err != ErrNoColumns {
+			t.Fatalf("expected ErrNoColumns, got %v", err)
+		}
+	})
+
+	t.Run("failed rows do not drop the header", func(t *testing.T) {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.Columns = []string{"a", "b"}
+		enc.Missing = MissingError
+
+		err := enc.EncodeAll(testctx.New("a", 1, "b", 2), testctx.New("a", 1))
+		if _, ok := err.(*MissingColumnError); !ok {
+			t.Fatalf("expected MissingColumnError, got %v", err)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("expected no output, got %q", buf.String())
+		}
+
+		if err := enc.EncodeAll(testctx.New("a", 3, "b", 4)); err != nil {
+			t.Fatal(err)
+		}
+		if want := "a,b\n3,4\n"; buf.String() != want {
+			t.Errorf("got %q, want %q", buf.String(), want)
+		}
+	})
+
+	t.Run("header is written once", func(t *testing.T) {
+		var buf bytes.Buffer
+		enc := NewTSVEncoder(&buf)
+		enc.Columns = []string{"a", "b"}
+		for i := 0; i < 2; i++ {
+			if err := enc.Encode(testctx.New("a", i, "b", "x")); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		want := "a\tb\n0\tx\n1\tx\n"
+		if buf.String() != want {
+			t.Errorf("got %q, want %q", buf.String(), want)
+		}
+	})
+
+	t.Run("schema is kept between batches", func(t *testing.T) {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		if err := enc.EncodeAll(testctx.New("a", 1)); err != nil {
+			t.Fatal(err)
+		}
+		if err := enc.EncodeAll(testctx.New("a", 2, "b", 3)); err != nil {
+			t.Fatal(err)
+		}
+
+		want := "a\n1\n2\n"
+		if buf.String() != want {
+			t.Errorf("got %q, want %q", buf.String(), want)
+		}
+		if enc.Columns != nil {
+			t.Errorf("configured columns must not be modified, got %v", enc.Columns)
+		}
+		if err := enc.Encode(testctx.New("a", 4)); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,340 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+// Package gelf encodes diagnostic contexts as GELF 1.1 messages, as accepted
+// by Graylog.
+//
+// A Message is encoded as JSON object with the GELF header fields version,
+// host, short_message, full_message, timestamp, and level. All fields of the
+// message context are written as additional fields, prefixed with '_'.
+// Dots in field names are replaced by the configured separator, e.g.
+// `http.request.method` becomes `_http_request_method`.
+//
+// GELF only supports strings and numbers as additional field values. Booleans,
+// timestamps, and other values are encoded as strings. Fields with nil values
+// are not written.
+//
+// UDPWriter implements chunked GELF over UDP, splitting large messages into
+// multiple datagrams.
+package gelf
+
+import (
+	stdjson "encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/urso/diag"
+	"github.com/urso/diag/encoding/syslog"
+	"github.com/urso/diag/internal/jsonenc"
+)
+
+// Encoder writes messages as GELF JSON documents.
+// An Encoder must not be used concurrently.
+type Encoder struct {
+	// Separator replaces the dots in field names of additional fields.
+	// "_" is used if Separator is empty.
+	Separator string
+
+	// Durations configures the encoding of durations.
+	Durations DurationFormat
+
+	// NullDelimited terminates each message written by Encode with a null
+	// byte, as required for GELF over TCP.
+	NullDelimited bool
+
+	w       io.Writer
+	enc     jsonenc.Writer
+	nameBuf []byte
+}
+
+// DurationFormat selects the encoding of durations.
+type DurationFormat = jsonenc.DurationFormat
+
+const (
+	// DurationNanos encodes durations as integer nanoseconds.
+	DurationNanos = jsonenc.DurationNanos
+
+	// DurationString encodes durations as string, e.g. "1m30s".
+	DurationString = jsonenc.DurationString
+
+	// DurationSeconds encodes durations as floating point seconds.
+	DurationSeconds = jsonenc.DurationSeconds
+)
+
+// Message is a GELF message. Host and ShortMessage are required.
+type Message struct {
+	Host         string
+	ShortMessage string
+	FullMessage  string
+
+	// Timestamp of the message. The timestamp is not written if zero, such
+	// that the receiver uses the time it received the message.
+	Timestamp time.Time
+
+	// Level is the syslog severity of the message. Level is only written if
+	// HasLevel is set.
+	Level    syslog.Severity
+	HasLevel bool
+
+	// Context is written as additional fields.
+	Context *diag.Context
+}
+
+const gelfVersion = "1.1"
+
+var (
+	errMissingHost    = errors.New("gelf: host is required")
+	errMissingMessage = errors.New("gelf: short message is required")
+)
+
+// NewEncoder creates a new Encoder writing to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Marshal encodes the message as GELF JSON document, using the default
+// settings.
+func Marshal(msg *Message) ([]byte, error) {
+	var e Encoder
+	return e.Append(nil, msg)
+}
+
+// Encode writes the message to the writer configured with NewEncoder, using
+// one call to Write per message.
+func (e *Encoder) Encode(msg *Message) error {
+	e.enc.Reset()
+	if err := e.encode(msg); err != nil {
+		return err
+	}
+
+	if e.NullDelimited {
+		e.enc.Buf = append(e.enc.Buf, 0)
+	}
+	_, err := e.w.Write(e.enc.Buf)
+	return err
+}
+
+// Append appends the GELF JSON document to dst and returns the extended
+// buffer.
+func (e *Encoder) Append(dst []byte, msg *Message) ([]byte, error) {
+	scratch := e.enc.Buf
+	e.enc = jsonenc.Writer{Buf: dst}
+	err := e.encode(msg)
+	dst = e.enc.Buf
+	e.enc = jsonenc.Writer{Buf: scratch[:0]}
+	return dst, err
+}
+
+func (e *Encoder) encode(msg *Message) error {
+	if msg.Host == "" {
+		return errMissingHost
+	}
+	if msg.ShortMessage == "" {
+		return errMissingMessage
+	}
+
+	e.enc.BeginObject()
+	e.enc.Key("version")
+	e.enc.String(gelfVersion)
+	e.enc.Key("host")
+	e.enc.String(msg.Host)
+	e.enc.Key("short_message")
+	e.enc.String(msg.ShortMessage)
+	if msg.FullMessage != "" {
+		e.enc.Key("full_message")
+		e.enc.String(msg.FullMessage)
+	}
+	if !msg.Timestamp.IsZero() {
+		e.enc.Key("timestamp")
+		e.enc.Raw(appendTimestamp(nil, msg.Timestamp))
+	}
+	if msg.HasLevel {
+		e.enc.Key("level")
+		e.enc.Int(int64(msg.Level))
+	}
+
+	if msg.Context != nil {
+		if err := msg.Context.VisitKeyValues(e); err != nil {
+			return err
+		}
+	}
+
+	e.enc.EndObject()
+	return nil
+}
+
+// OnObjStart does nothing. Additional fields are flat, and OnValue joins
+// nested keys using Separator.
+func (e *Encoder) OnObjStart(_ string) error { return nil }
+
+// OnObjEnd does nothing.
+func (e *Encoder) OnObjEnd() error { return nil }
+
+// OnValue writes an additional field.
+func (e *Encoder) OnValue(key string, v diag.Value) error {
+	if v.Reporter == nil {
+		return nil
+	}
+
+	switch v.Reporter.Type() {
+	case diag.BoolType:
+		e.key(key)
+		e.enc.String(strconv.FormatBool(v.Primitive != 0))
+	case diag.IntType, diag.Int64Type:
+		e.key(key)
+		e.enc.Int(int64(v.Primitive))
+	case diag.Uint64Type:
+		e.key(key)
+		e.enc.Uint(v.Primitive)
+	case diag.Float64Type:
+		e.key(key)
+		e.encodeFloat(math.Float64frombits(v.Primitive))
+	case diag.DurationType:
+		e.key(key)
+		e.encodeDuration(time.Duration(v.Primitive))
+	case diag.StringType:
+		e.key(key)
+		e.enc.String(v.String)
+	case diag.TimestampType:
+		if ts, ok := v.Ifc.(time.Time); ok {
+			e.key(key)
+			e.enc.String(ts.Format(time.RFC3339Nano))
+			return nil
+		}
+		return e.encodeAny(key, v.Interface())
+	default:
+		return e.encodeAny(key, v.Interface())
+	}
+	return nil
+}
+
+// key writes the name of an additional field. Dots are replaced by the
+// separator, escaped dots are kept as is, and characters not allowed by GELF
+// are replaced with '_'. The reserved field `_id` is written as `__id`.
+func (e *Encoder) key(key string) {
+	sep := e.Separator
+	if sep == "" {
+		sep = "_"
+	}
+
+	b := append(e.nameBuf[:0], '_')
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if c == '\\' && i+1 < len(key) {
+			// escaped dots are kept as is
+			i++
+			if c = key[i]; c == '.' {
+				b = append(b, c)
+				continue
+			}
+		}
+
+		switch {
+		case c == '.':
+			b = append(b, sep...)
+		case c == '-' || c == '_' ||
+			('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9'):
+			b = append(b, c)
+		default:
+			b = append(b, '_')
+		}
+	}
+	if string(b) == "_id" {
+		b = append(b[:0], "__id"...)
+	}
+
+	e.nameBuf = b
+	e.enc.Key(string(b))
+}
+
+func (e *Encoder) encodeFloat(f float64) {
+	switch {
+	case math.IsNaN(f):
+		e.enc.String("NaN")
+	case math.IsInf(f, 1):
+		e.enc.String("+Inf")
+	case math.IsInf(f, -1):
+		e.enc.String("-Inf")
+	default:
+		e.enc.Float(f)
+	}
+}
+
+func (e *Encoder) encodeDuration(d time.Duration) {
+	switch e.Durations {
+	case DurationString:
+		e.enc.String(d.String())
+	case DurationSeconds:
+		e.encodeFloat(d.Seconds())
+	default:
+		e.enc.Int(int64(d))
+	}
+}
+
+// encodeAny encodes values of unknown type as string. Errors not implementing
+// json.Marshaler are encoded as error message, numbers and strings as is, and
+// all other values using their JSON encoding.
+func (e *Encoder) encodeAny(key string, v interface{}) error {
+	switch val := v.(type) {
+	case nil:
+		return nil
+	case stdjson.Marshaler:
+	case error:
+		e.key(key)
+		e.enc.String(val.Error())
+		return nil
+	}
+
+	b, err := stdjson.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("gelf: failed to encode field %q: %v", key, err)
+	}
+
+	if string(b) == "null" {
+		return nil
+	}
+
+	e.key(key)
+	switch {
+	case b[0] == '"' || b[0] == '-' || ('0' <= b[0] && b[0] <= '9'):
+		e.enc.Raw(b)
+	default:
+		e.enc.String(string(b))
+	}
+	return nil
+}
+
+// appendTimestamp writes the timestamp as seconds since the epoch, with
+// microsecond precision.
+func appendTimestamp(b []byte, ts time.Time) []byte {
+	usec := ts.UnixNano() / int64(time.Microsecond)
+	if usec < 0 {
+		return strconv.AppendFloat(b, float64(usec)/1e6, 'f', -1, 64)
+	}
+
+	sec, frac := usec/1e6, usec%1e6
+
+	b = strconv.AppendInt(b, sec, 10)
+	if frac == 0 {
+		return b
+	}
+
+	var tmp [7]byte
+	tmp[0] = '.'
+	for i := 6; i > 0; i-- {
+		tmp[i] = byte('0' + frac%10)
+		frac /= 10
+	}
+	end := len(tmp)
+	for tmp[end-1] == '0' {
+		end--
+	}
+	return append(b, tmp[:end]...)
+}
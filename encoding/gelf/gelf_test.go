@@ -0,0 +1,138 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+package gelf
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/urso/diag"
+	"github.com/urso/diag/encoding/syslog"
+	"github.com/urso/diag/internal/testctx"
+)
+
+func TestEncoder(t *testing.T) {
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 6000, time.UTC)
+
+	cases := map[string]struct {
+		encoder Encoder
+		msg     Message
+		want    string
+	}{
+		"minimal": {
+			msg:  Message{Host: "example.org", ShortMessage: "hello"},
+			want: `{"version":"1.1","host":"example.org","short_message":"hello"}`,
+		},
+		"header fields": {
+			msg: Message{
+				Host:         "example.org",
+				ShortMessage: "hello",
+				FullMessage:  "hello\nworld",
+				Timestamp:    ts,
+				Level:        syslog.SeverityWarning,
+				HasLevel:     true,
+			},
+			want: `{"version":"1.1","host":"example.org","short_message":"hello","full_message":"hello\nworld",` +
+				`"timestamp":1577934245.000006,"level":4}`,
+		},
+		"whole second timestamp": {
+			msg:  Message{Host: "h", ShortMessage: "m", Timestamp: time.Unix(1500000000, 0)},
+			want: `{"version":"1.1","host":"h","short_message":"m","timestamp":1500000000}`,
+		},
+		"additional fields": {
+			msg: Message{
+				Host:         "h",
+				ShortMessage: "m",
+				Context: testctx.New(
+					diag.String("http.request.method", "GET"),
+					diag.Int("http.response.status", 200),
+					diag.Bool("ok", true),
+					diag.Uint64("u", math.MaxUint64),
+					diag.Float("f", 1.5),
+				),
+			},
+			want: `{"version":"1.1","host":"h","short_message":"m",` +
+				`"_f":1.5,"_http_request_method":"GET","_http_response_status":200,"_ok":"true","_u":18446744073709551615}`,
+		},
+		"escaped dots": {
+			msg:  Message{Host: "h", ShortMessage: "m", Context: testctx.New(diag.Int(`hosts.db\.example\.com`, 1))},
+			want: `{"version":"1.1","host":"h","short_message":"m","_hosts_db.example.com":1}`,
+		},
+		"custom separator": {
+			encoder: Encoder{Separator: "."},
+			msg:     Message{Host: "h", ShortMessage: "m", Context: testctx.New(diag.Int("a.b", 1))},
+			want:    `{"version":"1.1","host":"h","short_message":"m","_a.b":1}`,
+		},
+		"field name sanitization": {
+			msg:  Message{Host: "h", ShortMessage: "m", Context: testctx.New("a b", 1, "id", 2, "@x-y", 3)},
+			want: `{"version":"1.1","host":"h","short_message":"m","__x-y":3,"_a_b":1,"__id":2}`,
+		},
+		"value types": {
+			msg: Message{
+				Host:         "h",
+				ShortMessage: "m",
+				Context: testctx.New(
+					diag.Duration("d", time.Second),
+					diag.Float("nan", math.NaN()),
+					diag.Timestamp("ts", ts),
+					"err", errors.New("oops"),
+					"list", []int{1, 2},
+					"n", 3,
+					"nil", nil,
+				),
+			},
+			want: `{"version":"1.1","host":"h","short_message":"m",` +
+				`"_d":1000000000,"_err":"oops","_list":"[1,2]","_n":3,"_nan":"NaN","_ts":"2020-01-02T03:04:05.000006Z"}`,
+		},
+		"durations as string": {
+			encoder: Encoder{Durations: DurationString},
+			msg:     Message{Host: "h", ShortMessage: "m", Context: testctx.New(diag.Duration("d", time.Second))},
+			want:    `{"version":"1.1","host":"h","short_message":"m","_d":"1s"}`,
+		},
+	}
+
+	for name, test := range cases {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			got, err := test.encoder.Append(nil, &test.msg)
+			if err != nil {
+				t.Fatalf("encoding failed: %v", err)
+			}
+			if string(got) != test.want {
+				t.Errorf("got  %s\nwant %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestEncoderRequiredFields(t *testing.T) {
+	if _, err := Marshal(&Message{ShortMessage: "m"}); err == nil {
+		t.Error("expected error for missing host")
+	}
+	if _, err := Marshal(&Message{Host: "h"}); err == nil {
+		t.Error("expected error for missing short message")
+	}
+}
+
+func TestEncodeNullDelimited(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.NullDelimited = true
+	for i := 0; i < 2; i++ {
+		if err := enc.Encode(&Message{Host: "h", ShortMessage: "m"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	msg := `{"version":"1.1","host":"h","short_message":"m"}` + "\x00"
+	if buf.String() != msg+msg {
+		t.Errorf("got %q", buf.String())
+	}
+}
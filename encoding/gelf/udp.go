@@ -0,0 +1,113 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+package gelf
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// UDPWriter sends GELF messages as UDP datagrams. Each call to Write sends
+// one message. Messages larger than ChunkSize are split into up to 128
+// chunks, using the GELF chunking protocol.
+//
+// Use UDPWriter with NewEncoder to send contexts to Graylog:
+//
+//	w, err := gelf.DialUDP("graylog:12201")
+//	...
+//	enc := gelf.NewEncoder(w)
+//	err = enc.Encode(&gelf.Message{Host: host, ShortMessage: msg, Context: ctx})
+type UDPWriter struct {
+	// ChunkSize is the maximum size of a datagram, including the chunk header.
+	// DefaultChunkSize is used if ChunkSize is 0.
+	ChunkSize int
+
+	conn net.Conn
+	buf  []byte
+}
+
+// DefaultChunkSize is the default maximum datagram size. It fits into the
+// MTU of most networks.
+const DefaultChunkSize = 1420
+
+const (
+	chunkMagic0    = 0x1e
+	chunkMagic1    = 0x0f
+	chunkHeaderLen = 12
+	maxChunks      = 128
+)
+
+// ErrMessageTooLarge is returned by UDPWriter if a message requires more than
+// 128 chunks.
+var ErrMessageTooLarge = errors.New("gelf: message too large")
+
+// DialUDP connects to the GELF UDP input at addr.
+func DialUDP(addr string) (*UDPWriter, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewUDPWriter(conn), nil
+}
+
+// NewUDPWriter creates a new UDPWriter sending messages via conn.
+func NewUDPWriter(conn net.Conn) *UDPWriter {
+	return &UDPWriter{conn: conn}
+}
+
+// Write sends p as a single GELF message.
+func (w *UDPWriter) Write(p []byte) (int, error) {
+	chunkSize := w.ChunkSize
+	if chunkSize == 0 {
+		chunkSize = DefaultChunkSize
+	}
+	if chunkSize <= chunkHeaderLen {
+		return 0, fmt.Errorf("gelf: chunk size %v too small", chunkSize)
+	}
+
+	if len(p) <= chunkSize {
+		if _, err := w.conn.Write(p); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	payloadSize := chunkSize - chunkHeaderLen
+	count := (len(p) + payloadSize - 1) / payloadSize
+	if count > maxChunks {
+		return 0, ErrMessageTooLarge
+	}
+
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return 0, err
+	}
+
+	for seq := 0; seq < count; seq++ {
+		start := seq * payloadSize
+		end := start + payloadSize
+		if end > len(p) {
+			end = len(p)
+		}
+
+		w.buf = append(w.buf[:0], chunkMagic0, chunkMagic1)
+		w.buf = append(w.buf, id[:]...)
+		w.buf = append(w.buf, byte(seq), byte(count))
+		w.buf = append(w.buf, p[start:end]...)
+		if _, err := w.conn.Write(w.buf); err != nil {
+			return start, err
+		}
+	}
+	return len(p), nil
+}
+
+// Close closes the underlying connection.
+func (w *UDPWriter) Close() error {
+	return w.conn.Close()
+}
@@ -0,0 +1,161 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+package gelf
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/urso/diag"
+	"github.com/urso/diag/internal/testctx"
+)
+
+func TestUDPWriter(t *testing.T) {
+	cases := map[string]struct {
+		chunkSize int
+		msgLen    int
+		chunks    int
+	}{
+		"single datagram":  {chunkSize: 0, msgLen: 100, chunks: 1},
+		"exact chunk size": {chunkSize: 100, msgLen: 100, chunks: 1},
+		"chunked":          {chunkSize: 100, msgLen: 1000, chunks: 12},
+	}
+
+	for name, test := range cases {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			listener, w := newUDPTest(t)
+			defer listener.Close()
+			defer w.Close()
+			w.ChunkSize = test.chunkSize
+
+			msg := bytes.Repeat([]byte("x"), test.msgLen)
+			n, err := w.Write(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != len(msg) {
+				t.Errorf("expected %v bytes written, got %v", len(msg), n)
+			}
+
+			datagrams := readDatagrams(t, listener, test.chunks)
+			got := reassemble(t, datagrams)
+			if !bytes.Equal(msg, got) {
+				t.Errorf("reassembled message does not match")
+			}
+		})
+	}
+}
+
+func TestUDPWriterTooLarge(t *testing.T) {
+	listener, w := newUDPTest(t)
+	defer listener.Close()
+	defer w.Close()
+
+	w.ChunkSize = 20
+	if _, err := w.Write(make([]byte, 8*maxChunks+1)); err != ErrMessageTooLarge {
+		t.Fatalf("expected ErrMessageTooLarge, got %v", err)
+	}
+}
+
+func TestUDPEncode(t *testing.T) {
+	listener, w := newUDPTest(t)
+	defer listener.Close()
+	defer w.Close()
+	w.ChunkSize = 64
+
+	msg := &Message{
+		Host:         "h",
+		ShortMessage: strings.Repeat("long message ", 10),
+		Context:      testctx.New(diag.String("a.b", "c")),
+	}
+	want, err := Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := NewEncoder(w).Encode(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	chunks := (len(want) + 64 - chunkHeaderLen - 1) / (64 - chunkHeaderLen)
+	got := reassemble(t, readDatagrams(t, listener, chunks))
+	if !bytes.Equal(want, got) {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func newUDPTest(t *testing.T) (net.PacketConn, *UDPWriter) {
+	t.Helper()
+
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("can not listen on UDP: %v", err)
+	}
+
+	w, err := DialUDP(listener.LocalAddr().String())
+	if err != nil {
+		listener.Close()
+		t.Fatal(err)
+	}
+	return listener, w
+}
+
+func readDatagrams(t *testing.T, conn net.PacketConn, n int) [][]byte {
+	t.Helper()
+
+	var datagrams [][]byte
+	buf := make([]byte, 65536)
+	for len(datagrams) < n {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		sz, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("failed to read datagram %v of %v: %v", len(datagrams)+1, n, err)
+		}
+		datagrams = append(datagrams, append([]byte(nil), buf[:sz]...))
+	}
+	return datagrams
+}
+
+// reassemble joins the GELF chunks in datagrams.
+func reassemble(t *testing.T, datagrams [][]byte) []byte {
+	t.Helper()
+
+	if len(datagrams) == 1 && !isChunk(datagrams[0]) {
+		return datagrams[0]
+	}
+
+	var id []byte
+	parts := make([][]byte, len(datagrams))
+	for _, d := range datagrams {
+		if !isChunk(d) {
+			t.Fatalf("expected chunk, got %q", d)
+		}
+		if id == nil {
+			id = d[2:10]
+		} else if !bytes.Equal(id, d[2:10]) {
+			t.Fatalf("message ID mismatch")
+		}
+
+		seq, count := int(d[10]), int(d[11])
+		if count != len(datagrams) {
+			t.Fatalf("expected chunk count %v, got %v", len(datagrams), count)
+		}
+		if parts[seq] != nil {
+			t.Fatalf("duplicate chunk %v", seq)
+		}
+		parts[seq] = d[chunkHeaderLen:]
+	}
+	return bytes.Join(parts, nil)
+}
+
+func isChunk(d []byte) bool {
+	return len(d) >= chunkHeaderLen && d[0] == chunkMagic0 && d[1] == chunkMagic1
+}
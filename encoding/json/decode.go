@@ -0,0 +1,205 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+package json
+
+import (
+	"bytes"
+	stdjson "encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urso/diag"
+)
+
+// Decoder reads JSON objects from an input stream and adds the fields to
+// diagnostic contexts. Nested objects are flattened into dotted keys.
+// Member names are escaped with diag.EscapeKey, such that names containing
+// dots are reported as a single name by VisitStructured. Set Flat to decode
+// documents written with dotted keys into nested objects.
+//
+// Numbers are decoded as int64 if possible, as uint64 if too large for int64,
+// and as float64 otherwise. Arrays are decoded as []interface{} using the
+// same rules for numbers.
+type Decoder struct {
+	// ParseTimestamps configures the decoder to decode strings in RFC3339
+	// format as timestamps.
+	ParseTimestamps bool
+
+	// Standardized holds the keys of fields to be marked as standardized.
+	// Keys of nested fields use the dotted notation.
+	Standardized map[string]bool
+
+	// Flat configures the decoder to split the member names of the top-level
+	// object at dots, as written by an Encoder with Flat set. Member names of
+	// nested objects are always escaped.
+	Flat bool
+
+	dec *stdjson.Decoder
+}
+
+var errNoObject = errors.New("json: input is not an object")
+
+// NewDecoder creates a new Decoder reading from r.
+func NewDecoder(r io.Reader) *Decoder {
+	dec := stdjson.NewDecoder(r)
+	dec.UseNumber()
+	return &Decoder{dec: dec}
+}
+
+// Unmarshal decodes the JSON object in data and adds its fields to ctx,
+// using the default Decoder settings.
+func Unmarshal(data []byte, ctx *diag.Context) error {
+	return NewDecoder(bytes.NewReader(data)).Decode(ctx)
+}
+
+// Decode reads the next JSON object from the input and adds its fields to
+// ctx. Decode returns io.EOF if the input is exhausted.
+func (d *Decoder) Decode(ctx *diag.Context) error {
+	tok, err := d.dec.Token()
+	if err != nil {
+		return err
+	}
+	if delim, ok := tok.(stdjson.Delim); !ok || delim != '{' {
+		return errNoObject
+	}
+	return d.decodeObject(ctx, "")
+}
+
+// decodeObject reads the fields of an object, after the opening '{' has been
+// read.
+func (d *Decoder) decodeObject(ctx *diag.Context, prefix string) error {
+	for d.dec.More() {
+		tok, err := d.dec.Token()
+		if err != nil {
+			return err
+		}
+		key := tok.(string)
+		if prefix != "" || !d.Flat {
+			key = prefix + diag.EscapeKey(key)
+		}
+
+		tok, err = d.dec.Token()
+		if err != nil {
+			return err
+		}
+
+		var val diag.Value
+		switch v := tok.(type) {
+		case stdjson.Delim:
+			if v == '{' {
+				if err := d.decodeObject(ctx, key+"."); err != nil {
+					return err
+				}
+				continue
+			}
+
+			arr, err := d.decodeArray()
+			if err != nil {
+				return err
+			}
+			val = diag.ValAny(arr)
+		default:
+			val = d.value(v)
+		}
+
+		ctx.AddField(diag.Field{Key: key, Value: val, Standardized: d.Standardized[key]})
+	}
+
+	_, err := d.dec.Token() // consume '}'
+	return err
+}
+
+// decodeArray reads the elements of an array, after the opening '[' has been
+// read.
+func (d *Decoder) decodeArray() ([]interface{}, error) {
+	arr := []interface{}{}
+	for d.dec.More() {
+		var elem interface{}
+		if err := d.dec.Decode(&elem); err != nil {
+			return nil, err
+		}
+		arr = append(arr, normalize(elem))
+	}
+
+	if _, err := d.dec.Token(); err != nil { // consume ']'
+		return nil, err
+	}
+	return arr, nil
+}
+
+func (d *Decoder) value(v interface{}) diag.Value {
+	switch x := v.(type) {
+	case bool:
+		return diag.ValBool(x)
+	case stdjson.Number:
+		switch n := number(x).(type) {
+		case int64:
+			return diag.ValInt64(n)
+		case uint64:
+			return diag.ValUint64(n)
+		case float64:
+			return diag.ValFloat(n)
+		default:
+			return diag.ValString(x.String())
+		}
+	case string:
+		if d.ParseTimestamps {
+			if ts, ok := parseTimestamp(x); ok {
+				return diag.ValTime(ts)
+			}
+		}
+		return diag.ValString(x)
+	default:
+		return diag.ValAny(nil)
+	}
+}
+
+// normalize converts the numbers in values decoded by encoding/json into
+// int64, uint64, or float64.
+func normalize(v interface{}) interface{} {
+	switch x := v.(type) {
+	case stdjson.Number:
+		return number(x)
+	case []interface{}:
+		for i := range x {
+			x[i] = normalize(x[i])
+		}
+	case map[string]interface{}:
+		for k := range x {
+			x[k] = normalize(x[k])
+		}
+	}
+	return v
+}
+
+func number(n stdjson.Number) interface{} {
+	s := string(n)
+	if !strings.ContainsAny(s, ".eE") {
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return i
+		}
+		if u, err := strconv.ParseUint(s, 10, 64); err == nil {
+			return u
+		}
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f
+	}
+	return s
+}
+
+func parseTimestamp(s string) (time.Time, bool) {
+	// quick check for the date prefix 'YYYY-MM-DDT'
+	if len(s) < 20 || s[4] != '-' || s[7] != '-' || (s[10] != 'T' && s[10] != 't') {
+		return time.Time{}, false
+	}
+	ts, err := time.Parse(time.RFC3339Nano, s)
+	return ts, err == nil
+}
@@ -0,0 +1,196 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+package json
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/urso/diag"
+)
+
+type decodedField struct {
+	Value        interface{}
+	Type         diag.Type
+	Standardized bool
+}
+
+type fieldCollector map[string]decodedField
+
+func (c fieldCollector) OnObjStart(_ string) error { return nil }
+func (c fieldCollector) OnObjEnd() error           { return nil }
+func (c fieldCollector) OnValue(key string, v diag.Value) error {
+	c[key] = decodedField{Value: v.Interface(), Type: v.Reporter.Type()}
+	return nil
+}
+
+func collectFields(ctx *diag.Context) fieldCollector {
+	fields, std := fieldCollector{}, fieldCollector{}
+	ctx.VisitKeyValues(fields)
+	ctx.Standardized().VisitKeyValues(std)
+	for key := range std {
+		f := fields[key]
+		f.Standardized = true
+		fields[key] = f
+	}
+	return fields
+}
+
+func TestUnmarshal(t *testing.T) {
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+
+	cases := map[string]struct {
+		decoder Decoder
+		input   string
+		want    fieldCollector
+	}{
+		"empty": {
+			input: `{}`,
+			want:  fieldCollector{},
+		},
+		"primitives": {
+			input: `{"b": true, "i": -3, "big": 18446744073709551615, "f": 1.5, "e": 1e3, "s": "x", "n": null}`,
+			want: fieldCollector{
+				"b":   {Value: true, Type: diag.BoolType},
+				"i":   {Value: int64(-3), Type: diag.Int64Type},
+				"big": {Value: uint64(18446744073709551615), Type: diag.Uint64Type},
+				"f":   {Value: 1.5, Type: diag.Float64Type},
+				"e":   {Value: 1000.0, Type: diag.Float64Type},
+				"s":   {Value: "x", Type: diag.StringType},
+				"n":   {Value: nil, Type: diag.IfcType},
+			},
+		},
+		"nested objects are flattened": {
+			input: `{"http": {"request": {"method": "GET"}, "status": 200}}`,
+			want: fieldCollector{
+				"http.request.method": {Value: "GET", Type: diag.StringType},
+				"http.status":         {Value: int64(200), Type: diag.Int64Type},
+			},
+		},
+		"dotted member names": {
+			input: `{"hosts": {"db.example.com": 1}, "a.b": 2}`,
+			want: fieldCollector{
+				`hosts.db\.example\.com`: {Value: int64(1), Type: diag.Int64Type},
+				`a\.b`:                   {Value: int64(2), Type: diag.Int64Type},
+			},
+		},
+		"flat top-level names": {
+			decoder: Decoder{Flat: true},
+			input:   `{"hosts": {"db.example.com": 1}, "a.b": 2}`,
+			want: fieldCollector{
+				`hosts.db\.example\.com`: {Value: int64(1), Type: diag.Int64Type},
+				"a.b":                    {Value: int64(2), Type: diag.Int64Type},
+			},
+		},
+		"arrays": {
+			input: `{"list": [1, 2.5, "a", {"b": 3}]}`,
+			want: fieldCollector{
+				"list": {Value: []interface{}{int64(1), 2.5, "a", map[string]interface{}{"b": int64(3)}}, Type: diag.IfcType},
+			},
+		},
+		"timestamps are strings by default": {
+			input: `{"ts": "2020-01-02T03:04:05.000000006Z"}`,
+			want:  fieldCollector{"ts": {Value: "2020-01-02T03:04:05.000000006Z", Type: diag.StringType}},
+		},
+		"parse timestamps": {
+			decoder: Decoder{ParseTimestamps: true},
+			input:   `{"ts": "2020-01-02T03:04:05.000000006Z", "other": "2020-01-02 03:04"}`,
+			want: fieldCollector{
+				"ts":    {Value: ts, Type: diag.TimestampType},
+				"other": {Value: "2020-01-02 03:04", Type: diag.StringType},
+			},
+		},
+		"standardized keys": {
+			decoder: Decoder{Standardized: map[string]bool{"host.name": true}},
+			input:   `{"host": {"name": "localhost"}, "user": "x"}`,
+			want: fieldCollector{
+				"host.name": {Value: "localhost", Type: diag.StringType, Standardized: true},
+				"user":      {Value: "x", Type: diag.StringType},
+			},
+		},
+	}
+
+	for name, test := range cases {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			dec := NewDecoder(strings.NewReader(test.input))
+			dec.ParseTimestamps = test.decoder.ParseTimestamps
+			dec.Standardized = test.decoder.Standardized
+			dec.Flat = test.decoder.Flat
+
+			ctx := diag.NewContext(nil, nil)
+			if err := dec.Decode(ctx); err != nil {
+				t.Fatalf("decoding failed: %v", err)
+			}
+			if diff := cmp.Diff(test.want, collectFields(ctx)); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	for _, input := range []string{`[]`, `1`, `{"a": }`, `{"a": 1`, ``} {
+		if err := Unmarshal([]byte(input), diag.NewContext(nil, nil)); err == nil {
+			t.Errorf("Unmarshal(%q) succeeded, want error", input)
+		}
+	}
+}
+
+func TestDecoderStream(t *testing.T) {
+	dec := NewDecoder(strings.NewReader(`{"a": 1} {"a": 2}`))
+
+	var got []interface{}
+	for {
+		ctx := diag.NewContext(nil, nil)
+		err := dec.Decode(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, collectFields(ctx)["a"].Value)
+	}
+
+	if diff := cmp.Diff([]interface{}{int64(1), int64(2)}, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestRoundtrip(t *testing.T) {
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+	ctx := diag.NewContext(nil, nil)
+	ctx.AddFields(
+		diag.String("http.method", "GET"),
+		diag.Int64("http.status", 200),
+		diag.Float("load", 0.5),
+		diag.Bool("ok", true),
+		diag.Timestamp("ts", ts),
+	)
+	ctx.AddPath([]string{"hosts", "db.example.com"}, diag.ValInt64(1))
+	ctx.AddPath([]string{"db.example.com"}, diag.ValInt64(2))
+
+	b, err := Marshal(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded := diag.NewContext(nil, nil)
+	dec := NewDecoder(strings.NewReader(string(b)))
+	dec.ParseTimestamps = true
+	if err := dec.Decode(decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(collectFields(ctx), collectFields(decoded)); diff != "" {
+		t.Errorf("roundtrip mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,153 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+// Package json encodes diagnostic contexts as JSON objects, and decodes JSON
+// objects into diagnostic contexts.
+//
+// The Encoder implements diag.Visitor and writes the fields reported by
+// a context directly to its output buffer, without building intermediate
+// maps. Values are encoded based on their diag.Type. Timestamps are encoded
+// as RFC3339 strings with nanosecond precision. The encoding of durations
+// and of non-finite floating point numbers can be configured.
+//
+// The Decoder flattens nested objects into dotted keys, such that decoded
+// contexts produce the same JSON document when encoded again.
+package json
+
+import (
+	"io"
+
+	"github.com/urso/diag"
+	"github.com/urso/diag/internal/jsonenc"
+)
+
+// Encoder writes diagnostic contexts as JSON objects.
+// An Encoder must not be used concurrently.
+type Encoder struct {
+	// Flat configures the encoder to write all fields into one object using
+	// dotted keys. By default fields with dotted keys are combined into nested
+	// objects.
+	Flat bool
+
+	// Durations configures the encoding of durations.
+	Durations DurationFormat
+
+	// NonFinite configures the encoding of NaN and infinite floating point
+	// numbers, which can not be represented in JSON.
+	NonFinite NonFinitePolicy
+
+	w   io.Writer
+	enc jsonenc.Encoder
+}
+
+// DurationFormat selects the encoding of durations.
+type DurationFormat = jsonenc.DurationFormat
+
+const (
+	// DurationNanos encodes durations as integer nanoseconds.
+	DurationNanos = jsonenc.DurationNanos
+
+	// DurationString encodes durations as string, e.g. "1m30s".
+	DurationString = jsonenc.DurationString
+
+	// DurationSeconds encodes durations as floating point seconds.
+	DurationSeconds = jsonenc.DurationSeconds
+)
+
+// NonFinitePolicy selects the encoding of NaN and infinite floating point
+// numbers.
+type NonFinitePolicy = jsonenc.NonFinitePolicy
+
+const (
+	// NonFiniteNull encodes NaN and infinite values as null.
+	NonFiniteNull = jsonenc.NonFiniteNull
+
+	// NonFiniteString encodes NaN and infinite values as the strings "NaN",
+	// "+Inf", and "-Inf".
+	NonFiniteString = jsonenc.NonFiniteString
+
+	// NonFiniteError fails encoding if a NaN or infinite value is found.
+	NonFiniteError = jsonenc.NonFiniteError
+)
+
+// NewEncoder creates a new Encoder writing to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Marshal encodes the context as nested JSON object using the default
+// settings.
+func Marshal(ctx *diag.Context) ([]byte, error) {
+	var e Encoder
+	return e.Append(nil, ctx)
+}
+
+// Encode writes the context as JSON object followed by a newline to the
+// writer configured with NewEncoder.
+func (e *Encoder) Encode(ctx *diag.Context) error {
+	e.enc.Reset()
+	if err := e.encode(ctx); err != nil {
+		return err
+	}
+
+	e.enc.Buf = append(e.enc.Buf, '\n')
+	_, err := e.w.Write(e.enc.Buf)
+	return err
+}
+
+// Append appends the JSON encoded context to dst and returns the extended
+// buffer. dst is returned unchanged if encoding fails.
+func (e *Encoder) Append(dst []byte, ctx *diag.Context) ([]byte, error) {
+	scratch := e.enc.Buf
+	e.enc.Writer = jsonenc.Writer{Buf: dst}
+	err := e.encode(ctx)
+	if err == nil {
+		dst = e.enc.Buf
+	}
+	e.enc.Writer = jsonenc.Writer{Buf: scratch[:0]}
+	return dst, err
+}
+
+func (e *Encoder) encode(ctx *diag.Context) error {
+	e.enc.Durations, e.enc.NonFinite = e.Durations, e.NonFinite
+	e.enc.BeginObject()
+
+	var err error
+	if e.Flat {
+		err = ctx.VisitKeyValues(e)
+	} else {
+		err = ctx.VisitStructured(e)
+	}
+	if err != nil {
+		return err
+	}
+
+	e.enc.EndObject()
+	return nil
+}
+
+// OnObjStart starts a nested object.
+func (e *Encoder) OnObjStart(key string) error {
+	e.enc.Key(key)
+	e.enc.BeginObject()
+	return nil
+}
+
+// OnObjEnd closes the current object.
+func (e *Encoder) OnObjEnd() error {
+	e.enc.EndObject()
+	return nil
+}
+
+// OnValue writes a field to the current object.
+func (e *Encoder) OnValue(key string, v diag.Value) error {
+	if v.Reporter == nil {
+		e.enc.Key(key)
+		e.enc.Null()
+		return nil
+	}
+	return e.enc.Value(key, jsonenc.Kind(v.Reporter.Type()), v.Primitive, v.String, v.Interface)
+}
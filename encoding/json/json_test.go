@@ -0,0 +1,179 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+package json
+
+import (
+	"bytes"
+	stdjson "encoding/json"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/urso/diag"
+	"github.com/urso/diag/internal/testctx"
+)
+
+type testMarshaler struct{}
+
+func (testMarshaler) MarshalJSON() ([]byte, error) { return []byte(`"custom"`), nil }
+func (testMarshaler) Error() string                { return "error" }
+
+func TestEncoder(t *testing.T) {
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+
+	cases := map[string]struct {
+		encoder Encoder
+		ctx     *diag.Context
+		want    string
+	}{
+		"empty": {
+			ctx:  testctx.New(),
+			want: `{}`,
+		},
+		"primitives": {
+			ctx: testctx.New(
+				diag.Bool("b", true),
+				diag.Int("i", -1),
+				diag.Int64("i64", math.MinInt64),
+				diag.Uint64("u", math.MaxUint64),
+				diag.Float("f", 1.5),
+				diag.String("s", "say \"hi\"\n"),
+				diag.Timestamp("ts", ts),
+			),
+			want: `{"b":true,"f":1.5,"i":-1,"i64":-9223372036854775808,"s":"say \"hi\"\n","ts":"2020-01-02T03:04:05.000000006Z","u":18446744073709551615}`,
+		},
+		"nested": {
+			ctx:  testctx.New(diag.String("http.method", "GET"), diag.Int("http.status", 200), diag.String("id", "x")),
+			want: `{"http":{"method":"GET","status":200},"id":"x"}`,
+		},
+		"flat": {
+			encoder: Encoder{Flat: true},
+			ctx:     testctx.New(diag.String("http.method", "GET"), diag.Int("http.status", 200), diag.String("id", "x")),
+			want:    `{"http.method":"GET","http.status":200,"id":"x"}`,
+		},
+		"durations as nanos": {
+			ctx:  testctx.New(diag.Duration("d", 1500*time.Millisecond)),
+			want: `{"d":1500000000}`,
+		},
+		"durations as string": {
+			encoder: Encoder{Durations: DurationString},
+			ctx:     testctx.New(diag.Duration("d", 1500*time.Millisecond)),
+			want:    `{"d":"1.5s"}`,
+		},
+		"durations as seconds": {
+			encoder: Encoder{Durations: DurationSeconds},
+			ctx:     testctx.New(diag.Duration("d", 1500*time.Millisecond)),
+			want:    `{"d":1.5}`,
+		},
+		"non-finite as null": {
+			ctx:  testctx.New(diag.Float("inf", math.Inf(1)), diag.Float("nan", math.NaN())),
+			want: `{"inf":null,"nan":null}`,
+		},
+		"non-finite as string": {
+			encoder: Encoder{NonFinite: NonFiniteString},
+			ctx:     testctx.New(diag.Float("a", math.Inf(1)), diag.Float("b", math.Inf(-1)), diag.Float("c", math.NaN())),
+			want:    `{"a":"+Inf","b":"-Inf","c":"NaN"}`,
+		},
+		"interface values": {
+			ctx: testctx.New(
+				"err", errors.New("oops"),
+				"custom", testMarshaler{},
+				"list", []string{"a", "b"},
+				"nil", nil,
+			),
+			want: `{"custom":"custom","err":"oops","list":["a","b"],"nil":null}`,
+		},
+		"zero value": {
+			ctx:  testctx.New(diag.Field{Key: "zero"}),
+			want: `{"zero":null}`,
+		},
+	}
+
+	for name, test := range cases {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			got, err := test.encoder.Append(nil, test.ctx)
+			if err != nil {
+				t.Fatalf("encoding failed: %v", err)
+			}
+			if string(got) != test.want {
+				t.Errorf("got %s\nwant %s", got, test.want)
+			}
+			if !stdjson.Valid(got) {
+				t.Errorf("invalid JSON: %s", got)
+			}
+		})
+	}
+}
+
+func TestEncoderNonFiniteError(t *testing.T) {
+	e := Encoder{NonFinite: NonFiniteError}
+	dst := []byte("prefix")
+	got, err := e.Append(dst, testctx.New(diag.Int("a", 1), diag.Float("f", math.NaN())))
+	if err == nil {
+		t.Error("expected error")
+	}
+	if string(got) != "prefix" {
+		t.Errorf("got %q, want dst unchanged", got)
+	}
+}
+
+func TestEncodeWriter(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.Flat = true
+
+	for i := 0; i < 2; i++ {
+		if err := enc.Encode(testctx.New(diag.Int("a.b", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := "{\"a.b\":0}\n{\"a.b\":1}\n"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestMarshalMatchesContextMarshalJSON(t *testing.T) {
+	ctx := testctx.New(
+		diag.String("a.b", "x"),
+		diag.Int("a.c", 1),
+		diag.Duration("took", time.Second),
+		diag.Float("nan", math.NaN()),
+		"err", errors.New("oops"),
+	)
+
+	got, err := Marshal(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := ctx.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("Marshal = %s, MarshalJSON = %s", got, want)
+	}
+}
+
+func BenchmarkEncoder(b *testing.B) {
+	ctx := testctx.New(
+		diag.String("http.method", "GET"),
+		diag.Int("http.status", 200),
+		diag.Duration("took", time.Second),
+		diag.String("msg", "hello world"),
+	)
+
+	var enc Encoder
+	var buf []byte
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf, _ = enc.Append(buf[:0], ctx)
+	}
+}
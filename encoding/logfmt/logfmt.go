@@ -0,0 +1,260 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+// Package logfmt encodes diagnostic contexts as logfmt lines, and parses
+// logfmt lines into diagnostic contexts.
+//
+// A logfmt line is a list of space separated key=value pairs, e.g.
+//
+//	http.method=GET http.status=200 msg="hello world"
+//
+// Fields are written in the order reported by VisitKeyValues, which sorts
+// the fields by key. Values containing spaces, quotes, '=', or control
+// characters are quoted.
+package logfmt
+
+import (
+	"encoding"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/urso/diag"
+	"github.com/urso/diag/internal/jsonenc"
+)
+
+// Encoder writes diagnostic contexts as logfmt lines.
+// An Encoder must not be used concurrently.
+type Encoder struct {
+	// TimeFormat configures the layout used to encode timestamps.
+	// time.RFC3339Nano is used if TimeFormat is empty.
+	TimeFormat string
+
+	// Durations configures the encoding of durations.
+	Durations DurationFormat
+
+	w       io.Writer
+	buf     []byte
+	needSep bool
+}
+
+// DurationFormat selects the encoding of durations.
+type DurationFormat = jsonenc.DurationFormat
+
+const (
+	// DurationNanos encodes durations as integer nanoseconds.
+	DurationNanos = jsonenc.DurationNanos
+
+	// DurationString encodes durations as string, e.g. 1m30s.
+	DurationString = jsonenc.DurationString
+
+	// DurationSeconds encodes durations as floating point seconds.
+	DurationSeconds = jsonenc.DurationSeconds
+)
+
+// NewEncoder creates a new Encoder writing to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Marshal encodes the context as logfmt line, using the default settings.
+// The line is not terminated by a newline.
+func Marshal(ctx *diag.Context) ([]byte, error) {
+	var e Encoder
+	return e.Append(nil, ctx)
+}
+
+// Encode writes the context as logfmt line followed by a newline to the
+// writer configured with NewEncoder.
+func (e *Encoder) Encode(ctx *diag.Context) error {
+	e.buf = e.buf[:0]
+	e.needSep = false
+	if err := ctx.VisitKeyValues(e); err != nil {
+		return err
+	}
+
+	e.buf = append(e.buf, '\n')
+	_, err := e.w.Write(e.buf)
+	return err
+}
+
+// Append appends the logfmt encoded context to dst and returns the extended
+// buffer.
+func (e *Encoder) Append(dst []byte, ctx *diag.Context) ([]byte, error) {
+	scratch := e.buf
+	e.buf = dst
+	e.needSep = false
+	err := ctx.VisitKeyValues(e)
+	dst = e.buf
+	e.buf = scratch[:0]
+	return dst, err
+}
+
+// OnObjStart does nothing. logfmt has no nested objects, and keys are
+// written as dotted paths by OnValue.
+func (e *Encoder) OnObjStart(_ string) error { return nil }
+
+// OnObjEnd does nothing.
+func (e *Encoder) OnObjEnd() error { return nil }
+
+// OnValue writes a key=value pair.
+func (e *Encoder) OnValue(key string, v diag.Value) error {
+	if e.needSep {
+		e.buf = append(e.buf, ' ')
+	}
+	e.needSep = true
+	e.buf = appendKey(e.buf, key)
+	e.buf = append(e.buf, '=')
+
+	if v.Reporter == nil {
+		e.buf = append(e.buf, "null"...)
+		return nil
+	}
+
+	switch v.Reporter.Type() {
+	case diag.BoolType:
+		e.buf = strconv.AppendBool(e.buf, v.Primitive != 0)
+	case diag.IntType, diag.Int64Type:
+		e.buf = strconv.AppendInt(e.buf, int64(v.Primitive), 10)
+	case diag.Uint64Type:
+		e.buf = strconv.AppendUint(e.buf, v.Primitive, 10)
+	case diag.Float64Type:
+		e.buf = appendFloat(e.buf, math.Float64frombits(v.Primitive))
+	case diag.DurationType:
+		e.buf = e.appendDuration(e.buf, time.Duration(v.Primitive))
+	case diag.StringType:
+		e.buf = appendValue(e.buf, v.String)
+	case diag.TimestampType:
+		if ts, ok := v.Ifc.(time.Time); ok {
+			e.buf = appendValue(e.buf, ts.Format(e.timeFormat()))
+			return nil
+		}
+		e.buf = appendAny(e.buf, v.Interface())
+	default:
+		e.buf = appendAny(e.buf, v.Interface())
+	}
+	return nil
+}
+
+func (e *Encoder) timeFormat() string {
+	if e.TimeFormat == "" {
+		return time.RFC3339Nano
+	}
+	return e.TimeFormat
+}
+
+func (e *Encoder) appendDuration(b []byte, d time.Duration) []byte {
+	switch e.Durations {
+	case DurationString:
+		return append(b, d.String()...)
+	case DurationSeconds:
+		return appendFloat(b, d.Seconds())
+	default:
+		return strconv.AppendInt(b, int64(d), 10)
+	}
+}
+
+// appendKey writes the key, replacing characters not allowed in logfmt keys
+// with '_'.
+func appendKey(b []byte, key string) []byte {
+	if key == "" {
+		return append(b, '_')
+	}
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if c <= ' ' || c == '=' || c == '"' || c == 0x7f {
+			c = '_'
+		}
+		b = append(b, c)
+	}
+	return b
+}
+
+// appendValue writes the value, quoting the value if required. Strings that
+// would be decoded as number, boolean, or null are quoted as well.
+func appendValue(b []byte, s string) []byte {
+	if needsQuoting(s) || isLiteral(s) {
+		return jsonenc.AppendString(b, s)
+	}
+	return append(b, s...)
+}
+
+func needsQuoting(s string) bool {
+	if s == "" {
+		return true
+	}
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			if c <= ' ' || c == '=' || c == '"' || c == '\\' || c == 0x7f {
+				return true
+			}
+			i++
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			return true
+		}
+		i += size
+	}
+	return false
+}
+
+// isLiteral checks if the unquoted string s would be decoded as a typed
+// value.
+func isLiteral(s string) bool {
+	switch s {
+	case "true", "false", "null", "NaN":
+		return true
+	}
+	if c := s[0]; c != '-' && c != '+' && !('0' <= c && c <= '9') {
+		return false
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return true
+	}
+	if _, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return true
+	}
+	return false
+}
+
+func appendFloat(b []byte, f float64) []byte {
+	switch {
+	case math.IsNaN(f):
+		return append(b, "NaN"...)
+	case math.IsInf(f, 1):
+		return append(b, "+Inf"...)
+	case math.IsInf(f, -1):
+		return append(b, "-Inf"...)
+	default:
+		return jsonenc.AppendFloat(b, f)
+	}
+}
+
+func appendAny(b []byte, v interface{}) []byte {
+	switch x := v.(type) {
+	case nil:
+		return append(b, "null"...)
+	case error:
+		return appendValue(b, x.Error())
+	case encoding.TextMarshaler:
+		text, err := x.MarshalText()
+		if err != nil {
+			return appendValue(b, fmt.Sprintf("!ERROR(%v)", err))
+		}
+		return appendValue(b, string(text))
+	case fmt.Stringer:
+		return appendValue(b, x.String())
+	default:
+		return appendValue(b, fmt.Sprint(v))
+	}
+}
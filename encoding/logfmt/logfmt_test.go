@@ -0,0 +1,137 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+package logfmt
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/urso/diag"
+	"github.com/urso/diag/internal/testctx"
+)
+
+type testStringer struct{}
+
+func (testStringer) String() string { return "stringer value" }
+
+func TestEncoder(t *testing.T) {
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+
+	cases := map[string]struct {
+		encoder Encoder
+		ctx     *diag.Context
+		want    string
+	}{
+		"empty": {
+			ctx:  testctx.New(),
+			want: ``,
+		},
+		"primitives": {
+			ctx: testctx.New(
+				diag.Bool("b", true),
+				diag.Int("i", -1),
+				diag.Uint64("u", math.MaxUint64),
+				diag.Float("f", 1.5),
+				diag.String("s", "word"),
+			),
+			want: `b=true f=1.5 i=-1 s=word u=18446744073709551615`,
+		},
+		"sorted dotted keys": {
+			ctx:  testctx.New(diag.Int("http.status", 200), diag.String("http.method", "GET"), diag.String("a", "x")),
+			want: `a=x http.method=GET http.status=200`,
+		},
+		"quote typed literals": {
+			ctx: testctx.New(
+				diag.String("a", "123"),
+				diag.String("b", "true"),
+				diag.String("c", "null"),
+				diag.String("d", "-1.5e3"),
+				diag.String("e", "+Inf"),
+				diag.String("f", "1.2.3"),
+			),
+			want: `a="123" b="true" c="null" d="-1.5e3" e="+Inf" f=1.2.3`,
+		},
+		"quoting": {
+			ctx: testctx.New(
+				diag.String("a", "hello world"),
+				diag.String("b", `say "hi"`),
+				diag.String("c", "x=y"),
+				diag.String("d", ""),
+				diag.String("e", "line\nbreak"),
+				diag.String("f", `back\slash`),
+				diag.String("g", "ünïcode"),
+			),
+			want: `a="hello world" b="say \"hi\"" c="x=y" d="" e="line\nbreak" f="back\\slash" g=ünïcode`,
+		},
+		"invalid key characters": {
+			ctx:  testctx.New(diag.String("a b=\"c", "x")),
+			want: `a_b__c=x`,
+		},
+		"non-finite floats": {
+			ctx:  testctx.New(diag.Float("a", math.NaN()), diag.Float("b", math.Inf(1)), diag.Float("c", math.Inf(-1))),
+			want: `a=NaN b=+Inf c=-Inf`,
+		},
+		"timestamps": {
+			ctx:  testctx.New(diag.Timestamp("ts", ts)),
+			want: `ts=2020-01-02T03:04:05.000000006Z`,
+		},
+		"custom time format": {
+			encoder: Encoder{TimeFormat: "2006-01-02 15:04"},
+			ctx:     testctx.New(diag.Timestamp("ts", ts)),
+			want:    `ts="2020-01-02 03:04"`,
+		},
+		"durations": {
+			ctx:  testctx.New(diag.Duration("d", 1500*time.Millisecond)),
+			want: `d=1500000000`,
+		},
+		"durations as string": {
+			encoder: Encoder{Durations: DurationString},
+			ctx:     testctx.New(diag.Duration("d", 1500*time.Millisecond)),
+			want:    `d=1.5s`,
+		},
+		"durations as seconds": {
+			encoder: Encoder{Durations: DurationSeconds},
+			ctx:     testctx.New(diag.Duration("d", 1500*time.Millisecond)),
+			want:    `d=1.5`,
+		},
+		"interface values": {
+			ctx:  testctx.New("err", errors.New("file not found"), "nil", nil, "s", testStringer{}, "list", []int{1, 2}),
+			want: `err="file not found" list="[1 2]" nil=null s="stringer value"`,
+		},
+	}
+
+	for name, test := range cases {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			got, err := test.encoder.Append(nil, test.ctx)
+			if err != nil {
+				t.Fatalf("encoding failed: %v", err)
+			}
+			if string(got) != test.want {
+				t.Errorf("got  %s\nwant %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestEncodeWriter(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	for i := 0; i < 2; i++ {
+		if err := enc.Encode(testctx.New(diag.Int("a", i), diag.String("b", "x y"))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := "a=0 b=\"x y\"\na=1 b=\"x y\"\n"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
@@ -0,0 +1,207 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+package logfmt
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urso/diag"
+)
+
+// Decoder reads logfmt lines from an input stream and adds the fields to
+// diagnostic contexts.
+//
+// Unquoted values are typed: true and false are decoded as booleans, integers
+// as int64 (or uint64 if too large), and numbers with fraction or exponent
+// as float64. Quoted values are always decoded as strings. A key without
+// value is decoded as boolean true.
+type Decoder struct {
+	// ParseTimestamps configures the decoder to decode unquoted values in
+	// TimeFormat as timestamps.
+	ParseTimestamps bool
+
+	// TimeFormat configures the layout used to parse timestamps.
+	// time.RFC3339Nano is used if TimeFormat is empty.
+	TimeFormat string
+
+	// MaxLineSize limits the length of the lines read by Decode, in bytes.
+	// Decode fails with bufio.ErrTooLong if a line exceeds the limit. The
+	// limit defaults to 1MB if MaxLineSize is 0.
+	MaxLineSize int
+
+	scanner *bufio.Scanner
+	started bool
+}
+
+const defaultMaxLineSize = 1 << 20
+
+// SyntaxError reports an invalid logfmt line.
+type SyntaxError struct {
+	Msg    string
+	Offset int // byte offset in the line the error occurred at
+}
+
+// NewDecoder creates a new Decoder reading from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{scanner: bufio.NewScanner(r)}
+}
+
+// Unmarshal parses a single logfmt line and adds its fields to ctx, using the
+// default Decoder settings.
+func Unmarshal(line []byte, ctx *diag.Context) error {
+	var d Decoder
+	return d.Parse(string(line), ctx)
+}
+
+// Decode reads the next non-empty line from the input and adds its fields to
+// ctx. Decode returns io.EOF if the input is exhausted.
+func (d *Decoder) Decode(ctx *diag.Context) error {
+	if !d.started {
+		max := d.MaxLineSize
+		if max <= 0 {
+			max = defaultMaxLineSize
+		}
+		d.scanner.Buffer(nil, max)
+		d.started = true
+	}
+
+	for d.scanner.Scan() {
+		line := d.scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		return d.Parse(line, ctx)
+	}
+
+	if err := d.scanner.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+// Parse parses a single logfmt line and adds its fields to ctx.
+// No fields are added if the line is invalid.
+func (d *Decoder) Parse(line string, ctx *diag.Context) error {
+	var fields []diag.Field
+
+	for i := 0; i < len(line); {
+		// skip whitespace
+		if c := line[i]; c == ' ' || c == '\t' || c == '\r' || c == '\n' {
+			i++
+			continue
+		}
+
+		start := i
+		for i < len(line) && line[i] > ' ' && line[i] != '=' && line[i] != '"' {
+			i++
+		}
+		if i == start {
+			return &SyntaxError{Msg: "missing key", Offset: i}
+		}
+		key := line[start:i]
+
+		if i >= len(line) || line[i] != '=' {
+			if i < len(line) && line[i] == '"' {
+				return &SyntaxError{Msg: "unexpected quote in key", Offset: i}
+			}
+			fields = append(fields, diag.Bool(key, true))
+			continue
+		}
+		i++ // skip '='
+
+		if i < len(line) && line[i] == '"' {
+			end, err := quotedEnd(line, i)
+			if err != nil {
+				return err
+			}
+
+			s, err := strconv.Unquote(line[i:end])
+			if err != nil {
+				return &SyntaxError{Msg: "invalid quoted value", Offset: i}
+			}
+			fields = append(fields, diag.String(key, s))
+			i = end
+			continue
+		}
+
+		start = i
+		for i < len(line) && line[i] > ' ' {
+			if line[i] == '"' || line[i] == '=' {
+				return &SyntaxError{Msg: fmt.Sprintf("unexpected '%c' in value", line[i]), Offset: i}
+			}
+			i++
+		}
+		fields = append(fields, diag.Field{Key: key, Value: d.value(line[start:i])})
+	}
+
+	ctx.AddFields(fields...)
+	return nil
+}
+
+// quotedEnd finds the end of the quoted string starting at line[start].
+func quotedEnd(line string, start int) (int, error) {
+	for i := start + 1; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1, nil
+		}
+	}
+	return 0, &SyntaxError{Msg: "unterminated quoted value", Offset: start}
+}
+
+func (d *Decoder) value(s string) diag.Value {
+	switch s {
+	case "":
+		return diag.ValString("")
+	case "true":
+		return diag.ValBool(true)
+	case "false":
+		return diag.ValBool(false)
+	case "null":
+		return diag.ValAny(nil)
+	case "NaN":
+		return diag.ValFloat(math.NaN())
+	}
+
+	if c := s[0]; c == '-' || c == '+' || ('0' <= c && c <= '9') {
+		if !strings.ContainsAny(s, ".eEI") {
+			if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+				return diag.ValInt64(i)
+			}
+			if u, err := strconv.ParseUint(s, 10, 64); err == nil {
+				return diag.ValUint64(u)
+			}
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return diag.ValFloat(f)
+		}
+	}
+
+	if d.ParseTimestamps {
+		layout := d.TimeFormat
+		if layout == "" {
+			layout = time.RFC3339Nano
+		}
+		if ts, err := time.Parse(layout, s); err == nil {
+			return diag.ValTime(ts)
+		}
+	}
+
+	return diag.ValString(s)
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("logfmt: %v at offset %v", e.Msg, e.Offset)
+}
@@ -0,0 +1,189 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+package logfmt
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/urso/diag"
+)
+
+type fieldCollector map[string]interface{}
+
+func (c fieldCollector) OnObjStart(_ string) error { return nil }
+func (c fieldCollector) OnObjEnd() error           { return nil }
+func (c fieldCollector) OnValue(key string, v diag.Value) error {
+	c[key] = v.Interface()
+	return nil
+}
+
+func collectFields(ctx *diag.Context) map[string]interface{} {
+	c := fieldCollector{}
+	ctx.VisitKeyValues(c)
+	return c
+}
+
+func TestParse(t *testing.T) {
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+
+	cases := map[string]struct {
+		decoder Decoder
+		line    string
+		want    map[string]interface{}
+	}{
+		"empty": {
+			line: "",
+			want: map[string]interface{}{},
+		},
+		"typed values": {
+			line: `b=true i=-12 u=18446744073709551615 f=1.5 e=1e3 s=word n=null empty=`,
+			want: map[string]interface{}{
+				"b": true, "i": int64(-12), "u": uint64(18446744073709551615), "f": 1.5, "e": 1000.0,
+				"s": "word", "n": nil, "empty": "",
+			},
+		},
+		"quoted values are strings": {
+			line: `a="hello world" b="say \"hi\"" c="12" d="line\nbreak" e="ä"`,
+			want: map[string]interface{}{"a": "hello world", "b": `say "hi"`, "c": "12", "d": "line\nbreak", "e": "ä"},
+		},
+		"bare keys": {
+			line: `debug level=info verbose`,
+			want: map[string]interface{}{"debug": true, "level": "info", "verbose": true},
+		},
+		"extra whitespace": {
+			line: "  a=1 \t b=2  ",
+			want: map[string]interface{}{"a": int64(1), "b": int64(2)},
+		},
+		"dotted keys": {
+			line: `http.method=GET http.status=200`,
+			want: map[string]interface{}{"http.method": "GET", "http.status": int64(200)},
+		},
+		"infinity": {
+			line: `a=+Inf b=-Inf`,
+			want: map[string]interface{}{"a": math.Inf(1), "b": math.Inf(-1)},
+		},
+		"timestamps": {
+			decoder: Decoder{ParseTimestamps: true},
+			line:    `ts=2020-01-02T03:04:05.000000006Z s="2020-01-02T03:04:05Z" d=2020-01-02`,
+			want:    map[string]interface{}{"ts": ts, "s": "2020-01-02T03:04:05Z", "d": "2020-01-02"},
+		},
+		"custom timestamp format": {
+			decoder: Decoder{ParseTimestamps: true, TimeFormat: "2006-01-02"},
+			line:    `d=2020-01-02`,
+			want:    map[string]interface{}{"d": time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)},
+		},
+	}
+
+	for name, test := range cases {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			ctx := diag.NewContext(nil, nil)
+			if err := test.decoder.Parse(test.line, ctx); err != nil {
+				t.Fatalf("parsing failed: %v", err)
+			}
+			if diff := cmp.Diff(test.want, collectFields(ctx)); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	cases := []string{
+		`=value`,
+		`a="unterminated`,
+		`a=b"c`,
+		`a=b=c`,
+		`a"b=c`,
+		`a="bad \q escape"`,
+	}
+
+	for _, line := range cases {
+		ctx := diag.NewContext(nil, nil)
+		err := Unmarshal([]byte(line), ctx)
+		if _, ok := err.(*SyntaxError); !ok {
+			t.Errorf("Unmarshal(%q) = %v, want SyntaxError", line, err)
+		}
+		if ctx.Len() != 0 {
+			t.Errorf("Unmarshal(%q) added fields on error", line)
+		}
+	}
+}
+
+func TestDecoderStream(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("a=1\n\nb=2\n"))
+
+	var got []map[string]interface{}
+	for {
+		ctx := diag.NewContext(nil, nil)
+		err := dec.Decode(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, collectFields(ctx))
+	}
+
+	want := []map[string]interface{}{{"a": int64(1)}, {"b": int64(2)}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestDecoderMaxLineSize(t *testing.T) {
+	line := "a=" + strings.Repeat("x", 100<<10) + "\n"
+
+	dec := NewDecoder(strings.NewReader(line))
+	ctx := diag.NewContext(nil, nil)
+	if err := dec.Decode(ctx); err != nil {
+		t.Fatalf("decoding line larger than 64KB failed: %v", err)
+	}
+
+	dec = NewDecoder(strings.NewReader(line))
+	dec.MaxLineSize = 1024
+	if err := dec.Decode(diag.NewContext(nil, nil)); err != bufio.ErrTooLong {
+		t.Errorf("got %v, want bufio.ErrTooLong", err)
+	}
+}
+
+func TestRoundtrip(t *testing.T) {
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+	ctx := diag.NewContext(nil, nil)
+	ctx.AddFields(
+		diag.String("msg", "hello \"world\"\n"),
+		diag.String("empty", ""),
+		diag.String("id", "0042"),
+		diag.String("flag", "true"),
+		diag.Int64("http.status", 200),
+		diag.Float("load", 0.25),
+		diag.Bool("ok", false),
+		diag.Timestamp("ts", ts),
+	)
+
+	line, err := Marshal(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded := diag.NewContext(nil, nil)
+	dec := Decoder{ParseTimestamps: true}
+	if err := dec.Parse(string(line), decoded); err != nil {
+		t.Fatalf("parsing %s failed: %v", line, err)
+	}
+
+	if diff := cmp.Diff(collectFields(ctx), collectFields(decoded)); diff != "" {
+		t.Errorf("roundtrip mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,434 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+// Package syslog encodes diagnostic contexts as RFC 5424 STRUCTURED-DATA,
+// and builds complete RFC 5424 syslog messages.
+//
+// Fields are grouped into SD-ELEMENTs by the first segment of their dotted
+// key. The remaining key is used as PARAM-NAME, without escaping of dots.
+// Top-level fields without a dot are written to the element configured by
+// DefaultID, e.g.
+//
+//	[http request.method="GET" response.status="200"][ctx message="done"]
+//
+// Names are sanitized to at most 32 printable ASCII characters, replacing
+// '=', ' ', ']', and '"' with '_'. Parameter values escape '"', '\', and ']'.
+package syslog
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/urso/diag"
+	"github.com/urso/diag/internal/textenc"
+)
+
+// Encoder writes diagnostic contexts as RFC 5424 STRUCTURED-DATA and
+// syslog messages.
+// An Encoder must not be used concurrently.
+type Encoder struct {
+	// DefaultID configures the SD-ID of the element for top-level fields.
+	// "ctx" is used if DefaultID is empty.
+	DefaultID string
+
+	// EnterpriseID is appended as `@<EnterpriseID>` to all SD-IDs, as required
+	// by RFC 5424 for SD-IDs not registered with IANA.
+	EnterpriseID string
+
+	// OctetCounting prefixes messages written by Encode with the message
+	// length, as required for syslog over TCP (RFC 6587).
+	OctetCounting bool
+
+	w      io.Writer
+	buf    []byte
+	frame  []byte
+	groups []group
+}
+
+// Facility is the syslog facility a message originates from.
+type Facility uint8
+
+// Syslog facilities as defined in RFC 5424.
+const (
+	FacilityKern Facility = iota
+	FacilityUser
+	FacilityMail
+	FacilityDaemon
+	FacilityAuth
+	FacilitySyslog
+	FacilityLPR
+	FacilityNews
+	FacilityUUCP
+	FacilityCron
+	FacilityAuthPriv
+	FacilityFTP
+	FacilityNTP
+	FacilityAudit
+	FacilityAlert
+	FacilityClock
+	FacilityLocal0
+	FacilityLocal1
+	FacilityLocal2
+	FacilityLocal3
+	FacilityLocal4
+	FacilityLocal5
+	FacilityLocal6
+	FacilityLocal7
+)
+
+// Severity is the syslog severity of a message.
+type Severity uint8
+
+// Syslog severities as defined in RFC 5424.
+const (
+	SeverityEmergency Severity = iota
+	SeverityAlert
+	SeverityCritical
+	SeverityError
+	SeverityWarning
+	SeverityNotice
+	SeverityInformational
+	SeverityDebug
+)
+
+// Message is a RFC 5424 syslog message. Empty header fields and a zero
+// Timestamp are written as NILVALUE '-'.
+type Message struct {
+	Facility  Facility
+	Severity  Severity
+	Timestamp time.Time
+	Hostname  string
+	AppName   string
+	ProcID    string
+	MsgID     string
+
+	// Context is encoded as STRUCTURED-DATA.
+	Context *diag.Context
+
+	// Msg is the free-form message. Msg is prefixed with the UTF-8 BOM, as
+	// recommended by RFC 5424.
+	Msg string
+}
+
+// group collects the SD-PARAMs of a SD-ELEMENT. Fields are grouped by the
+// final SD-ID, after applying DefaultID, sanitization, and EnterpriseID, such
+// that no SD-ID is written twice.
+type group struct {
+	key       string // first key segment, used to find the group quickly
+	id        string // final SD-ID
+	isDefault bool   // element holds top-level fields
+	params    []byte
+}
+
+const (
+	nilValue     = "-"
+	maxNameLen   = 32
+	maxHostname  = 255
+	maxAppName   = 48
+	maxProcID    = 128
+	maxMsgID     = 32
+	utf8BOM      = "\xef\xbb\xbf"
+	rfc5424Stamp = "2006-01-02T15:04:05.999999Z07:00"
+)
+
+// NewEncoder creates a new Encoder writing to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// MarshalStructuredData encodes the context as STRUCTURED-DATA, using the
+// default settings.
+func MarshalStructuredData(ctx *diag.Context) ([]byte, error) {
+	var e Encoder
+	return e.AppendStructuredData(nil, ctx)
+}
+
+// Encode writes the message to the writer configured with NewEncoder, using
+// one call to Write per message. Messages are not terminated by a newline.
+func (e *Encoder) Encode(msg *Message) error {
+	buf, err := e.AppendMessage(e.buf[:0], msg)
+	if err != nil {
+		return err
+	}
+
+	e.buf = buf[:0]
+
+	if e.OctetCounting {
+		e.frame = strconv.AppendInt(e.frame[:0], int64(len(buf)), 10)
+		e.frame = append(e.frame, ' ')
+		e.frame = append(e.frame, buf...)
+		buf = e.frame
+	}
+
+	_, err = e.w.Write(buf)
+	return err
+}
+
+// AppendMessage appends the RFC 5424 message to dst and returns the extended
+// buffer.
+func (e *Encoder) AppendMessage(dst []byte, msg *Message) ([]byte, error) {
+	if msg.Facility > FacilityLocal7 {
+		return dst, fmt.Errorf("syslog: invalid facility %v", msg.Facility)
+	}
+	if msg.Severity > SeverityDebug {
+		return dst, fmt.Errorf("syslog: invalid severity %v", msg.Severity)
+	}
+
+	dst = append(dst, '<')
+	dst = strconv.AppendUint(dst, uint64(msg.Facility)*8+uint64(msg.Severity), 10)
+	dst = append(dst, ">1 "...)
+
+	if msg.Timestamp.IsZero() {
+		dst = append(dst, nilValue...)
+	} else {
+		dst = msg.Timestamp.AppendFormat(dst, rfc5424Stamp)
+	}
+
+	dst = append(dst, ' ')
+	dst = appendHeaderField(dst, msg.Hostname, maxHostname)
+	dst = append(dst, ' ')
+	dst = appendHeaderField(dst, msg.AppName, maxAppName)
+	dst = append(dst, ' ')
+	dst = appendHeaderField(dst, msg.ProcID, maxProcID)
+	dst = append(dst, ' ')
+	dst = appendHeaderField(dst, msg.MsgID, maxMsgID)
+	dst = append(dst, ' ')
+
+	if msg.Context == nil {
+		dst = append(dst, nilValue...)
+	} else {
+		var err error
+		if dst, err = e.AppendStructuredData(dst, msg.Context); err != nil {
+			return dst, err
+		}
+	}
+
+	if msg.Msg != "" {
+		dst = append(dst, ' ')
+		dst = append(dst, utf8BOM...)
+		dst = append(dst, strings.ToValidUTF8(msg.Msg, "\ufffd")...)
+	}
+	return dst, nil
+}
+
+// AppendStructuredData appends the context as STRUCTURED-DATA to dst and
+// returns the extended buffer. An empty context is written as NILVALUE '-'.
+func (e *Encoder) AppendStructuredData(dst []byte, ctx *diag.Context) ([]byte, error) {
+	for i := range e.groups {
+		e.groups[i].params = e.groups[i].params[:0]
+	}
+	e.groups = e.groups[:0]
+
+	if err := ctx.VisitKeyValues(e); err != nil {
+		return dst, err
+	}
+
+	if len(e.groups) == 0 {
+		return append(dst, nilValue...), nil
+	}
+
+	// The default element is written last, after all grouped elements.
+	for _, g := range e.groups {
+		if !g.isDefault {
+			dst = appendElement(dst, g.id, g.params)
+		}
+	}
+	for _, g := range e.groups {
+		if g.isDefault {
+			dst = appendElement(dst, g.id, g.params)
+		}
+	}
+	return dst, nil
+}
+
+func appendElement(dst []byte, id string, params []byte) []byte {
+	dst = append(dst, '[')
+	dst = append(dst, id...)
+	dst = append(dst, params...)
+	return append(dst, ']')
+}
+
+// sdID returns the sanitized SD-ID for the first key segment. The DefaultID
+// is used for top-level fields. The name is truncated before the
+// EnterpriseID is appended, such that the enterprise number is never cut.
+func (e *Encoder) sdID(key string) string {
+	id := key
+	if id == "" {
+		id = e.DefaultID
+		if id == "" {
+			id = "ctx"
+		}
+	}
+
+	if e.EnterpriseID == "" {
+		return string(appendName(nil, id))
+	}
+
+	suffix := "@" + e.EnterpriseID
+	if max := maxNameLen - len(suffix); max > 0 && len(id) > max {
+		id = id[:max]
+	}
+	b := appendName(nil, id)
+	b = append(b, suffix...)
+	if len(b) > maxNameLen {
+		b = b[:maxNameLen]
+	}
+	return string(b)
+}
+
+// OnObjStart does nothing. OnValue selects the SD-ELEMENT from the
+// first segment of the key.
+func (e *Encoder) OnObjStart(_ string) error { return nil }
+
+// OnObjEnd does nothing.
+func (e *Encoder) OnObjEnd() error { return nil }
+
+// OnValue adds a SD-PARAM to the SD-ELEMENT selected by the first segment
+// of key.
+func (e *Encoder) OnValue(key string, v diag.Value) error {
+	id, name := "", key
+	if path := diag.SplitPath(key); len(path) > 1 {
+		id, name = path[0], strings.Join(path[1:], ".")
+		if id == "" {
+			id = "_"
+		}
+	} else {
+		name = path[0]
+	}
+
+	// VisitKeyValues reports keys sorted, such that all fields of an
+	// element are reported in order.
+	g := e.group(id)
+	g.params = append(g.params, ' ')
+	g.params = appendName(g.params, name)
+	g.params = append(g.params, '=', '"')
+	g.params = appendParamValue(g.params, formatValue(v))
+	g.params = append(g.params, '"')
+	return nil
+}
+
+// group returns the group for the first key segment. Keys mapping to the
+// same SD-ID share a group.
+func (e *Encoder) group(key string) *group {
+	if n := len(e.groups); n > 0 && e.groups[n-1].key == key {
+		return &e.groups[n-1]
+	}
+
+	id := e.sdID(key)
+	for i := range e.groups {
+		if g := &e.groups[i]; g.id == id {
+			g.key = key
+			g.isDefault = g.isDefault || key == ""
+			return g
+		}
+	}
+
+	if len(e.groups) < cap(e.groups) {
+		e.groups = e.groups[:len(e.groups)+1]
+	} else {
+		e.groups = append(e.groups, group{})
+	}
+	g := &e.groups[len(e.groups)-1]
+	g.key, g.id, g.isDefault = key, id, key == ""
+	g.params = g.params[:0]
+	return g
+}
+
+// appendName writes a SD-NAME. Characters not allowed in SD-NAMEs are
+// replaced with '_', and the name is truncated to 32 characters.
+func appendName(b []byte, name string) []byte {
+	if name == "" {
+		return append(b, '_')
+	}
+	if len(name) > maxNameLen {
+		name = name[:maxNameLen]
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c <= ' ' || c >= 0x7f || c == '=' || c == ']' || c == '"' {
+			c = '_'
+		}
+		b = append(b, c)
+	}
+	return b
+}
+
+// appendParamValue writes a PARAM-VALUE, escaping '"', '\', and ']'.
+// Invalid UTF-8 sequences are replaced with U+FFFD.
+func appendParamValue(b []byte, s string) []byte {
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			if c == '"' || c == '\\' || c == ']' {
+				b = append(b, '\\')
+			}
+			b = append(b, c)
+			i++
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			b = append(b, "\ufffd"...)
+		} else {
+			b = append(b, s[i:i+size]...)
+		}
+		i += size
+	}
+	return b
+}
+
+// appendHeaderField writes a header field consisting of printable ASCII
+// characters, or NILVALUE if s is empty.
+func appendHeaderField(b []byte, s string, max int) []byte {
+	if s == "" {
+		return append(b, nilValue...)
+	}
+	if len(s) > max {
+		s = s[:max]
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c >= 0x7f {
+			c = '_'
+		}
+		b = append(b, c)
+	}
+	return b
+}
+
+func formatValue(v diag.Value) string {
+	if v.Reporter == nil {
+		return ""
+	}
+
+	switch v.Reporter.Type() {
+	case diag.BoolType:
+		return strconv.FormatBool(v.Primitive != 0)
+	case diag.IntType, diag.Int64Type:
+		return strconv.FormatInt(int64(v.Primitive), 10)
+	case diag.Uint64Type:
+		return strconv.FormatUint(v.Primitive, 10)
+	case diag.Float64Type:
+		return textenc.FormatFloat(math.Float64frombits(v.Primitive))
+	case diag.DurationType:
+		return time.Duration(v.Primitive).String()
+	case diag.StringType:
+		return v.String
+	case diag.TimestampType:
+		if ts, ok := v.Ifc.(time.Time); ok {
+			return ts.Format(time.RFC3339Nano)
+		}
+		return textenc.FormatAny(v.Interface())
+	default:
+		return textenc.FormatAny(v.Interface())
+	}
+}
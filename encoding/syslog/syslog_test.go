@@ -0,0 +1,221 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+package syslog
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/urso/diag"
+	"github.com/urso/diag/internal/testctx"
+)
+
+func TestStructuredData(t *testing.T) {
+	cases := map[string]struct {
+		encoder Encoder
+		ctx     *diag.Context
+		want    string
+	}{
+		"empty": {
+			ctx:  testctx.New(),
+			want: `-`,
+		},
+		"top-level fields": {
+			ctx:  testctx.New("message", "done", "n", 1),
+			want: `[ctx message="done" n="1"]`,
+		},
+		"grouping": {
+			ctx: testctx.New(
+				"message", "done",
+				diag.String("http.request.method", "GET"),
+				diag.Int("http.response.status", 200),
+				diag.String("user.name", "alice"),
+				"z", true,
+			),
+			want: `[http request.method="GET" response.status="200"][user name="alice"][ctx message="done" z="true"]`,
+		},
+		"escaped dots": {
+			ctx:  testctx.New(diag.Int(`hosts.db\.example\.com`, 1), diag.Int(`a\.b`, 2)),
+			want: `[hosts db.example.com="1"][ctx a.b="2"]`,
+		},
+		"custom ids": {
+			encoder: Encoder{DefaultID: "meta", EnterpriseID: "32473"},
+			ctx:     testctx.New("a", 1, diag.Int("b.c", 2)),
+			want:    `[b@32473 c="2"][meta@32473 a="1"]`,
+		},
+		"long id with enterprise number": {
+			encoder: Encoder{EnterpriseID: "32473"},
+			ctx:     testctx.New(diag.Int(strings.Repeat("x", 30)+".a", 1)),
+			want:    `[` + strings.Repeat("x", 26) + `@32473 a="1"]`,
+		},
+		"merge default id": {
+			ctx:  testctx.New(diag.Int("ctx.foo", 1), diag.Int("bar", 2)),
+			want: `[ctx bar="2" foo="1"]`,
+		},
+		"merge sanitized ids": {
+			ctx:  testctx.New(diag.Int("a b.x", 1), diag.Int("a=b.y", 2), diag.Int("a_b.z", 3), diag.Int("a c.w", 4)),
+			want: `[a_b x="1" y="2" z="3"][a_c w="4"]`,
+		},
+		"value escaping": {
+			ctx:  testctx.New("a", `say "hi"`, "b", `back\slash`, "c", "[x]", "d", "ünï\xffcode"),
+			want: `[ctx a="say \"hi\"" b="back\\slash" c="[x\]" d="ünï` + "\ufffd" + `code"]`,
+		},
+		"name sanitization": {
+			ctx: testctx.New(
+				"a b", 1,
+				"c=d", 2,
+				`e"f]`, 3,
+				"ünï", 4,
+				strings.Repeat("x", 40), 5,
+			),
+			want: `[ctx a_b="1" c_d="2" e_f_="3" ` + strings.Repeat("x", 32) + `="5" __n__="4"]`,
+		},
+		"empty id segment": {
+			ctx:  testctx.New(diag.Int(".a", 1)),
+			want: `[_ a="1"]`,
+		},
+		"types": {
+			ctx: testctx.New(
+				diag.Float("f", math.NaN()),
+				diag.Duration("d", 1500*time.Millisecond),
+				diag.Timestamp("ts", time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)),
+				"err", errors.New("oops"),
+				"nil", nil,
+			),
+			want: `[ctx d="1.5s" err="oops" f="NaN" nil="" ts="2020-01-02T03:04:05Z"]`,
+		},
+	}
+
+	for name, test := range cases {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			got, err := test.encoder.AppendStructuredData(nil, test.ctx)
+			if err != nil {
+				t.Fatalf("encoding failed: %v", err)
+			}
+			if string(got) != test.want {
+				t.Errorf("got  %s\nwant %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMessage(t *testing.T) {
+	ts := time.Date(2003, 10, 11, 22, 14, 15, 3000000, time.UTC)
+
+	cases := map[string]struct {
+		msg  Message
+		want string
+	}{
+		"nil values": {
+			msg:  Message{Facility: FacilityKern, Severity: SeverityEmergency},
+			want: `<0>1 - - - - - -`,
+		},
+		"full message": {
+			msg: Message{
+				Facility:  FacilityLocal4,
+				Severity:  SeverityNotice,
+				Timestamp: ts,
+				Hostname:  "mymachine.example.com",
+				AppName:   "evntslog",
+				ProcID:    "1234",
+				MsgID:     "ID47",
+				Context:   testctx.New(diag.String("exampleSDID.iut", "3")),
+				Msg:       "An application event",
+			},
+			want: `<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog 1234 ID47 [exampleSDID iut="3"] ` +
+				"\xef\xbb\xbfAn application event",
+		},
+		"header sanitization": {
+			msg: Message{
+				Facility: FacilityUser,
+				Severity: SeverityError,
+				Hostname: "my host",
+				AppName:  strings.Repeat("a", 60),
+			},
+			want: `<11>1 - my_host ` + strings.Repeat("a", 48) + ` - - -`,
+		},
+		"timezone": {
+			msg: Message{
+				Facility:  FacilityUser,
+				Severity:  SeverityDebug,
+				Timestamp: time.Date(2003, 8, 24, 5, 14, 15, 0, time.FixedZone("", -7*3600)),
+			},
+			want: `<15>1 2003-08-24T05:14:15-07:00 - - - - -`,
+		},
+	}
+
+	for name, test := range cases {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			var enc Encoder
+			got, err := enc.AppendMessage(nil, &test.msg)
+			if err != nil {
+				t.Fatalf("encoding failed: %v", err)
+			}
+			if string(got) != test.want {
+				t.Errorf("got  %q\nwant %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMessageInvalidPriority(t *testing.T) {
+	var enc Encoder
+	if _, err := enc.AppendMessage(nil, &Message{Facility: FacilityLocal7 + 1}); err == nil {
+		t.Error("expected error for invalid facility")
+	}
+	if _, err := enc.AppendMessage(nil, &Message{Severity: SeverityDebug + 1}); err == nil {
+		t.Error("expected error for invalid severity")
+	}
+}
+
+func TestEncode(t *testing.T) {
+	msg := &Message{Facility: FacilityUser, Severity: SeverityInformational, Context: testctx.New("a", 1)}
+
+	t.Run("one write per message", func(t *testing.T) {
+		var w recordWriter
+		enc := NewEncoder(&w)
+		for i := 0; i < 2; i++ {
+			if err := enc.Encode(msg); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		want := []string{`<14>1 - - - - - [ctx a="1"]`, `<14>1 - - - - - [ctx a="1"]`}
+		if strings.Join(w.writes, "|") != strings.Join(want, "|") {
+			t.Errorf("got %q, want %q", w.writes, want)
+		}
+	})
+
+	t.Run("octet counting", func(t *testing.T) {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.OctetCounting = true
+		if err := enc.Encode(msg); err != nil {
+			t.Fatal(err)
+		}
+
+		want := `27 <14>1 - - - - - [ctx a="1"]`
+		if buf.String() != want {
+			t.Errorf("got %q, want %q", buf.String(), want)
+		}
+	})
+}
+
+type recordWriter struct {
+	writes []string
+}
+
+func (w *recordWriter) Write(p []byte) (int, error) {
+	w.writes = append(w.writes, string(p))
+	return len(p), nil
+}
@@ -0,0 +1,299 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+// Package yaml encodes diagnostic contexts as YAML documents.
+//
+// Fields with dotted keys are combined into nested mappings, as reported by
+// VisitStructured, e.g.
+//
+//	http:
+//	  method: GET
+//	  status: 200
+//	message: hello world
+//
+// Strings are quoted only if they would otherwise be read as another type,
+// or contain characters not allowed in plain scalars. Values of type
+// diag.IfcType are written in flow style, using their JSON encoding.
+package yaml
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/urso/diag"
+	"github.com/urso/diag/internal/jsonenc"
+)
+
+// Encoder writes diagnostic contexts as YAML documents.
+// An Encoder must not be used concurrently.
+type Encoder struct {
+	// TimeFormat configures the layout used to encode timestamps.
+	// time.RFC3339Nano is used if TimeFormat is empty.
+	TimeFormat string
+
+	// Durations configures the encoding of durations.
+	Durations DurationFormat
+
+	// Indent configures the number of spaces used to indent nested mappings.
+	// Two spaces are used if Indent is 0.
+	Indent int
+
+	w     io.Writer
+	buf   []byte
+	depth int
+}
+
+// DurationFormat selects the encoding of durations.
+type DurationFormat = jsonenc.DurationFormat
+
+const (
+	// DurationNanos encodes durations as integer nanoseconds.
+	DurationNanos = jsonenc.DurationNanos
+
+	// DurationString encodes durations as string, e.g. 1m30s.
+	DurationString = jsonenc.DurationString
+
+	// DurationSeconds encodes durations as floating point seconds.
+	DurationSeconds = jsonenc.DurationSeconds
+)
+
+// NewEncoder creates a new Encoder writing to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Marshal encodes the context as YAML document, using the default settings.
+func Marshal(ctx *diag.Context) ([]byte, error) {
+	var e Encoder
+	return e.Append(nil, ctx)
+}
+
+// Encode writes the context as YAML document to the writer configured with
+// NewEncoder. Each document is started with `---`, such that multiple
+// contexts can be written to the same stream.
+func (e *Encoder) Encode(ctx *diag.Context) error {
+	buf, err := e.Append(append(e.buf[:0], "---\n"...), ctx)
+	e.buf = buf[:0]
+	if err != nil {
+		return err
+	}
+
+	_, err = e.w.Write(buf)
+	return err
+}
+
+// Append appends the YAML encoded context to dst and returns the extended
+// buffer. An empty context is encoded as `{}`.
+func (e *Encoder) Append(dst []byte, ctx *diag.Context) ([]byte, error) {
+	scratch := e.buf
+	e.buf = dst
+	e.depth = 0
+
+	start := len(dst)
+	err := ctx.VisitStructured(e)
+	if err == nil && len(e.buf) == start {
+		e.buf = append(e.buf, "{}\n"...)
+	}
+
+	dst = e.buf
+	e.buf = scratch
+	return dst, err
+}
+
+// OnObjStart starts a nested mapping.
+func (e *Encoder) OnObjStart(key string) error {
+	e.appendKey(key)
+	e.buf = append(e.buf, '\n')
+	e.depth++
+	return nil
+}
+
+// OnObjEnd closes the current mapping.
+func (e *Encoder) OnObjEnd() error {
+	e.depth--
+	return nil
+}
+
+// OnValue writes a key: value pair to the current mapping.
+func (e *Encoder) OnValue(key string, v diag.Value) error {
+	e.appendKey(key)
+	e.buf = append(e.buf, ' ')
+	e.appendValue(v)
+	e.buf = append(e.buf, '\n')
+	return nil
+}
+
+func (e *Encoder) appendKey(key string) {
+	indent := e.Indent
+	if indent <= 0 {
+		indent = 2
+	}
+	for i := 0; i < e.depth*indent; i++ {
+		e.buf = append(e.buf, ' ')
+	}
+	e.buf = appendString(e.buf, key)
+	e.buf = append(e.buf, ':')
+}
+
+func (e *Encoder) appendValue(v diag.Value) {
+	if v.Reporter == nil {
+		e.buf = append(e.buf, "null"...)
+		return
+	}
+
+	switch v.Reporter.Type() {
+	case diag.BoolType:
+		e.buf = strconv.AppendBool(e.buf, v.Primitive != 0)
+	case diag.IntType, diag.Int64Type:
+		e.buf = strconv.AppendInt(e.buf, int64(v.Primitive), 10)
+	case diag.Uint64Type:
+		e.buf = strconv.AppendUint(e.buf, v.Primitive, 10)
+	case diag.Float64Type:
+		e.buf = appendFloat(e.buf, math.Float64frombits(v.Primitive))
+	case diag.DurationType:
+		e.appendDuration(time.Duration(v.Primitive))
+	case diag.StringType:
+		e.buf = appendString(e.buf, v.String)
+	case diag.TimestampType:
+		if ts, ok := v.Ifc.(time.Time); ok {
+			e.buf = appendString(e.buf, ts.Format(e.timeFormat()))
+			return
+		}
+		e.buf = appendAny(e.buf, v.Interface())
+	default:
+		e.buf = appendAny(e.buf, v.Interface())
+	}
+}
+
+func (e *Encoder) timeFormat() string {
+	if e.TimeFormat == "" {
+		return time.RFC3339Nano
+	}
+	return e.TimeFormat
+}
+
+func (e *Encoder) appendDuration(d time.Duration) {
+	switch e.Durations {
+	case DurationString:
+		e.buf = append(e.buf, d.String()...)
+	case DurationSeconds:
+		e.buf = appendFloat(e.buf, d.Seconds())
+	default:
+		e.buf = strconv.AppendInt(e.buf, int64(d), 10)
+	}
+}
+
+func appendFloat(b []byte, f float64) []byte {
+	switch {
+	case math.IsNaN(f):
+		return append(b, ".nan"...)
+	case math.IsInf(f, 1):
+		return append(b, ".inf"...)
+	case math.IsInf(f, -1):
+		return append(b, "-.inf"...)
+	default:
+		return jsonenc.AppendFloat(b, f)
+	}
+}
+
+// appendAny writes interface values in flow style. YAML flow style is a
+// superset of JSON, such that the JSON encoding of a value can be used as is.
+func appendAny(b []byte, v interface{}) []byte {
+	switch x := v.(type) {
+	case nil:
+		return append(b, "null"...)
+	case json.Marshaler:
+		// written as flow style value below, even if v is an error
+	case error:
+		return appendString(b, x.Error())
+	}
+
+	tmp, err := json.Marshal(v)
+	if err != nil {
+		return appendString(b, fmt.Sprint(v))
+	}
+	return append(b, tmp...)
+}
+
+// appendString writes s as plain scalar if possible, or as double quoted
+// string otherwise.
+func appendString(b []byte, s string) []byte {
+	if needsQuoting(s) {
+		return jsonenc.AppendString(b, s)
+	}
+	return append(b, s...)
+}
+
+func needsQuoting(s string) bool {
+	if s == "" || isReserved(s) || looksNumeric(s) {
+		return true
+	}
+
+	switch s[0] {
+	case '-', '?', ':', ',', '[', ']', '{', '}', '#', '&', '*', '!', '|',
+		'>', '\'', '"', '%', '@', '`', ' ':
+		return true
+	}
+	if s[len(s)-1] == ' ' || s[len(s)-1] == ':' {
+		return true
+	}
+
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			if c < ' ' || c == 0x7f {
+				return true
+			}
+			if c == ':' && i+1 < len(s) && s[i+1] == ' ' {
+				return true
+			}
+			if c == '#' && s[i-1] == ' ' {
+				return true
+			}
+			i++
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 || r == '\u2028' || r == '\u2029' || r == '\ufeff' {
+			return true
+		}
+		i += size
+	}
+	return false
+}
+
+// isReserved checks if s would be read as bool or null, by YAML 1.1 or YAML
+// 1.2 parsers.
+func isReserved(s string) bool {
+	switch strings.ToLower(s) {
+	case "null", "~", "true", "false", "yes", "no", "on", "off", "y", "n",
+		".nan", ".inf", "-.inf", "+.inf":
+		return true
+	}
+	return false
+}
+
+// looksNumeric checks if s would be read as number.
+func looksNumeric(s string) bool {
+	c := s[0]
+	if c != '+' && c != '-' && c != '.' && (c < '0' || c > '9') {
+		return false
+	}
+	if _, err := strconv.ParseFloat(strings.Replace(s, "_", "", -1), 64); err == nil {
+		return true
+	}
+	if _, err := strconv.ParseInt(s, 0, 64); err == nil {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,165 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+package yaml
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/urso/diag"
+	"github.com/urso/diag/internal/testctx"
+)
+
+func TestEncoder(t *testing.T) {
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+
+	cases := map[string]struct {
+		encoder Encoder
+		ctx     *diag.Context
+		want    string
+	}{
+		"empty": {
+			ctx:  testctx.New(),
+			want: "{}\n",
+		},
+		"primitives": {
+			ctx: testctx.New(
+				diag.Bool("b", true),
+				diag.Int("i", -1),
+				diag.Uint64("u", math.MaxUint64),
+				diag.Float("f", 1.5),
+				diag.String("s", "hello world"),
+			),
+			want: "b: true\nf: 1.5\ni: -1\ns: hello world\nu: 18446744073709551615\n",
+		},
+		"nested": {
+			ctx: testctx.New(
+				diag.String("http.request.method", "GET"),
+				diag.Int("http.response.status", 200),
+				diag.String("message", "done"),
+			),
+			want: "" +
+				"http:\n" +
+				"  request:\n" +
+				"    method: GET\n" +
+				"  response:\n" +
+				"    status: 200\n" +
+				"message: done\n",
+		},
+		"custom indent": {
+			encoder: Encoder{Indent: 4},
+			ctx:     testctx.New(diag.String("a.b", "c")),
+			want:    "a:\n    b: c\n",
+		},
+		"quoting": {
+			ctx: testctx.New(
+				diag.String("a", ""),
+				diag.String("b", "true"),
+				diag.String("c", "No"),
+				diag.String("d", "123"),
+				diag.String("e", "1e3"),
+				diag.String("f", "- item"),
+				diag.String("g", "key: value"),
+				diag.String("h", "line\nbreak"),
+				diag.String("i", "text # comment"),
+				diag.String("j", "null"),
+				diag.String("k", "ünïcode"),
+				diag.String("l", "trailing "),
+				diag.String("m", "a:b"),
+			),
+			want: "" +
+				"a: \"\"\n" +
+				"b: \"true\"\n" +
+				"c: \"No\"\n" +
+				"d: \"123\"\n" +
+				"e: \"1e3\"\n" +
+				"f: \"- item\"\n" +
+				"g: \"key: value\"\n" +
+				"h: \"line\\nbreak\"\n" +
+				"i: \"text # comment\"\n" +
+				"j: \"null\"\n" +
+				"k: ünïcode\n" +
+				"l: \"trailing \"\n" +
+				"m: a:b\n",
+		},
+		"quoted keys": {
+			ctx:  testctx.New(diag.String("@timestamp", "x"), diag.String("key: x", "y")),
+			want: "\"@timestamp\": x\n\"key: x\": \"y\"\n",
+		},
+		"non-finite floats": {
+			ctx:  testctx.New(diag.Float("a", math.NaN()), diag.Float("b", math.Inf(1)), diag.Float("c", math.Inf(-1))),
+			want: "a: .nan\nb: .inf\nc: -.inf\n",
+		},
+		"timestamps": {
+			ctx:  testctx.New(diag.Timestamp("ts", ts)),
+			want: "ts: 2020-01-02T03:04:05.000000006Z\n",
+		},
+		"custom time format": {
+			encoder: Encoder{TimeFormat: "2006-01-02 15:04"},
+			ctx:     testctx.New(diag.Timestamp("ts", ts)),
+			want:    "ts: 2020-01-02 03:04\n",
+		},
+		"durations": {
+			ctx:  testctx.New(diag.Duration("d", 1500*time.Millisecond)),
+			want: "d: 1500000000\n",
+		},
+		"durations as string": {
+			encoder: Encoder{Durations: DurationString},
+			ctx:     testctx.New(diag.Duration("d", 1500*time.Millisecond)),
+			want:    "d: 1.5s\n",
+		},
+		"durations as seconds": {
+			encoder: Encoder{Durations: DurationSeconds},
+			ctx:     testctx.New(diag.Duration("d", 1500*time.Millisecond)),
+			want:    "d: 1.5\n",
+		},
+		"interface values": {
+			ctx: testctx.New(
+				"err", errors.New("file: not found"),
+				"nil", nil,
+				"list", []int{1, 2},
+				"obj", map[string]string{"a": "b"},
+			),
+			want: "" +
+				"err: \"file: not found\"\n" +
+				"list: [1,2]\n" +
+				"nil: null\n" +
+				"obj: {\"a\":\"b\"}\n",
+		},
+	}
+
+	for name, test := range cases {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			got, err := test.encoder.Append(nil, test.ctx)
+			if err != nil {
+				t.Fatalf("encoding failed: %v", err)
+			}
+			if string(got) != test.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestEncodeWriter(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	for i := 0; i < 2; i++ {
+		if err := enc.Encode(testctx.New(diag.Int("a.b", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := "---\na:\n  b: 0\n---\na:\n  b: 1\n"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
@@ -42,3 +42,24 @@ func TestFieldValues(t *testing.T) {
 		})
 	}
 }
+
+func TestFieldTypes(t *testing.T) {
+	cases := map[diag.Field]diag.Type{
+		diag.Bool("b", true):                diag.BoolType,
+		diag.Int("i", -23):                  diag.IntType,
+		diag.Int64("i", -42):                diag.Int64Type,
+		diag.Uint("i", 23):                  diag.Uint64Type,
+		diag.Uint64("i", 23):                diag.Uint64Type,
+		diag.Float("f", 3.14):               diag.Float64Type,
+		diag.String("hello", "world"):       diag.StringType,
+		diag.Duration("d", time.Second):     diag.DurationType,
+		diag.Timestamp("ts", time.Time{}):   diag.TimestampType,
+		diag.Any("any", struct{ A int }{1}): diag.IfcType,
+	}
+
+	for field, want := range cases {
+		if got := field.Value.Reporter.Type(); got != want {
+			t.Errorf("field %v: type missmatch, want %v, got %v", field.Key, want, got)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+// Package jsonenc provides a low level JSON writer, shared by the diag
+// package and the encoders in diag/encoding.
+package jsonenc
+
+import (
+	"math"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// Writer appends JSON tokens to Buf. Separators between object fields are
+// inserted automatically.
+type Writer struct {
+	Buf []byte
+
+	needSep bool
+}
+
+const hexDigits = "0123456789abcdef"
+
+// Reset clears the buffer and the writer state.
+func (w *Writer) Reset() {
+	w.Buf = w.Buf[:0]
+	w.needSep = false
+}
+
+// BeginObject starts a new object. The object is added as a field if Key has
+// been called before.
+func (w *Writer) BeginObject() {
+	w.Buf = append(w.Buf, '{')
+	w.needSep = false
+}
+
+// EndObject closes the current object.
+func (w *Writer) EndObject() {
+	w.Buf = append(w.Buf, '}')
+	w.needSep = true
+}
+
+// Key writes the field name of the next value.
+func (w *Writer) Key(k string) {
+	if w.needSep {
+		w.Buf = append(w.Buf, ',')
+	}
+	w.Buf = AppendString(w.Buf, k)
+	w.Buf = append(w.Buf, ':')
+	w.needSep = false
+}
+
+// Null writes a null value.
+func (w *Writer) Null() {
+	w.Buf = append(w.Buf, "null"...)
+	w.needSep = true
+}
+
+// Bool writes a boolean value.
+func (w *Writer) Bool(b bool) {
+	w.Buf = strconv.AppendBool(w.Buf, b)
+	w.needSep = true
+}
+
+// Int writes a signed integer.
+func (w *Writer) Int(i int64) {
+	w.Buf = strconv.AppendInt(w.Buf, i, 10)
+	w.needSep = true
+}
+
+// Uint writes an unsigned integer.
+func (w *Writer) Uint(u uint64) {
+	w.Buf = strconv.AppendUint(w.Buf, u, 10)
+	w.needSep = true
+}
+
+// Float writes a floating point number. The caller must ensure that f is
+// finite.
+func (w *Writer) Float(f float64) {
+	w.Buf = AppendFloat(w.Buf, f)
+	w.needSep = true
+}
+
+// String writes a quoted string.
+func (w *Writer) String(s string) {
+	w.Buf = AppendString(w.Buf, s)
+	w.needSep = true
+}
+
+// Time writes the timestamp as quoted RFC3339 string with nanosecond precision.
+func (w *Writer) Time(t time.Time) {
+	w.Buf = append(w.Buf, '"')
+	w.Buf = t.AppendFormat(w.Buf, time.RFC3339Nano)
+	w.Buf = append(w.Buf, '"')
+	w.needSep = true
+}
+
+// Raw writes an already encoded JSON value.
+func (w *Writer) Raw(b []byte) {
+	w.Buf = append(w.Buf, b...)
+	w.needSep = true
+}
+
+// AppendFloat appends the shortest representation of f, using the same
+// format as encoding/json.
+func AppendFloat(b []byte, f float64) []byte {
+	abs := math.Abs(f)
+	format := byte('f')
+	if abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+
+	b = strconv.AppendFloat(b, f, format, -1, 64)
+	if format == 'e' {
+		// clean up e-09 to e-9
+		n := len(b)
+		if n >= 4 && b[n-4] == 'e' && b[n-3] == '-' && b[n-2] == '0' {
+			b[n-2] = b[n-1]
+			b = b[:n-1]
+		}
+	}
+	return b
+}
+
+// AppendString appends the quoted and escaped string s to b. Invalid UTF-8
+// is replaced with the unicode replacement character.
+func AppendString(b []byte, s string) []byte {
+	b = append(b, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if c := s[i]; c < utf8.RuneSelf {
+			if c >= 0x20 && c != '"' && c != '\\' {
+				i++
+				continue
+			}
+
+			b = append(b, s[start:i]...)
+			switch c {
+			case '"', '\\':
+				b = append(b, '\\', c)
+			case '\n':
+				b = append(b, '\\', 'n')
+			case '\r':
+				b = append(b, '\\', 'r')
+			case '\t':
+				b = append(b, '\\', 't')
+			default:
+				b = append(b, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xf])
+			}
+			i++
+			start = i
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			b = append(b, s[start:i]...)
+			b = append(b, `\ufffd`...)
+			i += size
+			start = i
+			continue
+		}
+
+		// U+2028 and U+2029 are valid JSON, but break JavaScript parsers.
+		if r == '\u2028' || r == '\u2029' {
+			b = append(b, s[start:i]...)
+			b = append(b, '\\', 'u', '2', '0', '2', hexDigits[r&0xf])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	b = append(b, s[start:]...)
+	return append(b, '"')
+}
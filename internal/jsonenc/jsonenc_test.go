@@ -0,0 +1,72 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+package jsonenc
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestAppendString(t *testing.T) {
+	cases := []string{
+		"",
+		"hello world",
+		"quote \" and backslash \\",
+		"new\nline\ttab\rreturn",
+		"control \x00 \x1f \x7f",
+		"unicode äöü 日本語 😀",
+		"separators \u2028 \u2029",
+	}
+
+	for _, s := range cases {
+		got := string(AppendString(nil, s))
+		want, _ := json.Marshal(s)
+		if got != string(want) {
+			t.Errorf("AppendString(%q) = %s, want %s", s, got, want)
+		}
+	}
+
+	if got, want := string(AppendString(nil, "bad \xff")), `"bad \ufffd"`; got != want {
+		t.Errorf("AppendString with invalid UTF-8 = %s, want %s", got, want)
+	}
+}
+
+func TestAppendFloat(t *testing.T) {
+	cases := []float64{0, 1, -1, 1.5, 1e20, 1e21, 1e-6, 1e-7, 123456789.125, math.MaxFloat64, math.SmallestNonzeroFloat64}
+
+	for _, f := range cases {
+		got := string(AppendFloat(nil, f))
+		want, _ := json.Marshal(f)
+		if got != string(want) {
+			t.Errorf("AppendFloat(%v) = %s, want %s", f, got, want)
+		}
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var w Writer
+	w.BeginObject()
+	w.Key("a")
+	w.Int(-1)
+	w.Key("b")
+	w.BeginObject()
+	w.Key("c")
+	w.Bool(true)
+	w.Key("d")
+	w.Null()
+	w.EndObject()
+	w.Key("e")
+	w.String("x")
+	w.Key("f")
+	w.Uint(2)
+	w.EndObject()
+
+	if got, want := string(w.Buf), `{"a":-1,"b":{"c":true,"d":null},"e":"x","f":2}`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
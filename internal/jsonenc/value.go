@@ -0,0 +1,146 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+package jsonenc
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Kind identifies how the slots of a value passed to Encoder.Value are
+// interpreted. The kinds mirror diag.Type, such that a diag.Type can be
+// converted to a Kind.
+type Kind uint8
+
+const (
+	KindAny Kind = iota
+	KindBool
+	KindInt
+	KindInt64
+	KindUint64
+	KindFloat64
+	KindDuration
+	KindTimestamp
+	KindString
+)
+
+// DurationFormat selects the encoding of durations. The type is shared by all
+// encoders, such that the zero value encodes durations as integer nanoseconds
+// in every format.
+type DurationFormat uint8
+
+const (
+	DurationNanos DurationFormat = iota
+	DurationString
+	DurationSeconds
+)
+
+// NonFinitePolicy selects the encoding of NaN and infinite floating point
+// numbers.
+type NonFinitePolicy uint8
+
+const (
+	NonFiniteNull NonFinitePolicy = iota
+	NonFiniteString
+	NonFiniteError
+)
+
+// Encoder writes the fields of a diagnostic context. The zero value encodes
+// durations as nanoseconds and non-finite floats as null.
+type Encoder struct {
+	Writer
+
+	Durations DurationFormat
+	NonFinite NonFinitePolicy
+}
+
+// Value writes a field. Primitive values are read from prim, strings from s.
+// The value of all other kinds is obtained by calling decode.
+func (e *Encoder) Value(key string, kind Kind, prim uint64, s string, decode func() interface{}) error {
+	e.Key(key)
+
+	switch kind {
+	case KindBool:
+		e.Bool(prim != 0)
+	case KindInt, KindInt64:
+		e.Int(int64(prim))
+	case KindUint64:
+		e.Uint(prim)
+	case KindFloat64:
+		return e.encodeFloat(key, math.Float64frombits(prim))
+	case KindDuration:
+		return e.encodeDuration(key, time.Duration(prim))
+	case KindString:
+		e.String(s)
+	default:
+		return e.encodeAny(decode())
+	}
+	return nil
+}
+
+func (e *Encoder) encodeFloat(key string, f float64) error {
+	if !math.IsNaN(f) && !math.IsInf(f, 0) {
+		e.Float(f)
+		return nil
+	}
+
+	switch e.NonFinite {
+	case NonFiniteString:
+		switch {
+		case math.IsNaN(f):
+			e.String("NaN")
+		case f > 0:
+			e.String("+Inf")
+		default:
+			e.String("-Inf")
+		}
+	case NonFiniteError:
+		return fmt.Errorf("json: unsupported value %v for field %q", f, key)
+	default:
+		e.Null()
+	}
+	return nil
+}
+
+func (e *Encoder) encodeDuration(key string, d time.Duration) error {
+	switch e.Durations {
+	case DurationString:
+		e.String(d.String())
+	case DurationSeconds:
+		return e.encodeFloat(key, d.Seconds())
+	default:
+		e.Int(int64(d))
+	}
+	return nil
+}
+
+// encodeAny encodes values of unknown type. Timestamps are encoded as RFC3339
+// strings, errors without custom JSON encoding as error message. All other
+// values are encoded using encoding/json.
+func (e *Encoder) encodeAny(v interface{}) error {
+	switch val := v.(type) {
+	case nil:
+		e.Null()
+		return nil
+	case time.Time:
+		e.Time(val)
+		return nil
+	case json.Marshaler:
+	case error:
+		e.String(val.Error())
+		return nil
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	e.Raw(b)
+	return nil
+}
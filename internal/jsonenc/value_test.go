@@ -0,0 +1,33 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+package jsonenc_test
+
+import (
+	"testing"
+
+	"github.com/urso/diag"
+	"github.com/urso/diag/internal/jsonenc"
+)
+
+func TestKindMatchesType(t *testing.T) {
+	kinds := map[diag.Type]jsonenc.Kind{
+		diag.IfcType:       jsonenc.KindAny,
+		diag.BoolType:      jsonenc.KindBool,
+		diag.IntType:       jsonenc.KindInt,
+		diag.Int64Type:     jsonenc.KindInt64,
+		diag.Uint64Type:    jsonenc.KindUint64,
+		diag.Float64Type:   jsonenc.KindFloat64,
+		diag.DurationType:  jsonenc.KindDuration,
+		diag.TimestampType: jsonenc.KindTimestamp,
+		diag.StringType:    jsonenc.KindString,
+	}
+	for typ, kind := range kinds {
+		if jsonenc.Kind(typ) != kind {
+			t.Errorf("type %v maps to kind %v, want %v", typ, jsonenc.Kind(typ), kind)
+		}
+	}
+}
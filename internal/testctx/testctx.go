@@ -0,0 +1,19 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+// Package testctx builds diagnostic contexts for the tests of the encoders
+// in diag/encoding.
+package testctx
+
+import "github.com/urso/diag"
+
+// New creates a context holding the fields. Fields are passed as diag.Field
+// values or key value pairs, as accepted by diag.Context.AddAll.
+func New(fields ...interface{}) *diag.Context {
+	ctx := diag.NewContext(nil, nil)
+	ctx.AddAll(fields...)
+	return ctx
+}
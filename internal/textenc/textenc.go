@@ -0,0 +1,54 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+// Package textenc formats values as plain text, shared by the encoders in
+// diag/encoding that write values into text cells or parameters.
+package textenc
+
+import (
+	"encoding"
+	"fmt"
+	"math"
+
+	"github.com/urso/diag/internal/jsonenc"
+)
+
+// FormatFloat formats f like a JSON number. NaN and infinite values are
+// formatted as "NaN", "+Inf", and "-Inf".
+func FormatFloat(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	default:
+		return string(jsonenc.AppendFloat(nil, f))
+	}
+}
+
+// FormatAny formats values of unknown type. Errors are formatted by their
+// error message, and nil as empty string. Values implementing
+// encoding.TextMarshaler or fmt.Stringer use their custom text encoding.
+func FormatAny(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case error:
+		return x.Error()
+	case encoding.TextMarshaler:
+		text, err := x.MarshalText()
+		if err != nil {
+			return fmt.Sprintf("!ERROR(%v)", err)
+		}
+		return string(text)
+	case fmt.Stringer:
+		return x.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
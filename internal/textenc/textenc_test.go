@@ -0,0 +1,51 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+package textenc
+
+import (
+	"errors"
+	"math"
+	"net"
+	"testing"
+)
+
+type badText struct{}
+
+func (badText) MarshalText() ([]byte, error) { return nil, errors.New("bad") }
+
+func TestFormatFloat(t *testing.T) {
+	cases := map[float64]string{
+		1.5:          "1.5",
+		1e21:         "1e+21",
+		math.NaN():   "NaN",
+		math.Inf(1):  "+Inf",
+		math.Inf(-1): "-Inf",
+	}
+	for f, want := range cases {
+		if got := FormatFloat(f); got != want {
+			t.Errorf("FormatFloat(%v) = %q, want %q", f, got, want)
+		}
+	}
+}
+
+func TestFormatAny(t *testing.T) {
+	cases := []struct {
+		in   interface{}
+		want string
+	}{
+		{nil, ""},
+		{errors.New("oops"), "oops"},
+		{net.IPv4(127, 0, 0, 1), "127.0.0.1"},
+		{badText{}, "!ERROR(bad)"},
+		{[]int{1, 2}, "[1 2]"},
+	}
+	for _, test := range cases {
+		if got := FormatAny(test.in); got != test.want {
+			t.Errorf("FormatAny(%v) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
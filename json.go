@@ -0,0 +1,48 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+package diag
+
+import "github.com/urso/diag/internal/jsonenc"
+
+// jsonVisitor encodes a context as JSON, using the default settings of the
+// diag/encoding/json package.
+type jsonVisitor struct {
+	w jsonenc.Encoder
+}
+
+// MarshalJSON encodes the context as nested JSON object. Durations are
+// encoded as nanoseconds, and NaN or infinite floats as null.
+// Use diag/encoding/json for more control over the encoding.
+func (c *Context) MarshalJSON() ([]byte, error) {
+	var v jsonVisitor
+	v.w.BeginObject()
+	if err := c.VisitStructured(&v); err != nil {
+		return nil, err
+	}
+	v.w.EndObject()
+	return v.w.Buf, nil
+}
+
+func (v *jsonVisitor) OnObjStart(key string) error {
+	v.w.Key(key)
+	v.w.BeginObject()
+	return nil
+}
+
+func (v *jsonVisitor) OnObjEnd() error {
+	v.w.EndObject()
+	return nil
+}
+
+func (v *jsonVisitor) OnValue(key string, val Value) error {
+	if val.Reporter == nil {
+		v.w.Key(key)
+		v.w.Null()
+		return nil
+	}
+	return v.w.Value(key, jsonenc.Kind(val.Reporter.Type()), val.Primitive, val.String, val.Interface)
+}
@@ -0,0 +1,36 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+package diag_test
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/urso/diag"
+)
+
+func TestContextMarshalJSON(t *testing.T) {
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+	ctx := makeCtx(nil, nil,
+		diag.String("a.b", "x"),
+		diag.Int("a.c", 1),
+		diag.Duration("took", time.Second),
+		diag.Timestamp("ts", ts),
+		diag.Float("nan", math.NaN()),
+		"err", errors.New("oops"),
+		"list", []int{1, 2},
+	)
+
+	b, err := json.Marshal(ctx)
+	requireNoError(t, err)
+
+	want := `{"a":{"b":"x","c":1},"err":"oops","list":[1,2],"nan":null,"took":1000000000,"ts":"2020-01-02T03:04:05.000000006Z"}`
+	requireEqual(t, want, string(b))
+}
@@ -0,0 +1,143 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+package diag
+
+import "strings"
+
+// PathVisitor can be used to iterate all fields in a context, receiving the
+// unescaped path segments of each field its key.
+// Use with (*Context).VisitPaths.
+type PathVisitor interface {
+	// OnPath is called for every field. The path is only valid during the
+	// call, and must be copied if it is retained.
+	OnPath(path []string, v Value) error
+}
+
+// AddPath adds a new field to the context. The key is build from the path
+// segments, escaping dots and backslashes within segments, such that
+// segments containing dots are reported as a single object or field name by
+// VisitStructured.
+func (c *Context) AddPath(path []string, value Value) {
+	c.Add(JoinPath(path...), value)
+}
+
+// VisitPaths reports all fields in the context to the visitor, passing the
+// unescaped segments of each key.
+func (c *Context) VisitPaths(v PathVisitor) error {
+	var raw, path []string
+	return c.VisitKeyValues(pathVisitor(func(key string, val Value) error {
+		raw = splitKey(raw[:0], key)
+		path = path[:0]
+		for _, seg := range raw {
+			path = append(path, UnescapeKey(seg))
+		}
+		return v.OnPath(path, val)
+	}))
+}
+
+// JoinPath builds a key from path segments. Dots and backslashes in segments
+// are escaped with a backslash.
+func JoinPath(path ...string) string {
+	switch len(path) {
+	case 0:
+		return ""
+	case 1:
+		return EscapeKey(path[0])
+	}
+
+	var sb strings.Builder
+	for i, seg := range path {
+		if i > 0 {
+			sb.WriteByte('.')
+		}
+		sb.WriteString(EscapeKey(seg))
+	}
+	return sb.String()
+}
+
+// SplitPath splits a key into its unescaped path segments.
+func SplitPath(key string) []string {
+	path := splitKey(nil, key)
+	for i, seg := range path {
+		path[i] = UnescapeKey(seg)
+	}
+	return path
+}
+
+// EscapeKey escapes dots and backslashes in a single key segment.
+func EscapeKey(s string) string {
+	if !strings.ContainsAny(s, `.\`) {
+		return s
+	}
+
+	var sb strings.Builder
+	sb.Grow(len(s) + 2)
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c == '.' || c == '\\' {
+			sb.WriteByte('\\')
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
+
+// UnescapeKey removes the escaping of dots and backslashes from a single key
+// segment. Only the sequences `\.` and `\\` are unescaped. All other
+// backslashes are kept as is, such that keys like `C:\temp` are not modified.
+func UnescapeKey(s string) string {
+	if !strings.Contains(s, `\.`) && !strings.Contains(s, `\\`) {
+		return s
+	}
+
+	var sb strings.Builder
+	sb.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '\\' && i+1 < len(s) && (s[i+1] == '.' || s[i+1] == '\\') {
+			i++
+			c = s[i]
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
+
+// splitKey appends the escaped segments of key to segs. Dots preceded by a
+// backslash do not separate segments.
+func splitKey(segs []string, key string) []string {
+	start := 0
+	for i := 0; i < len(key); i++ {
+		switch key[i] {
+		case '\\':
+			i++ // skip escaped character
+		case '.':
+			segs = append(segs, key[start:i])
+			start = i + 1
+		}
+	}
+	return append(segs, key[start:])
+}
+
+// hasPathPrefix checks if the path segments in prefix are the parents of
+// the path segments in segs.
+func hasPathPrefix(segs, prefix []string) bool {
+	if len(segs) <= len(prefix) {
+		return false
+	}
+	for i := range prefix {
+		if segs[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+type pathVisitor func(key string, v Value) error
+
+func (pathVisitor) OnObjStart(_ string) error            { return nil }
+func (pathVisitor) OnObjEnd() error                      { return nil }
+func (fn pathVisitor) OnValue(key string, v Value) error { return fn(key, v) }
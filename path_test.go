@@ -0,0 +1,128 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+package diag_test
+
+import (
+	"testing"
+
+	"github.com/urso/diag"
+)
+
+func TestPathEscaping(t *testing.T) {
+	cases := map[string]struct {
+		path []string
+		key  string
+	}{
+		"single segment":     {path: []string{"key"}, key: "key"},
+		"nested":             {path: []string{"a", "b", "c"}, key: "a.b.c"},
+		"dotted segment":     {path: []string{"hosts", "db.example.com"}, key: `hosts.db\.example\.com`},
+		"backslash":          {path: []string{`a\b`, "c"}, key: `a\\b.c`},
+		"trailing backslash": {path: []string{`a\`, "b"}, key: `a\\.b`},
+		"empty segments":     {path: []string{"", "a", ""}, key: ".a."},
+	}
+
+	for name, test := range cases {
+		t.Run(name, func(t *testing.T) {
+			key := diag.JoinPath(test.path...)
+			requireEqual(t, test.key, key)
+			requireEqual(t, test.path, diag.SplitPath(key))
+		})
+	}
+}
+
+func TestEscapeKey(t *testing.T) {
+	for _, s := range []string{"", "plain", "a.b", `a\b`, `\.`, `..\\`} {
+		requireEqual(t, s, diag.UnescapeKey(diag.EscapeKey(s)), s)
+	}
+}
+
+func TestUnescapeKey(t *testing.T) {
+	cases := map[string]string{
+		`plain`:     `plain`,
+		`a\.b`:      `a.b`,
+		`a\\b`:      `a\b`,
+		`C:\temp`:   `C:\temp`,
+		`\n\t`:      `\n\t`,
+		`trailing\`: `trailing\`,
+		`a\\\.b`:    `a\.b`,
+	}
+
+	for in, want := range cases {
+		requireEqual(t, want, diag.UnescapeKey(in), in)
+	}
+}
+
+func TestCtxUnescapedBackslashes(t *testing.T) {
+	ctx := makeCtx(nil, nil, diag.String(`C:\temp`, "x"))
+	assertCtx(t, map[string]interface{}{`C:\temp`: "x"}, ctx)
+	assertFlatCtx(t, map[string]interface{}{`C:\temp`: "x"}, ctx)
+}
+
+func TestCtxAddPath(t *testing.T) {
+	ctx := diag.NewContext(nil, nil)
+	ctx.AddPath([]string{"hosts", "db.example.com", "up"}, diag.ValBool(true))
+	ctx.AddPath([]string{"hosts", "web.example.com", "up"}, diag.ValBool(false))
+	ctx.AddPath([]string{"hosts", "count"}, diag.ValInt(2))
+	ctx.AddPath([]string{"version.major"}, diag.ValInt(1))
+
+	assertCtx(t, map[string]interface{}{
+		"hosts": map[string]interface{}{
+			"count":           2,
+			"db.example.com":  map[string]interface{}{"up": true},
+			"web.example.com": map[string]interface{}{"up": false},
+		},
+		"version.major": 1,
+	}, ctx)
+
+	assertFlatCtx(t, map[string]interface{}{
+		`hosts.count`:                2,
+		`hosts.db\.example\.com.up`:  true,
+		`hosts.web\.example\.com.up`: false,
+		`version\.major`:             1,
+	}, ctx)
+}
+
+func TestCtxVisitPaths(t *testing.T) {
+	ctx := makeCtx(nil, nil,
+		diag.String("a.b", "x"),
+		diag.Int(`hosts.db\.example\.com`, 1))
+
+	var got [][]string
+	err := ctx.VisitPaths(pathVisitorFunc(func(path []string, _ diag.Value) error {
+		got = append(got, append([]string(nil), path...))
+		return nil
+	}))
+	requireNoError(t, err)
+
+	requireEqual(t, [][]string{
+		{"a", "b"},
+		{"hosts", "db.example.com"},
+	}, got)
+}
+
+func TestCtxVisitStructuredEscapedSiblings(t *testing.T) {
+	ctx := makeCtx(nil, nil,
+		diag.Int("a.b", 1),
+		diag.Int(`a.b\.c`, 2),
+		diag.Int(`a\.b.c`, 3),
+		diag.Int("a.d", 4))
+
+	assertCtx(t, map[string]interface{}{
+		"a": map[string]interface{}{
+			"b":   1,
+			"b.c": 2,
+			"d":   4,
+		},
+		"a.b": map[string]interface{}{
+			"c": 3,
+		},
+	}, ctx)
+}
+
+type pathVisitorFunc func(path []string, v diag.Value) error
+
+func (fn pathVisitorFunc) OnPath(path []string, v diag.Value) error { return fn(path, v) }
@@ -100,7 +100,7 @@ type uint64Reporter struct{}
 
 var _uint64Reporter Reporter = uint64Reporter{}
 
-func (uint64Reporter) Type() Type                           { return Int64Type }
+func (uint64Reporter) Type() Type                           { return Uint64Type }
 func (uint64Reporter) Ifc(v *Value, fn func(v interface{})) { fn(uint64(v.Primitive)) }
 
 // ValFloat creates a new Value representing a float.
@@ -0,0 +1,426 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+package diag
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Binary wire format
+//
+// The binary encoding of a context is a flat list of the fields reported by
+// VisitKeyValues. All integers are varint encoded, signed integers using
+// zig-zag encoding.
+//
+//    message := magic version dict fields
+//    magic   := 'd' 'g'
+//    version := byte
+//    dict    := count { len bytes }              // key segments
+//    fields  := count { key tag len payload }
+//    key     := count { segment-index }
+//    tag     := byte                             // bit 7: standardized, bits 0-6: wire type
+//
+// Keys are split at unescaped dots and every segment is stored only once in
+// the dictionary. Each field stores the length of its payload, such that
+// decoders can skip fields of unknown wire types.
+
+const (
+	wireMagic0  = 'd'
+	wireMagic1  = 'g'
+	wireVersion = 1
+
+	wireStdFlag  = 0x80
+	wireTypeMask = 0x7f
+)
+
+// wire types. Values must not be changed or reused.
+const (
+	wireNil       = 0
+	wireBool      = 1
+	wireInt       = 2
+	wireInt64     = 3
+	wireUint64    = 4
+	wireFloat64   = 5
+	wireDuration  = 6
+	wireTimestamp = 7
+	wireString    = 8
+	wireJSON      = 9  // any value encoded as JSON
+	wireError     = 10 // error message
+)
+
+var (
+	errWireMagic     = errors.New("diag: invalid binary encoding")
+	errWireTruncated = errors.New("diag: truncated binary encoding")
+	errWireKey       = errors.New("diag: invalid key in binary encoding")
+	errWireValue     = errors.New("diag: invalid value in binary encoding")
+)
+
+// MarshalBinary encodes the context into a compact binary format, that
+// preserves value types and the Standardized flag of each field.
+// Values of type IfcType are encoded as JSON, errors as their error message.
+// Values that can not be encoded as JSON are encoded as string.
+func (c *Context) MarshalBinary() ([]byte, error) {
+	view := newView(c, c.mode&fieldsClosure == 0)
+
+	var fields []*Field
+	view.eachField(func(fld *Field) error {
+		fields = append(fields, fld)
+		return nil
+	})
+
+	var dict []string
+	segIdx := map[string]int{}
+	keys := make([][]int, len(fields))
+	for i, fld := range fields {
+		segs := splitKey(nil, fld.Key)
+		key := make([]int, len(segs))
+		for j, seg := range segs {
+			idx, exists := segIdx[seg]
+			if !exists {
+				idx = len(dict)
+				segIdx[seg] = idx
+				dict = append(dict, seg)
+			}
+			key[j] = idx
+		}
+		keys[i] = key
+	}
+
+	buf := []byte{wireMagic0, wireMagic1, wireVersion}
+	buf = appendUvarint(buf, uint64(len(dict)))
+	for _, seg := range dict {
+		buf = appendUvarint(buf, uint64(len(seg)))
+		buf = append(buf, seg...)
+	}
+
+	var payload []byte
+	buf = appendUvarint(buf, uint64(len(fields)))
+	for i, fld := range fields {
+		buf = appendUvarint(buf, uint64(len(keys[i])))
+		for _, idx := range keys[i] {
+			buf = appendUvarint(buf, uint64(idx))
+		}
+
+		var tag byte
+		tag, payload = appendWireValue(payload[:0], fld.Value)
+		if fld.Standardized {
+			tag |= wireStdFlag
+		}
+		buf = append(buf, tag)
+		buf = appendUvarint(buf, uint64(len(payload)))
+		buf = append(buf, payload...)
+	}
+
+	return buf, nil
+}
+
+func appendWireValue(buf []byte, val Value) (byte, []byte) {
+	if val.Reporter == nil {
+		return wireNil, buf
+	}
+
+	switch val.Reporter.Type() {
+	case BoolType:
+		if val.Primitive != 0 {
+			return wireBool, append(buf, 1)
+		}
+		return wireBool, append(buf, 0)
+	case IntType:
+		return wireInt, appendVarint(buf, int64(val.Primitive))
+	case Int64Type:
+		return wireInt64, appendVarint(buf, int64(val.Primitive))
+	case Uint64Type:
+		return wireUint64, appendUvarint(buf, val.Primitive)
+	case Float64Type:
+		return wireFloat64, appendUint64LE(buf, val.Primitive)
+	case DurationType:
+		return wireDuration, appendVarint(buf, int64(val.Primitive))
+	case StringType:
+		return wireString, append(buf, val.String...)
+	}
+
+	switch x := val.Interface().(type) {
+	case nil:
+		return wireNil, buf
+	case time.Time:
+		_, offset := x.Zone()
+		buf = appendVarint(buf, x.Unix())
+		buf = appendUvarint(buf, uint64(x.Nanosecond()))
+		buf = appendVarint(buf, int64(offset))
+		return wireTimestamp, buf
+	case json.Marshaler:
+		// errors with custom JSON encoding are stored as wireJSON below
+	case error:
+		return wireError, append(buf, x.Error()...)
+	}
+
+	ifc := val.Interface()
+	b, err := json.Marshal(ifc)
+	if err != nil {
+		return wireString, append(buf, fmt.Sprint(ifc)...)
+	}
+	return wireJSON, append(buf, b...)
+}
+
+// UnmarshalBinary decodes a context encoded by MarshalBinary and adds all
+// decoded fields to the context. Fields of unknown wire types are ignored.
+// A zero Context is initialized to report all fields.
+func (c *Context) UnmarshalBinary(data []byte) error {
+	if len(data) < 3 || data[0] != wireMagic0 || data[1] != wireMagic1 {
+		return errWireMagic
+	}
+	if data[2] != wireVersion {
+		return fmt.Errorf("diag: unsupported binary encoding version %v", data[2])
+	}
+
+	d := wireDecoder{data: data[3:]}
+
+	n, err := d.count()
+	if err != nil {
+		return err
+	}
+	dict := make([]string, n)
+	for i := range dict {
+		b, err := d.bytes()
+		if err != nil {
+			return err
+		}
+		dict[i] = string(b)
+	}
+
+	n, err = d.count()
+	if err != nil {
+		return err
+	}
+	fields := make([]Field, 0, n)
+	var sb strings.Builder
+	for i := 0; i < n; i++ {
+		segs, err := d.count()
+		if err != nil {
+			return err
+		}
+		if segs == 0 {
+			return errWireKey
+		}
+
+		sb.Reset()
+		for j := 0; j < segs; j++ {
+			idx, err := d.uvarint()
+			if err != nil {
+				return err
+			}
+			if idx >= uint64(len(dict)) {
+				return errWireKey
+			}
+			if j > 0 {
+				sb.WriteByte('.')
+			}
+			sb.WriteString(dict[idx])
+		}
+
+		tag, err := d.byte()
+		if err != nil {
+			return err
+		}
+		payload, err := d.bytes()
+		if err != nil {
+			return err
+		}
+
+		val, known, err := decodeWireValue(tag&wireTypeMask, payload)
+		if err != nil {
+			return err
+		}
+		if !known {
+			continue
+		}
+
+		fields = append(fields, Field{
+			Key:          sb.String(),
+			Value:        val,
+			Standardized: tag&wireStdFlag != 0,
+		})
+	}
+
+	if len(d.data) != 0 {
+		return errWireValue
+	}
+
+	if c.mode == 0 && c.fields == nil && c.before == nil && c.after == nil {
+		c.mode = allFields
+	}
+	c.AddFields(fields...)
+	return nil
+}
+
+func decodeWireValue(typ byte, payload []byte) (Value, bool, error) {
+	d := wireDecoder{data: payload}
+
+	var val Value
+	switch typ {
+	case wireNil:
+		val = ValAny(nil)
+	case wireBool:
+		b, err := d.byte()
+		if err != nil || b > 1 {
+			return val, true, errWireValue
+		}
+		val = ValBool(b == 1)
+	case wireInt:
+		i, err := d.varint()
+		if err != nil {
+			return val, true, err
+		}
+		val = ValInt(int(i))
+	case wireInt64:
+		i, err := d.varint()
+		if err != nil {
+			return val, true, err
+		}
+		val = ValInt64(i)
+	case wireUint64:
+		u, err := d.uvarint()
+		if err != nil {
+			return val, true, err
+		}
+		val = ValUint64(u)
+	case wireFloat64:
+		if len(payload) != 8 {
+			return val, true, errWireValue
+		}
+		val = ValFloat(math.Float64frombits(binary.LittleEndian.Uint64(payload)))
+		d.data = nil
+	case wireDuration:
+		i, err := d.varint()
+		if err != nil {
+			return val, true, err
+		}
+		val = ValDuration(time.Duration(i))
+	case wireTimestamp:
+		sec, err := d.varint()
+		if err != nil {
+			return val, true, err
+		}
+		nsec, err := d.uvarint()
+		if err != nil || nsec >= uint64(time.Second) {
+			return val, true, errWireValue
+		}
+		offset, err := d.varint()
+		if err != nil || offset < math.MinInt32 || offset > math.MaxInt32 {
+			return val, true, errWireValue
+		}
+
+		ts := time.Unix(sec, int64(nsec)).UTC()
+		if offset != 0 {
+			ts = ts.In(time.FixedZone("", int(offset)))
+		}
+		val = ValTime(ts)
+	case wireString:
+		val = ValString(string(payload))
+		d.data = nil
+	case wireError:
+		val = ValAny(errors.New(string(payload)))
+		d.data = nil
+	case wireJSON:
+		var ifc interface{}
+		if err := json.Unmarshal(payload, &ifc); err != nil {
+			return val, true, errWireValue
+		}
+		val = ValAny(ifc)
+		d.data = nil
+	default:
+		return val, false, nil
+	}
+
+	if len(d.data) != 0 {
+		return val, true, errWireValue
+	}
+	return val, true, nil
+}
+
+// wireDecoder reads primitives from a binary encoded context.
+type wireDecoder struct {
+	data []byte
+}
+
+func (d *wireDecoder) byte() (byte, error) {
+	if len(d.data) == 0 {
+		return 0, errWireTruncated
+	}
+	b := d.data[0]
+	d.data = d.data[1:]
+	return b, nil
+}
+
+func (d *wireDecoder) uvarint() (uint64, error) {
+	u, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		return 0, errWireTruncated
+	}
+	d.data = d.data[n:]
+	return u, nil
+}
+
+func (d *wireDecoder) varint() (int64, error) {
+	i, n := binary.Varint(d.data)
+	if n <= 0 {
+		return 0, errWireTruncated
+	}
+	d.data = d.data[n:]
+	return i, nil
+}
+
+// count reads a number of elements. Every element requires at least one
+// byte, such that counts exceeding the remaining input are rejected before
+// allocating.
+func (d *wireDecoder) count() (int, error) {
+	u, err := d.uvarint()
+	if err != nil {
+		return 0, err
+	}
+	if u > uint64(len(d.data)) {
+		return 0, errWireTruncated
+	}
+	return int(u), nil
+}
+
+func (d *wireDecoder) bytes() ([]byte, error) {
+	u, err := d.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	if u > uint64(len(d.data)) {
+		return nil, errWireTruncated
+	}
+	b := d.data[:u]
+	d.data = d.data[u:]
+	return b, nil
+}
+
+func appendUvarint(buf []byte, u uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], u)
+	return append(buf, tmp[:n]...)
+}
+
+func appendVarint(buf []byte, i int64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(tmp[:], i)
+	return append(buf, tmp[:n]...)
+}
+
+func appendUint64LE(buf []byte, u uint64) []byte {
+	var tmp [8]byte
+	binary.LittleEndian.PutUint64(tmp[:], u)
+	return append(buf, tmp[:]...)
+}
@@ -0,0 +1,65 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+//go:build go1.18
+// +build go1.18
+
+package diag_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/urso/diag"
+)
+
+func FuzzUnmarshalBinary(f *testing.F) {
+	seeds := []*diag.Context{
+		makeCtx(nil, nil),
+		makeCtx(nil, nil, "hello", "world", "n", 42, "f", 1.5, "b", true),
+		makeCtx(nil, nil,
+			diag.Field{Key: "http.request.method", Value: diag.ValString("GET"), Standardized: true},
+			diag.Duration("http.request.duration", time.Second),
+			diag.Timestamp("@timestamp", time.Unix(1, 2)),
+			diag.Any("err", errors.New("oops")),
+			diag.Any("list", []string{"a", "b"})),
+	}
+	for _, ctx := range seeds {
+		data, err := ctx.MarshalBinary()
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var ctx diag.Context
+		if err := ctx.UnmarshalBinary(data); err != nil {
+			return
+		}
+
+		// Re-encoding a decoded context must be stable.
+		first, err := ctx.MarshalBinary()
+		if err != nil {
+			t.Fatalf("failed to encode decoded context: %v", err)
+		}
+
+		var other diag.Context
+		if err := other.UnmarshalBinary(first); err != nil {
+			t.Fatalf("failed to decode re-encoded context: %v", err)
+		}
+		second, err := other.MarshalBinary()
+		if err != nil {
+			t.Fatalf("failed to encode decoded context: %v", err)
+		}
+
+		if !bytes.Equal(first, second) {
+			t.Fatalf("encoding not stable:\n%x\n%x", first, second)
+		}
+	})
+}
@@ -0,0 +1,207 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+
+package diag_test
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/urso/diag"
+)
+
+func TestBinaryRoundtrip(t *testing.T) {
+	ts := time.Date(2020, 2, 3, 4, 5, 6, 7, time.FixedZone("", 3600))
+
+	cases := map[string]struct {
+		in   []diag.Field
+		want map[string]interface{}
+	}{
+		"empty": {},
+		"primitives": {
+			in: []diag.Field{
+				diag.Bool("bool", true),
+				diag.Int("int", -1),
+				diag.Int64("int64", math.MinInt64),
+				diag.Uint64("uint64", math.MaxUint64),
+				diag.Float("float", 1.5),
+				diag.Duration("duration", 3*time.Second),
+				diag.String("string", "hello world"),
+			},
+			want: map[string]interface{}{
+				"bool":     true,
+				"int":      -1,
+				"int64":    int64(math.MinInt64),
+				"uint64":   uint64(math.MaxUint64),
+				"float":    1.5,
+				"duration": 3 * time.Second,
+				"string":   "hello world",
+			},
+		},
+		"timestamp": {
+			in:   []diag.Field{diag.Timestamp("@timestamp", ts)},
+			want: map[string]interface{}{"@timestamp": ts},
+		},
+		"any values": {
+			in: []diag.Field{
+				diag.Any("nil", nil),
+				diag.Any("list", []int{1, 2}),
+				diag.Any("obj", map[string]string{"a": "b"}),
+			},
+			want: map[string]interface{}{
+				"nil":  nil,
+				"list": []interface{}{1.0, 2.0},
+				"obj":  map[string]interface{}{"a": "b"},
+			},
+		},
+		"nested keys share segments": {
+			in: []diag.Field{
+				diag.String("http.request.method", "GET"),
+				diag.Int("http.response.status", 200),
+			},
+			want: map[string]interface{}{
+				"http.request.method":  "GET",
+				"http.response.status": 200,
+			},
+		},
+		"shadowed fields are not encoded": {
+			in: []diag.Field{
+				diag.Int("key", 1),
+				diag.Int("key", 2),
+			},
+			want: map[string]interface{}{"key": 2},
+		},
+	}
+
+	for name, test := range cases {
+		t.Run(name, func(t *testing.T) {
+			ctx := makeCtx(nil, nil)
+			ctx.AddFields(test.in...)
+
+			data, err := ctx.MarshalBinary()
+			requireNoError(t, err)
+
+			var got diag.Context
+			requireNoError(t, got.UnmarshalBinary(data))
+			assertFlatCtx(t, test.want, &got)
+		})
+	}
+}
+
+func TestBinaryRoundtripTypes(t *testing.T) {
+	ctx := makeCtx(nil, nil,
+		diag.Int("int", 1),
+		diag.Int64("int64", 1),
+		diag.Duration("duration", 1),
+		diag.Any("err", errors.New("oops")))
+
+	data, err := ctx.MarshalBinary()
+	requireNoError(t, err)
+
+	got := diag.NewContext(nil, nil)
+	requireNoError(t, got.UnmarshalBinary(data))
+
+	types := map[string]diag.Type{}
+	var errMsg string
+	got.VisitKeyValues(visitorFunc(func(key string, val diag.Value) error {
+		types[key] = val.Reporter.Type()
+		if err, ok := val.Interface().(error); ok {
+			errMsg = err.Error()
+		}
+		return nil
+	}))
+
+	requireEqual(t, map[string]diag.Type{
+		"int":      diag.IntType,
+		"int64":    diag.Int64Type,
+		"duration": diag.DurationType,
+		"err":      diag.IfcType,
+	}, types)
+	requireEqual(t, "oops", errMsg)
+}
+
+func TestBinaryStandardized(t *testing.T) {
+	ctx := makeCtx(
+		makeCtx(nil, nil, diag.Field{Key: "std_before", Value: diag.ValInt(1), Standardized: true}),
+		nil,
+		diag.String("user", "test"),
+		diag.Field{Key: "std_local", Value: diag.ValInt(2), Standardized: true})
+
+	data, err := ctx.MarshalBinary()
+	requireNoError(t, err)
+
+	var got diag.Context
+	requireNoError(t, got.UnmarshalBinary(data))
+	assertFlatCtx(t, map[string]interface{}{
+		"std_before": 1,
+		"std_local":  2,
+	}, got.Standardized())
+	assertFlatCtx(t, map[string]interface{}{
+		"user": "test",
+	}, got.User())
+}
+
+func TestBinarySkipUnknownTypes(t *testing.T) {
+	data := []byte{
+		'd', 'g', 1,
+		2, // dictionary
+		3, 'n', 'e', 'w',
+		3, 'o', 'l', 'd',
+		2,    // fields
+		1, 0, // key 'new'
+		0x7f,       // unknown type
+		3, 1, 2, 3, // payload
+		1, 1, // key 'old'
+		8,           // string
+		2, 'o', 'k', // payload
+	}
+
+	var got diag.Context
+	requireNoError(t, got.UnmarshalBinary(data))
+	assertFlatCtx(t, map[string]interface{}{"old": "ok"}, &got)
+}
+
+func TestBinaryInvalid(t *testing.T) {
+	valid, err := makeCtx(nil, nil, "key", "value", "n", 1).MarshalBinary()
+	requireNoError(t, err)
+
+	cases := map[string][]byte{
+		"empty":           nil,
+		"bad magic":       []byte("xx\x01"),
+		"unknown version": []byte("dg\x02"),
+		"trailing bytes":  append(append([]byte{}, valid...), 0),
+		"key out of range": {
+			'd', 'g', 1, 0, 1, 1, 0, 8, 0,
+		},
+		"invalid bool": {
+			'd', 'g', 1, 1, 1, 'b', 1, 1, 0, 1, 1, 2,
+		},
+		"huge count": {
+			'd', 'g', 1, 0xff, 0xff, 0xff, 0xff, 0x0f,
+		},
+	}
+	for i := 1; i < len(valid); i++ {
+		cases[fmt.Sprintf("truncated at %v", i)] = valid[:i]
+	}
+
+	for name, data := range cases {
+		t.Run(name, func(t *testing.T) {
+			var ctx diag.Context
+			if err := ctx.UnmarshalBinary(data); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+type visitorFunc func(key string, val diag.Value) error
+
+func (visitorFunc) OnObjStart(_ string) error                   { return nil }
+func (visitorFunc) OnObjEnd() error                             { return nil }
+func (fn visitorFunc) OnValue(key string, val diag.Value) error { return fn(key, val) }